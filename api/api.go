@@ -0,0 +1,229 @@
+// Package api exposes a kademlia node over HTTP so that other services
+// can store and fetch objects without going through the interactive CLI.
+package api
+
+import (
+	"crypto/ecdh"
+	"d7024e/files"
+	"d7024e/kademlia"
+	"d7024e/secret"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// healthTimeout is how long /healthz waits for the action loop
+const healthTimeout = 2 * time.Second
+
+// maxObjectSize is the largest body POST /objects accepts, larger ones
+// are refused before they are read into memory
+const maxObjectSize = 64 << 20
+
+// Server serves the REST API of a single kademlia node
+type Server struct {
+	kademlia      *kademlia.Kademlia
+	mux           *http.ServeMux
+	minContacts   int
+	maxObjectSize int64
+}
+
+// NewServer returns a new instance of a Server for kademliaInstance
+func NewServer(kademliaInstance *kademlia.Kademlia) *Server {
+	server := &Server{kademlia: kademliaInstance, mux: http.NewServeMux(), minContacts: 1, maxObjectSize: maxObjectSize}
+	server.mux.HandleFunc("POST /objects", server.handlePostObject)
+	server.mux.HandleFunc("GET /objects/{hash}", server.handleGetObject)
+	server.mux.HandleFunc("GET /objects/{hash}/trace", server.handleTraceObject)
+	server.mux.HandleFunc("GET /healthz", server.handleHealthz)
+	server.mux.HandleFunc("GET /readyz", server.handleReadyz)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(kademliaInstance.MetricsCollector(), collectors.NewGoCollector())
+	server.mux.Handle("GET /metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	return server
+}
+
+// SetMinReadyContacts sets how many live contacts /readyz requires
+func (server *Server) SetMinReadyContacts(minContacts int) {
+	server.minContacts = minContacts
+}
+
+// ServeHTTP lets the Server be used as an http.Handler
+func (server *Server) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	server.mux.ServeHTTP(writer, request)
+}
+
+// ListenAndServe serves the API on addr until the listener fails
+func (server *Server) ListenAndServe(addr string) error {
+	fmt.Println("HTTP API listening on", addr)
+	return http.ListenAndServe(addr, server)
+}
+
+// handlePostObject stores the request body the way the CLI stores a file,
+// chunked if it is larger than one value, and points the Location header
+// at the new object. The X-Values header holds how many values it was
+// stored as. The hash function can be picked with ?hash=sha2-256.
+// With ?encrypt=true the body is encrypted with a new key and the
+// X-Capability header holds the URI that reads it back, with
+// ?recipient=<hex X25519 public key> it is encrypted for that recipient
+// and X-Capability names the recipient instead of holding a key.
+func (server *Server) handlePostObject(writer http.ResponseWriter, request *http.Request) {
+	hashFunc := kademlia.DefaultHashFunc
+	if name := request.URL.Query().Get("hash"); name != "" {
+		var err error
+		if hashFunc, err = kademlia.ParseHashFunc(name); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	encrypt, recipient, err := parseEncryption(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, server.maxObjectSize))
+	if err != nil {
+		http.Error(writer, "object is larger than the maximum object size", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var capability secret.Capability
+	var result files.PutResult
+	switch {
+	case encrypt:
+		capability, result, err = secret.Put(server.kademlia, data, hashFunc, nil)
+	case recipient != nil:
+		capability, result, err = secret.PutFor(server.kademlia, recipient, data, hashFunc, nil)
+	default:
+		result, err = files.Put(server.kademlia, data, hashFunc, nil)
+	}
+	switch {
+	case errors.Is(err, kademlia.ErrStoreQuorum):
+		http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if encrypt || recipient != nil {
+		writer.Header().Set("X-Capability", capability.String())
+	}
+	writer.Header().Set("Location", "/objects/"+result.Key.String())
+	writer.Header().Set("X-Delete-Token", fmt.Sprintf("%x", result.DeleteToken))
+	writer.Header().Set("X-Values", strconv.Itoa(result.Chunks))
+	writer.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(writer, result.Key.String())
+}
+
+// parseEncryption reads the ?encrypt and ?recipient parameters of a POST
+func parseEncryption(request *http.Request) (bool, *ecdh.PublicKey, error) {
+	query := request.URL.Query()
+	encrypt := false
+	if value := query.Get("encrypt"); value != "" {
+		var err error
+		if encrypt, err = strconv.ParseBool(value); err != nil {
+			return false, nil, fmt.Errorf("invalid encrypt parameter: %v", err)
+		}
+	}
+	if value := query.Get("recipient"); value != "" {
+		if encrypt {
+			return false, nil, fmt.Errorf("encrypt and recipient cannot be combined")
+		}
+		publicKey, err := hex.DecodeString(value)
+		if err != nil {
+			return false, nil, fmt.Errorf("invalid recipient public key: %v", err)
+		}
+		recipient, err := secret.ParseRecipient(publicKey)
+		return false, recipient, err
+	}
+	return encrypt, nil, nil
+}
+
+// handleGetObject looks up the object under the hash in the path the way
+// the CLI fetches a file, following its manifest if it was chunked. With the
+// key of a capability URI, or the private key of its recipient, in the
+// X-Decryption-Key header it is decrypted before it is returned. Keys are
+// refused in the query string, which ends up in access logs.
+func (server *Server) handleGetObject(writer http.ResponseWriter, request *http.Request) {
+	key, err := kademlia.ParseKey(request.PathValue("hash"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if request.URL.Query().Has("key") {
+		http.Error(writer, "pass the key in the X-Decryption-Key header", http.StatusBadRequest)
+		return
+	}
+	var decryptionKey []byte
+	if value := request.Header.Get("X-Decryption-Key"); value != "" {
+		if decryptionKey, err = base64.RawURLEncoding.DecodeString(value); err != nil || len(decryptionKey) != secret.KeySize {
+			http.Error(writer, secret.ErrInvalidKey.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	data, _, err := files.Get(server.kademlia, key, nil)
+	switch {
+	case errors.Is(err, kademlia.ErrNotFound):
+		http.Error(writer, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if decryptionKey != nil {
+		if data, err = secret.Open(decryptionKey, data); err != nil {
+			http.Error(writer, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	writer.Header().Set("Content-Type", "application/octet-stream")
+	writer.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	writer.Write(data)
+}
+
+// handleTraceObject looks up the object under the hash in the path and
+// returns the trace of the lookup as JSON instead of the object
+func (server *Server) handleTraceObject(writer http.ResponseWriter, request *http.Request) {
+	key, err := kademlia.ParseKey(request.PathValue("hash"))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	targetContact := kademlia.NewContact(key.KademliaID(server.kademlia.RoutingTable.IDLength()), "")
+	_, _, _, trace := server.kademlia.TraceLookup(&targetContact, key.String())
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(trace)
+}
+
+// handleHealthz answers 200 while the node can receive and process messages
+func (server *Server) handleHealthz(writer http.ResponseWriter, request *http.Request) {
+	if err := server.kademlia.CheckHealth(healthTimeout); err != nil {
+		http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(writer, "ok")
+}
+
+// handleReadyz answers 200 once the node has joined the network
+func (server *Server) handleReadyz(writer http.ResponseWriter, request *http.Request) {
+	if err := server.kademlia.CheckReady(server.minContacts); err != nil {
+		http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(writer, "ok")
+}
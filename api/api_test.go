@@ -0,0 +1,334 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"d7024e/kademlia"
+	"d7024e/kademlia/kademliatest"
+	"d7024e/secret"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer starts two connected nodes and serves the API of the first
+func newTestServer(t *testing.T) *httptest.Server {
+	node, other := kademliatest.NewNode(t), kademliatest.NewNode(t)
+	node.RoutingTable.AddContact(other.RoutingTable.Me)
+	other.RoutingTable.AddContact(node.RoutingTable.Me)
+
+	server := httptest.NewServer(NewServer(node))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPostObject_ThenGetObject(t *testing.T) {
+	server := newTestServer(t)
+
+	response, err := http.Post(server.URL+"/objects?hash=sha2-256", "text/plain", strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", response.StatusCode)
+	}
+	location := response.Header.Get("Location")
+	if !strings.HasPrefix(location, "/objects/1220") {
+		t.Fatalf("Expected Location of a sha2-256 key, got '%s'", location)
+	}
+	if response.Header.Get("X-Delete-Token") == "" {
+		t.Error("Expected a delete token header")
+	}
+
+	response, err = http.Get(server.URL + location)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || string(body) != "hello world" {
+		t.Errorf("Expected 200 'hello world', got %d '%s'", response.StatusCode, body)
+	}
+}
+
+func TestGetObject_NotFound(t *testing.T) {
+	server := newTestServer(t)
+
+	response, err := http.Get(server.URL + "/objects/a94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", response.StatusCode)
+	}
+}
+
+func TestGetObject_InvalidHash(t *testing.T) {
+	server := newTestServer(t)
+
+	response, err := http.Get(server.URL + "/objects/not-a-hash")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.StatusCode)
+	}
+}
+
+func TestPostObject_UnknownHashFunction(t *testing.T) {
+	server := newTestServer(t)
+
+	response, err := http.Post(server.URL+"/objects?hash=md5", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", response.StatusCode)
+	}
+}
+
+func TestPostObject_TooLarge(t *testing.T) {
+	node := kademliatest.NewNode(t)
+	apiServer := NewServer(node)
+	apiServer.maxObjectSize = 10
+	server := httptest.NewServer(apiServer)
+	defer server.Close()
+
+	response, err := http.Post(server.URL+"/objects", "text/plain", strings.NewReader("more than ten bytes"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413, got %d", response.StatusCode)
+	}
+}
+
+func TestPostObject_LargerThanOneValue(t *testing.T) {
+	server := newTestServer(t)
+	data := make([]byte, 3*kademlia.MaxValueSize+17)
+	rand.Read(data)
+
+	response, err := http.Post(server.URL+"/objects", "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", response.StatusCode)
+	}
+	if values := response.Header.Get("X-Values"); values != "5" {
+		t.Errorf("Expected 4 chunks and a manifest, got %s values", values)
+	}
+
+	response, err = http.Get(server.URL + response.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	if response.StatusCode != http.StatusOK || !bytes.Equal(body, data) {
+		t.Errorf("Expected 200 and the posted %d bytes, got %d and %d bytes", len(data), response.StatusCode, len(body))
+	}
+}
+
+func TestPostObject_QuorumFailure(t *testing.T) {
+	server := httptest.NewServer(NewServer(kademliatest.NewNode(t)))
+	defer server.Close()
+
+	response, err := http.Post(server.URL+"/objects", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", response.StatusCode)
+	}
+}
+
+func TestMetrics_ExportsNodeMetrics(t *testing.T) {
+	server := newTestServer(t)
+
+	response, err := http.Post(server.URL+"/objects", "text/plain", strings.NewReader("hello metrics"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	response.Body.Close()
+
+	response, err = http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(response.Body)
+	for _, metric := range []string{
+		`kademlia_rpc_requests_total{direction="sent",type="STORE"}`,
+		`kademlia_routing_table_contacts{bucket=`,
+		"kademlia_lookup_hops_count",
+		"kademlia_stored_keys 1",
+	} {
+		if !strings.Contains(string(body), metric) {
+			t.Errorf("Expected metrics to contain %s", metric)
+		}
+	}
+}
+
+func TestTraceObject_ReturnsJSON(t *testing.T) {
+	server := newTestServer(t)
+
+	response, err := http.Get(server.URL + "/objects/a94a8fe5ccb19ba61c4c0873d391e987982fbbd3/trace")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer response.Body.Close()
+	var trace kademlia.LookupTrace
+	if err := json.NewDecoder(response.Body).Decode(&trace); err != nil {
+		t.Fatalf("Expected a JSON trace, got %v", err)
+	}
+	if len(trace.Rounds) == 0 || trace.Termination == "" {
+		t.Errorf("Expected the trace to have rounds and a termination reason, got %+v", trace)
+	}
+}
+
+func TestHealthz_AndReadyz(t *testing.T) {
+	server := newTestServer(t)
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		var response *http.Response
+		var err error
+		// the listener of the node may still be starting
+		for attempt := 0; attempt < 10; attempt++ {
+			if response, err = http.Get(server.URL + path); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			response.Body.Close()
+			if response.StatusCode == http.StatusOK {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("Expected %s to answer 200, got %d", path, response.StatusCode)
+		}
+	}
+}
+
+func TestReadyz_NotJoined(t *testing.T) {
+	server := httptest.NewServer(NewServer(kademliatest.NewNode(t)))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/readyz")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", response.StatusCode)
+	}
+}
+
+func TestPostObject_Encrypted(t *testing.T) {
+	server := newTestServer(t)
+
+	response, err := http.Post(server.URL+"/objects?encrypt=true", "text/plain", strings.NewReader("private value"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", response.StatusCode)
+	}
+	capability, err := secret.ParseCapability(response.Header.Get("X-Capability"))
+	if err != nil {
+		t.Fatalf("Expected a capability header, got %v", err)
+	}
+	location := response.Header.Get("Location")
+
+	response, _ = http.Get(server.URL + location)
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if strings.Contains(string(body), "private value") {
+		t.Error("Expected ciphertext without the key")
+	}
+
+	response = getWithKey(t, server.URL+location, capability.Secret)
+	body, _ = io.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || string(body) != "private value" {
+		t.Errorf("Expected private value with the key, got %d '%s'", response.StatusCode, body)
+	}
+
+	otherKey, _ := secret.NewKey()
+	response = getWithKey(t, server.URL+location, otherKey)
+	response.Body.Close()
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status 403 for the wrong key, got %d", response.StatusCode)
+	}
+
+	response, _ = http.Get(server.URL + location + "?" + strings.SplitN(capability.String(), "?", 2)[1])
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a key in the query string, got %d", response.StatusCode)
+	}
+}
+
+// getWithKey fetches url with key in the X-Decryption-Key header
+func getWithKey(t *testing.T, url string, key []byte) *http.Response {
+	request, _ := http.NewRequest(http.MethodGet, url, nil)
+	request.Header.Set("X-Decryption-Key", base64.RawURLEncoding.EncodeToString(key))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return response
+}
+
+func TestPostObject_ForRecipient(t *testing.T) {
+	server := newTestServer(t)
+	recipient, _ := secret.NewRecipientKey()
+
+	response, err := http.Post(server.URL+"/objects?recipient="+hex.EncodeToString(recipient.PublicKey().Bytes()), "text/plain", strings.NewReader("for you"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", response.StatusCode)
+	}
+	capability, err := secret.ParseCapability(response.Header.Get("X-Capability"))
+	if err != nil || capability.Secret != nil || !bytes.Equal(capability.Recipient, recipient.PublicKey().Bytes()) {
+		t.Fatalf("Expected a capability naming the recipient, got %q (%v)", response.Header.Get("X-Capability"), err)
+	}
+
+	response = getWithKey(t, server.URL+response.Header.Get("Location"), recipient.Bytes())
+	body, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if string(body) != "for you" {
+		t.Errorf("Expected for you, got %d '%s'", response.StatusCode, body)
+	}
+}
+
+func TestPostObject_InvalidEncryption(t *testing.T) {
+	server := newTestServer(t)
+
+	for _, query := range []string{"encrypt=maybe", "recipient=zz", "recipient=00", "encrypt=true&recipient=00"} {
+		response, err := http.Post(server.URL+"/objects?"+query, "text/plain", strings.NewReader("data"))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", query, response.StatusCode)
+		}
+	}
+}
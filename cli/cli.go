@@ -2,20 +2,31 @@ package cli
 
 import (
 	"bufio"
-	"crypto/sha1"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"d7024e/files"
 	"d7024e/kademlia"
+	"d7024e/secret"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strings"
 	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
 type CLI struct {
 	kademlia *kademlia.Kademlia
 	reader   io.Reader
+	input    *bufio.Reader
 	writer   io.Writer
+	hashFunc kademlia.HashFunc
+	erasure  *files.ErasureCoding
+
+	signingKey ed25519.PrivateKey
 }
 
 func NewCLI(kademliaInstance *kademlia.Kademlia) *CLI {
@@ -23,13 +34,13 @@ func NewCLI(kademliaInstance *kademlia.Kademlia) *CLI {
 		kademlia: kademliaInstance,
 		reader:   os.Stdin,
 		writer:   os.Stdout,
+		hashFunc: kademlia.DefaultHashFunc,
 	}
 }
 
 func (cli *CLI) CommandLineInterface() (string, string, error) {
-	consoleReader := bufio.NewReader(cli.reader)
 	fmt.Fprint(cli.writer, ">>>")
-	input, err := consoleReader.ReadString('\n')
+	input, err := cli.consoleReader().ReadString('\n')
 	if err != nil {
 		return "", "", fmt.Errorf("error reading input: %w", err)
 	}
@@ -45,6 +56,15 @@ func (cli *CLI) CommandLineInterface() (string, string, error) {
 	return strings.ToUpper(command), arg, nil
 }
 
+// consoleReader returns the buffered reader of the input of the CLI, the
+// same one for commands and for the values PUT -f - reads
+func (cli *CLI) consoleReader() *bufio.Reader {
+	if cli.input == nil {
+		cli.input = bufio.NewReader(cli.reader)
+	}
+	return cli.input
+}
+
 func (cli *CLI) CliHandler() bool {
 	for {
 		command, arg, err := cli.CommandLineInterface()
@@ -66,6 +86,24 @@ func (cli *CLI) commandHandler(command, arg string) bool {
 		cli.handleGet(arg)
 	case "PUT":
 		cli.handlePut(arg)
+	case "DELETE":
+		cli.handleDelete(arg)
+	case "TRACE":
+		cli.handleTrace(arg)
+	case "HASH":
+		cli.handleHash(arg)
+	case "ERASURE":
+		cli.handleErasure(arg)
+	case "KEYPAIR":
+		cli.handleKeypair(arg)
+	case "PROVIDE":
+		cli.handleProvide(arg)
+	case "PROVIDERS":
+		cli.handleProviders(arg)
+	case "MPUT":
+		cli.handleMutablePut(arg)
+	case "MGET":
+		cli.handleMutableGet(arg)
 	case "EXIT":
 		fmt.Fprintln(cli.writer, "Exiting program.")
 		return true
@@ -78,14 +116,74 @@ func (cli *CLI) commandHandler(command, arg string) bool {
 }
 
 func (cli *CLI) handleGet(arg string) {
+	arg, outputPath := cutOption(arg, "-o")
+	hash, keyPath := cutOption(arg, "-k")
+	hash = strings.TrimSpace(hash)
+	if secret.IsCapability(hash) {
+		cli.handleGetSealed(hash, keyPath, outputPath)
+		return
+	}
+	if err := cli.ValidateArguments(hash); err != nil {
+		fmt.Fprintln(cli.writer, err)
+		return
+	}
+
+	key, _ := kademlia.ParseKey(hash)
+	if outputPath != "" {
+		cli.handleDownload(key, outputPath)
+		return
+	}
+	foundData, foundOnContact, err := files.Get(cli.kademlia, key, nil)
+	if err != nil && !errors.Is(err, kademlia.ErrNotFound) {
+		fmt.Fprintln(cli.writer, "error:", err)
+		return
+	}
+	cli.HandleLookupResult(foundOnContact, foundData)
+}
+
+// handleDownload fetches the file under key to path, resuming a previous
+// download to the same path
+func (cli *CLI) handleDownload(key kademlia.Multihash, path string) {
+	size, _, err := files.Download(cli.kademlia, key, path, cli.progress("Fetching"))
+	if errors.Is(err, kademlia.ErrNotFound) {
+		fmt.Fprintln(cli.writer, "Data not found.")
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(cli.writer, "error:", err)
+		return
+	}
+	fmt.Fprintf(cli.writer, "Wrote %d bytes to %s\n", size, path)
+}
+
+func (cli *CLI) handleTrace(arg string) {
 	if err := cli.ValidateArguments(arg); err != nil {
 		fmt.Fprintln(cli.writer, err)
 		return
 	}
 
 	targetContact := cli.CreateTargetContact(arg)
-	foundOnContact, foundData := cli.performNodeLookup(targetContact, arg)
-	cli.HandleLookupResult(foundOnContact, foundData)
+	_, _, _, trace := cli.kademlia.TraceLookup(&targetContact, arg)
+	cli.HandleTraceResult(trace)
+}
+
+func (cli *CLI) HandleTraceResult(trace *kademlia.LookupTrace) {
+	for i, round := range trace.Rounds {
+		fmt.Fprintf(cli.writer, "Round %d: queried %d contacts\n", i+1, len(round.Queried))
+		for _, query := range round.Queries {
+			switch {
+			case query.TimedOut:
+				fmt.Fprintf(cli.writer, "  %s timed out after %v\n", query.Contact.Address, query.Latency)
+			case query.Error != "":
+				fmt.Fprintf(cli.writer, "  %s failed after %v: %s\n", query.Contact.Address, query.Latency, query.Error)
+			case query.FoundData:
+				fmt.Fprintf(cli.writer, "  %s returned the data in %v\n", query.Contact.Address, query.Latency)
+			default:
+				fmt.Fprintf(cli.writer, "  %s returned %d contacts in %v\n", query.Contact.Address, len(query.Returned), query.Latency)
+			}
+		}
+	}
+	fmt.Fprintf(cli.writer, "Lookup terminated: %s after %v\n", trace.Termination, trace.Duration)
 }
 
 func (cli *CLI) ValidateArguments(arg string) error {
@@ -93,26 +191,28 @@ func (cli *CLI) ValidateArguments(arg string) error {
 		return fmt.Errorf("error: No argument provided for GET")
 	}
 
-	if len(arg) != 40 { // Kademlia ID length
-		return fmt.Errorf("error: Invalid Kademlia ID length")
+	if _, err := kademlia.ParseKey(arg); err != nil {
+		if len(arg) != 40 { // Kademlia ID length
+			return fmt.Errorf("error: Invalid Kademlia ID length")
+		}
+		return fmt.Errorf("error: %v", err)
 	}
 
 	return nil
 }
 
 func (cli *CLI) CreateTargetContact(arg string) kademlia.Contact {
-	return kademlia.NewContact(kademlia.NewKademliaID(arg), "")
-}
-
-func (cli *CLI) performNodeLookup(targetContact kademlia.Contact, arg string) (kademlia.Contact, []byte) {
-	_, foundOnContact, foundData := cli.kademlia.NodeLookup(&targetContact, arg)
-	return foundOnContact, foundData
+	key, err := kademlia.ParseKey(arg)
+	if err != nil {
+		return kademlia.NewContact(kademlia.NewKademliaID(arg), "")
+	}
+	return kademlia.NewContact(key.KademliaID(cli.idLength()), "")
 }
 
 func (cli *CLI) HandleLookupResult(foundOnContact kademlia.Contact, foundData []byte) {
 	if foundData != nil {
 		fmt.Fprintln(cli.writer, "Data found on contact:", foundOnContact.String())
-		fmt.Fprintln(cli.writer, "Data:", string(foundData))
+		cli.printData(foundData)
 	} else {
 		fmt.Fprintln(cli.writer, "Data not found.")
 	}
@@ -123,12 +223,314 @@ func (cli *CLI) handlePut(arg string) {
 		fmt.Fprintln(cli.writer, err)
 		return
 	}
+	if rest, found := strings.CutPrefix(arg, "-e "); found {
+		cli.handlePutSealed(rest, nil)
+		return
+	}
+	if rest, found := strings.CutPrefix(arg, "-to "); found {
+		publicKey, rest, _ := strings.Cut(rest, " ")
+		recipient, err := parseRecipient(publicKey)
+		if err != nil {
+			fmt.Fprintln(cli.writer, "error:", err)
+			return
+		}
+		cli.handlePutSealed(rest, recipient)
+		return
+	}
+	if path, found := strings.CutPrefix(arg, "-f "); found {
+		cli.handlePutFile(strings.TrimSpace(path))
+		return
+	}
+	if cli.erasure != nil {
+		cli.storeFile([]byte(arg))
+		return
+	}
+
+	result, err := cli.kademlia.PutValue([]byte(arg), cli.getHashFunc())
+	if result.Key == nil {
+		fmt.Fprintln(cli.writer, "error:", err)
+		return
+	}
+	cli.HandleStoreResult(result.Stored, result.Contacts, result.Key.String())
+	if err == nil {
+		fmt.Fprintln(cli.writer, "Delete token: "+hex.EncodeToString(result.DeleteToken))
+	}
+}
+
+// printData prints a value that is text as it is. Other values are
+// printed hex encoded, so that they cannot send control sequences to the
+// terminal.
+func (cli *CLI) printData(data []byte) {
+	if printable(data) {
+		fmt.Fprintln(cli.writer, "Data:", string(data))
+	} else {
+		fmt.Fprintln(cli.writer, "Data (hex, use -o <path> to save it):", hex.EncodeToString(data))
+	}
+}
+
+// printable returns true if data is UTF-8 text without control characters
+// other than newlines and tabs
+func printable(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// readFile returns the contents of the file at path, "-" reads the input
+// of the CLI until it ends
+func (cli *CLI) readFile(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(cli.consoleReader())
+	}
+	return os.ReadFile(path)
+}
+
+// handlePutFile stores the contents of the file at path, chunking it if
+// it is larger than a single value. A path of "-" stores the input of the
+// CLI up to its end.
+func (cli *CLI) handlePutFile(path string) {
+	data, err := cli.readFile(path)
+	if err != nil {
+		fmt.Fprintln(cli.writer, "error:", err)
+		return
+	}
+	cli.storeFile(data)
+}
 
+// storeFile stores data through the files package, erasure coded if an
+// ERASURE layout is set
+func (cli *CLI) storeFile(data []byte) {
+	var result files.PutResult
+	var err error
+	if cli.erasure != nil {
+		result, err = files.PutErasure(cli.kademlia, data, cli.getHashFunc(), cli.erasure.DataShards, cli.erasure.ParityShards, cli.progress("Storing"))
+	} else {
+		result, err = files.Put(cli.kademlia, data, cli.getHashFunc(), cli.progress("Storing"))
+	}
+	if err != nil {
+		fmt.Fprintln(cli.writer, "error:", err)
+		fmt.Fprintln(cli.writer, "Failed to store data.")
+		return
+	}
+	fmt.Fprintf(cli.writer, "Stored %d bytes as %d values. Hash: %s\n", len(data), result.Chunks, result.Key)
+	fmt.Fprintln(cli.writer, "Delete token: "+hex.EncodeToString(result.DeleteToken))
+}
+
+// handlePutSealed encrypts the value, or the file after -f, before it is
+// stored. Without a recipient it is sealed with a new key and the
+// capability that reads it is printed, otherwise it is sealed for the
+// recipient, who reads it with their private key.
+func (cli *CLI) handlePutSealed(arg string, recipient *ecdh.PublicKey) {
 	data := []byte(arg)
-	kadId, targetContact := cli.CreatePutTargetContact(data)
-	contacts := cli.performPutNodeLookup(targetContact)
-	successCount := cli.storeDataOnContacts(kadId, data, contacts)
-	cli.HandleStoreResult(successCount, len(contacts), kadId.String())
+	if path, found := strings.CutPrefix(arg, "-f "); found {
+		var err error
+		if data, err = cli.readFile(strings.TrimSpace(path)); err != nil {
+			fmt.Fprintln(cli.writer, "error:", err)
+			return
+		}
+	}
+	if len(data) == 0 {
+		fmt.Fprintln(cli.writer, "error: No value provided for PUT")
+		return
+	}
+
+	var capability secret.Capability
+	var result files.PutResult
+	var err error
+	if recipient == nil {
+		capability, result, err = secret.Put(cli.kademlia, data, cli.getHashFunc(), cli.progress("Storing"))
+	} else {
+		capability, result, err = secret.PutFor(cli.kademlia, recipient, data, cli.getHashFunc(), cli.progress("Storing"))
+	}
+	if err != nil {
+		fmt.Fprintln(cli.writer, "error:", err)
+		fmt.Fprintln(cli.writer, "Failed to store data.")
+		return
+	}
+	fmt.Fprintf(cli.writer, "Stored %d encrypted bytes as %d values. Hash: %s\n", len(data), result.Chunks, result.Key)
+	fmt.Fprintln(cli.writer, "Capability:", capability)
+	fmt.Fprintln(cli.writer, "Delete token: "+hex.EncodeToString(result.DeleteToken))
+}
+
+// handleGetSealed fetches and decrypts the value a capability URI points
+// at, writing it to outputPath if one is given. The capability of a
+// recipient is opened with the private key in the file at keyPath.
+func (cli *CLI) handleGetSealed(uri string, keyPath string, outputPath string) {
+	capability, err := secret.ParseCapability(uri)
+	if err != nil {
+		fmt.Fprintln(cli.writer, "error:", err)
+		return
+	}
+	var data []byte
+	var foundOn kademlia.Contact
+	if capability.Recipient != nil {
+		if keyPath == "" {
+			fmt.Fprintln(cli.writer, "error: Usage: GET <capability> -k <private key file>")
+			return
+		}
+		privateKey, err := secret.LoadRecipientKey(keyPath)
+		if err != nil {
+			fmt.Fprintln(cli.writer, "error:", err)
+			return
+		}
+		data, foundOn, err = secret.GetFor(cli.kademlia, capability, privateKey, nil)
+	} else {
+		data, foundOn, err = secret.Get(cli.kademlia, capability, nil)
+	}
+	switch {
+	case errors.Is(err, kademlia.ErrNotFound):
+		cli.HandleLookupResult(foundOn, nil)
+	case err != nil:
+		fmt.Fprintln(cli.writer, "error:", err)
+	case outputPath == "":
+		cli.HandleLookupResult(foundOn, data)
+	default:
+		if err := os.WriteFile(outputPath, data, 0644); err != nil {
+			fmt.Fprintln(cli.writer, "error:", err)
+			return
+		}
+		fmt.Fprintf(cli.writer, "Wrote %d bytes to %s\n", len(data), outputPath)
+	}
+}
+
+// handleKeypair creates an X25519 keypair for PUT -to, it writes the
+// private key to the file at path and prints the public key
+func (cli *CLI) handleKeypair(path string) {
+	if path = strings.TrimSpace(path); path == "" {
+		fmt.Fprintln(cli.writer, "error: Usage: KEYPAIR <private key file>")
+		return
+	}
+	privateKey, err := secret.NewRecipientKey()
+	if err != nil {
+		fmt.Fprintln(cli.writer, "error:", err)
+		return
+	}
+	if err := secret.SaveRecipientKey(path, privateKey); err != nil {
+		fmt.Fprintln(cli.writer, "error:", err)
+		return
+	}
+	fmt.Fprintln(cli.writer, "Public key:", hex.EncodeToString(privateKey.PublicKey().Bytes()))
+	fmt.Fprintln(cli.writer, "Private key written to", path)
+	fmt.Fprintf(cli.writer, "Read values stored with PUT -to <public key> using: GET <capability> -k %s\n", path)
+}
+
+// cutOption removes the option name and its value from arg. The value
+// runs until the next option or the end of arg.
+func cutOption(arg string, name string) (string, string) {
+	before, after, found := strings.Cut(arg, " "+name+" ")
+	if !found {
+		return arg, ""
+	}
+	value, rest, found := strings.Cut(after, " -")
+	if found {
+		before += " -" + rest
+	}
+	return before, strings.TrimSpace(value)
+}
+
+// parseRecipient parses the hex X25519 public key given to PUT -to
+func parseRecipient(publicKey string) (*ecdh.PublicKey, error) {
+	decoded, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient public key: %w", err)
+	}
+	return secret.ParseRecipient(decoded)
+}
+
+// progress returns a files.Progress that redraws a progress line
+func (cli *CLI) progress(verb string) files.Progress {
+	return func(done, total int) {
+		fmt.Fprintf(cli.writer, "\r%s: %d/%d chunks", verb, done, total)
+		if done == total {
+			fmt.Fprintln(cli.writer)
+		}
+	}
+}
+
+func (cli *CLI) handleDelete(arg string) {
+	parts := strings.SplitN(arg, " ", 2)
+	if len(parts) != 2 {
+		fmt.Fprintln(cli.writer, "error: Usage: DELETE <hash> <token>")
+		return
+	}
+	if err := cli.ValidateArguments(parts[0]); err != nil {
+		fmt.Fprintln(cli.writer, err)
+		return
+	}
+	token, err := hex.DecodeString(parts[1])
+	if err != nil {
+		fmt.Fprintln(cli.writer, "error: Invalid delete token")
+		return
+	}
+
+	targetContact := cli.CreateTargetContact(parts[0])
+	successCount, totalContacts := cli.kademlia.DeleteValue(targetContact.ID, token)
+	cli.HandleDeleteResult(successCount, totalContacts)
+}
+
+func (cli *CLI) HandleDeleteResult(successCount, totalContacts int) {
+	if successCount > 0 {
+		fmt.Fprintf(cli.writer, "Data deleted from %d of %d nodes.\n", successCount, totalContacts)
+	} else {
+		fmt.Fprintln(cli.writer, "Failed to delete data.")
+	}
+}
+
+func (cli *CLI) handleHash(arg string) {
+	if arg == "" {
+		fmt.Fprintln(cli.writer, "Hash function:", cli.getHashFunc())
+		return
+	}
+
+	hashFunc, err := kademlia.ParseHashFunc(arg)
+	if err != nil {
+		fmt.Fprintln(cli.writer, "error:", err)
+		return
+	}
+	cli.hashFunc = hashFunc
+	fmt.Fprintln(cli.writer, "Hash function set to", hashFunc)
+}
+
+// handleErasure sets the Reed-Solomon layout used by PUT, such as "4+2",
+// or turns erasure coding off with "off"
+func (cli *CLI) handleErasure(arg string) {
+	switch {
+	case arg == "" && cli.erasure == nil:
+		fmt.Fprintln(cli.writer, "Erasure coding: off")
+	case arg == "":
+		fmt.Fprintln(cli.writer, "Erasure coding:", cli.erasure)
+	case strings.EqualFold(arg, "off"):
+		cli.erasure = nil
+		fmt.Fprintln(cli.writer, "Erasure coding turned off")
+	default:
+		coding, err := files.ParseErasure(arg)
+		if err != nil {
+			fmt.Fprintln(cli.writer, "error:", err)
+			return
+		}
+		cli.erasure = coding
+		fmt.Fprintln(cli.writer, "Erasure coding set to", coding)
+	}
+}
+
+func (cli *CLI) idLength() int {
+	if cli.kademlia == nil {
+		return kademlia.IDLength
+	}
+	return cli.kademlia.RoutingTable.IDLength()
+}
+
+func (cli *CLI) getHashFunc() kademlia.HashFunc {
+	if cli.hashFunc == 0 {
+		return kademlia.DefaultHashFunc
+	}
+	return cli.hashFunc
 }
 
 func (cli *CLI) ValidatePutArg(arg string) error {
@@ -138,22 +540,56 @@ func (cli *CLI) ValidatePutArg(arg string) error {
 	return nil
 }
 
+func (cli *CLI) CreatePutKey(data []byte) (kademlia.Multihash, error) {
+	return kademlia.Sum(data, cli.getHashFunc())
+}
+
 func (cli *CLI) CreatePutTargetContact(data []byte) (*kademlia.KademliaID, kademlia.Contact) {
-	hasher := sha1.New()
-	hasher.Write(data)
-	hash := hasher.Sum(nil)
-	hashString := hex.EncodeToString(hash)
-	kadId := kademlia.NewKademliaID(hashString)
+	key, _ := cli.CreatePutKey(data)
+	kadId := key.KademliaID(cli.idLength())
 	targetContact := kademlia.NewContact(kadId, "")
 	return kadId, targetContact
 }
 
-func (cli *CLI) performPutNodeLookup(targetContact kademlia.Contact) []kademlia.Contact {
-	contacts, _, _ := cli.kademlia.NodeLookup(&targetContact, "")
-	return contacts
+func (cli *CLI) HandleStoreResult(successCount, totalContacts int, data string) {
+	if successCount > totalContacts/2 {
+		fmt.Fprintln(cli.writer, "Data stored successfully. Hash: "+data)
+	} else {
+		fmt.Fprintln(cli.writer, "Failed to store data.")
+	}
+}
+
+func (cli *CLI) handleMutablePut(arg string) {
+	parts := strings.SplitN(arg, " ", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		fmt.Fprintln(cli.writer, "error: Usage: MPUT <salt> <value>")
+		return
+	}
+	salt, value := []byte(parts[0]), []byte(parts[1])
+
+	signingKey := cli.signingKey
+	if signingKey == nil {
+		fmt.Fprintln(cli.writer, "error: No key to sign records with")
+		return
+	}
+	publicKey := signingKey.Public().(ed25519.PublicKey)
+	target := kademlia.MutableRecordID(publicKey, salt, cli.idLength())
+
+	current, contacts := cli.kademlia.RecordLookup(target)
+	seq := int64(1)
+	if current != nil {
+		seq = current.Seq + 1
+	}
+	record := kademlia.NewMutableRecord(signingKey, salt, seq, value)
+
+	successCount := cli.storeRecordOnContacts(record, contacts)
+	cli.HandleStoreResult(successCount, len(contacts), target.String())
+	if successCount > len(contacts)/2 {
+		fmt.Fprintf(cli.writer, "Published seq %d. Fetch with: MGET %s %s\n", seq, hex.EncodeToString(publicKey), parts[0])
+	}
 }
 
-func (cli *CLI) storeDataOnContacts(kadId *kademlia.KademliaID, data []byte, contacts []kademlia.Contact) int {
+func (cli *CLI) storeRecordOnContacts(record kademlia.MutableRecord, contacts []kademlia.Contact) int {
 	resultChan := make(chan bool, len(contacts))
 	var wg sync.WaitGroup
 
@@ -161,16 +597,11 @@ func (cli *CLI) storeDataOnContacts(kadId *kademlia.KademliaID, data []byte, con
 		wg.Add(1)
 		go func(contact kademlia.Contact) {
 			defer wg.Done()
-			result := cli.kademlia.Network.SendStoreMessage(&cli.kademlia.RoutingTable.Me, &contact, kadId, data)
-			fmt.Fprintln(cli.writer, "Storing data with key:", kadId.String(), "on contact:", contact.String())
-			resultChan <- result
+			resultChan <- cli.kademlia.Network.SendStoreRecordMessage(&cli.kademlia.RoutingTable.Me, &contact, record)
 		}(contact)
 	}
-
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
+	wg.Wait()
+	close(resultChan)
 
 	successCount := 0
 	for success := range resultChan {
@@ -181,10 +612,70 @@ func (cli *CLI) storeDataOnContacts(kadId *kademlia.KademliaID, data []byte, con
 	return successCount
 }
 
-func (cli *CLI) HandleStoreResult(successCount, totalContacts int, data string) {
+func (cli *CLI) handleMutableGet(arg string) {
+	parts := strings.SplitN(arg, " ", 2)
+	if len(parts) != 2 {
+		fmt.Fprintln(cli.writer, "error: Usage: MGET <public key> <salt>")
+		return
+	}
+	publicKey, err := hex.DecodeString(parts[0])
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		fmt.Fprintln(cli.writer, "error: Invalid public key")
+		return
+	}
+
+	target := kademlia.MutableRecordID(publicKey, []byte(parts[1]), cli.idLength())
+	record, _ := cli.kademlia.RecordLookup(target)
+	cli.HandleRecordResult(record)
+}
+
+func (cli *CLI) HandleRecordResult(record *kademlia.MutableRecord) {
+	if record != nil {
+		fmt.Fprintln(cli.writer, "Record seq:", record.Seq)
+		cli.printData(record.Value)
+	} else {
+		fmt.Fprintln(cli.writer, "Record not found.")
+	}
+}
+
+// SetSigningKey sets the key MPUT signs records with. It must be kept
+// across restarts, the records it published can only be updated with it.
+func (cli *CLI) SetSigningKey(signingKey ed25519.PrivateKey) {
+	cli.signingKey = signingKey
+}
+
+func (cli *CLI) handleProvide(arg string) {
+	if err := cli.ValidateArguments(arg); err != nil {
+		fmt.Fprintln(cli.writer, err)
+		return
+	}
+
+	targetContact := cli.CreateTargetContact(arg)
+	successCount, totalContacts := cli.kademlia.Provide(targetContact.ID)
 	if successCount > totalContacts/2 {
-		fmt.Fprintln(cli.writer, "Data stored successfully. Hash: "+data)
+		fmt.Fprintln(cli.writer, "Announced as provider of", arg, "on", successCount, "nodes.")
 	} else {
-		fmt.Fprintln(cli.writer, "Failed to store data.")
+		fmt.Fprintln(cli.writer, "Failed to announce provider.")
+	}
+}
+
+func (cli *CLI) handleProviders(arg string) {
+	if err := cli.ValidateArguments(arg); err != nil {
+		fmt.Fprintln(cli.writer, err)
+		return
 	}
-}
\ No newline at end of file
+
+	targetContact := cli.CreateTargetContact(arg)
+	providers := cli.kademlia.ProviderLookup(targetContact.ID)
+	cli.HandleProvidersResult(providers)
+}
+
+func (cli *CLI) HandleProvidersResult(providers []kademlia.Contact) {
+	if len(providers) == 0 {
+		fmt.Fprintln(cli.writer, "No providers found.")
+		return
+	}
+	for _, provider := range providers {
+		fmt.Fprintln(cli.writer, "Provider:", provider.String())
+	}
+}
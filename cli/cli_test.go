@@ -1,11 +1,17 @@
 package cli
 
 import (
+	"crypto/ed25519"
 	"d7024e/kademlia"
+	"d7024e/secret"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewCLI_ReturnsCLIInstance(t *testing.T) {
@@ -102,6 +108,21 @@ func TestHandleLookupResult_DataFound(t *testing.T) {
 	}
 }
 
+func TestHandleLookupResult_NotPrintableData(t *testing.T) {
+	contact := kademlia.NewContact(kademlia.NewKademliaID("a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"), "127.0.0.1")
+	for _, data := range [][]byte{{0xff, 0xfe, 0x00}, []byte("\x1b]0;title\x07")} {
+		writer := &strings.Builder{}
+		cli := &CLI{writer: writer}
+
+		cli.HandleLookupResult(contact, data)
+
+		expectedOutput := "Data found on contact: " + contact.String() + "\nData (hex, use -o <path> to save it): " + hex.EncodeToString(data) + "\n"
+		if writer.String() != expectedOutput {
+			t.Errorf("Expected output to be %q, got %q", expectedOutput, writer.String())
+		}
+	}
+}
+
 func TestHandleLookupResult_DataNotFound(t *testing.T) {
 	writer := &strings.Builder{}
 	cli := &CLI{writer: writer}
@@ -265,4 +286,345 @@ func TestHandleStoreResult_ExactHalfSuccess(t *testing.T) {
 	if writer.String() != expectedOutput {
 		t.Errorf("Expected output to be '%s', got '%s'", expectedOutput, writer.String())
 	}
-}
\ No newline at end of file
+}
+
+func TestHandleHash_SelectsFunction(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	cli.handleHash("sha2-256")
+
+	if cli.getHashFunc() != kademlia.SHA2_256 {
+		t.Errorf("Expected hash function sha2-256, got %s", cli.getHashFunc())
+	}
+	key, _ := cli.CreatePutKey([]byte(""))
+	expectedKey := "1220e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if key.String() != expectedKey {
+		t.Errorf("Expected key '%s', got '%s'", expectedKey, key.String())
+	}
+}
+
+func TestHandleHash_UnknownFunction(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	cli.handleHash("md5")
+
+	if !strings.Contains(writer.String(), "unknown hash function") {
+		t.Errorf("Expected unknown hash function error, got '%s'", writer.String())
+	}
+	if cli.getHashFunc() != kademlia.DefaultHashFunc {
+		t.Errorf("Expected default hash function, got %s", cli.getHashFunc())
+	}
+}
+
+func TestValidateArguments_AcceptsMultihash(t *testing.T) {
+	cli := &CLI{}
+	key, _ := kademlia.Sum([]byte("some data"), kademlia.BLAKE3)
+
+	if err := cli.ValidateArguments(key.String()); err != nil {
+		t.Errorf("Expected no error, got '%v'", err)
+	}
+	contact := cli.CreateTargetContact(key.String())
+	if !contact.ID.Equals(key.KademliaID(kademlia.IDLength)) {
+		t.Errorf("Expected contact ID %s, got %s", key.KademliaID(kademlia.IDLength), contact.ID)
+	}
+}
+
+func TestHandleMutablePut_MissingValue(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	cli.handleMutablePut("config")
+
+	if !strings.Contains(writer.String(), "Usage: MPUT <salt> <value>") {
+		t.Errorf("Expected usage error, got '%s'", writer.String())
+	}
+}
+
+func TestHandleMutablePut_MissingSigningKey(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	cli.handleMutablePut("config v1")
+
+	if !strings.Contains(writer.String(), "error: No key to sign records with") {
+		t.Errorf("Expected missing key error, got '%s'", writer.String())
+	}
+}
+
+func TestHandleMutableGet_InvalidPublicKey(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	cli.handleMutableGet("abcd config")
+
+	if !strings.Contains(writer.String(), "error: Invalid public key") {
+		t.Errorf("Expected invalid public key error, got '%s'", writer.String())
+	}
+}
+
+func TestHandleRecordResult(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	_, signingKey, _ := ed25519.GenerateKey(nil)
+	record := kademlia.NewMutableRecord(signingKey, []byte("config"), 4, []byte("v4"))
+	cli.HandleRecordResult(&record)
+	cli.HandleRecordResult(nil)
+
+	expectedOutput := "Record seq: 4\nData: v4\nRecord not found.\n"
+	if writer.String() != expectedOutput {
+		t.Errorf("Expected output to be '%s', got '%s'", expectedOutput, writer.String())
+	}
+}
+
+func TestHandleProvidersResult(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+	contact := kademlia.NewContact(kademlia.NewKademliaID("a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"), "127.0.0.1")
+
+	cli.HandleProvidersResult([]kademlia.Contact{contact})
+	cli.HandleProvidersResult(nil)
+
+	expectedOutput := "Provider: " + contact.String() + "\nNo providers found.\n"
+	if writer.String() != expectedOutput {
+		t.Errorf("Expected output to be '%s', got '%s'", expectedOutput, writer.String())
+	}
+}
+
+func TestHandleDelete_MissingToken(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	cli.handleDelete("a94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+
+	if !strings.Contains(writer.String(), "Usage: DELETE <hash> <token>") {
+		t.Errorf("Expected usage error, got '%s'", writer.String())
+	}
+}
+
+func TestHandleDelete_InvalidToken(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	cli.handleDelete("a94a8fe5ccb19ba61c4c0873d391e987982fbbd3 not-hex")
+
+	if !strings.Contains(writer.String(), "error: Invalid delete token") {
+		t.Errorf("Expected invalid token error, got '%s'", writer.String())
+	}
+}
+
+func TestHandleDeleteResult(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	cli.HandleDeleteResult(3, 5)
+	cli.HandleDeleteResult(0, 5)
+
+	expectedOutput := "Data deleted from 3 of 5 nodes.\nFailed to delete data.\n"
+	if writer.String() != expectedOutput {
+		t.Errorf("Expected output to be '%s', got '%s'", expectedOutput, writer.String())
+	}
+}
+
+func TestHandleTraceResult(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+	contact := kademlia.NewContact(kademlia.NewKademliaID("a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"), "127.0.0.1:8000")
+	trace := &kademlia.LookupTrace{
+		Rounds: []*kademlia.TraceRound{{
+			Queried: []kademlia.Contact{contact, contact},
+			Queries: []kademlia.TraceQuery{
+				{Contact: contact, Latency: time.Millisecond, Returned: []kademlia.Contact{contact}},
+				{Contact: contact, Latency: time.Second, TimedOut: true, Error: "timeout"},
+			},
+		}},
+		Termination: kademlia.TerminationConverged,
+		Duration:    time.Second,
+	}
+
+	cli.HandleTraceResult(trace)
+
+	expectedOutput := "Round 1: queried 2 contacts\n" +
+		"  127.0.0.1:8000 returned 1 contacts in 1ms\n" +
+		"  127.0.0.1:8000 timed out after 1s\n" +
+		"Lookup terminated: no closer contacts found after 1s\n"
+	if writer.String() != expectedOutput {
+		t.Errorf("Expected output to be '%s', got '%s'", expectedOutput, writer.String())
+	}
+}
+
+func TestHandleTrace_InvalidHash(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	cli.handleTrace("")
+
+	if !strings.Contains(writer.String(), "No argument provided") {
+		t.Errorf("Expected missing argument error, got '%s'", writer.String())
+	}
+}
+
+func TestHandleGet_InvalidHashWithOutput(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{kademlia: &kademlia.Kademlia{}, writer: writer}
+
+	cli.handleGet("invalid_length -o out.bin")
+
+	if !strings.Contains(writer.String(), "error: Invalid Kademlia ID length") {
+		t.Errorf("Expected invalid length error, got '%s'", writer.String())
+	}
+}
+
+func TestHandlePut_MissingFile(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	cli.handlePut("-f " + t.TempDir() + "/missing.bin")
+
+	if !strings.Contains(writer.String(), "error:") {
+		t.Errorf("Expected an error for a missing file, got '%s'", writer.String())
+	}
+}
+
+func TestReadFile_Stdin(t *testing.T) {
+	cli := &CLI{reader: strings.NewReader("PUT -f -\nline one\nline two\n")}
+
+	if _, err := cli.consoleReader().ReadString('\n'); err != nil {
+		t.Fatalf("Expected to read the command, got %v", err)
+	}
+	data, err := cli.readFile("-")
+	if err != nil {
+		t.Fatalf("Expected to read the input, got %v", err)
+	}
+	if string(data) != "line one\nline two\n" {
+		t.Errorf("Expected the input after the command, got %q", data)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	progress := cli.progress("Storing")
+	progress(1, 2)
+	progress(2, 2)
+
+	expectedOutput := "\rStoring: 1/2 chunks\rStoring: 2/2 chunks\n"
+	if writer.String() != expectedOutput {
+		t.Errorf("Expected output to be %q, got %q", expectedOutput, writer.String())
+	}
+}
+
+func TestHandleErasure(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	cli.handleErasure("")
+	cli.handleErasure("4+2")
+	cli.handleErasure("")
+	cli.handleErasure("4")
+	cli.handleErasure("off")
+
+	output := writer.String()
+	for _, expected := range []string{"Erasure coding: off\n", "Erasure coding set to 4+2\n", "Erasure coding: 4+2\n", "error: invalid erasure coding", "Erasure coding turned off\n"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected output to contain %q, got %q", expected, output)
+		}
+	}
+	if cli.erasure != nil {
+		t.Errorf("Expected erasure coding to be off, got %v", cli.erasure)
+	}
+}
+
+func TestHandleKeypair(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+	path := filepath.Join(t.TempDir(), "recipient.key")
+
+	cli.handleKeypair(path)
+
+	lines := strings.Split(writer.String(), "\n")
+	publicKey, found := strings.CutPrefix(lines[0], "Public key: ")
+	if !found || len(publicKey) != 64 {
+		t.Errorf("Expected a hex public key, got '%s'", lines[0])
+	}
+	if _, err := parseRecipient(publicKey); err != nil {
+		t.Errorf("Expected the public key to be accepted by PUT -to, got %v", err)
+	}
+	privateKey, err := secret.LoadRecipientKey(path)
+	if err != nil {
+		t.Fatalf("Expected the private key to be written to %s, got %v", path, err)
+	}
+	if strings.Contains(writer.String(), base64.RawURLEncoding.EncodeToString(privateKey.Bytes())) {
+		t.Error("Expected the private key not to be printed")
+	}
+}
+
+func TestHandleKeypair_MissingPath(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	cli.handleKeypair("")
+
+	if !strings.Contains(writer.String(), "error: Usage: KEYPAIR") {
+		t.Errorf("Expected usage error, got '%s'", writer.String())
+	}
+}
+
+func TestHandleGet_RecipientCapabilityNeedsKeyFile(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+	recipient, _ := secret.NewRecipientKey()
+	key, _ := kademlia.Sum([]byte("data1"), kademlia.SHA1)
+	capability := secret.Capability{Key: key, Recipient: recipient.PublicKey().Bytes()}
+
+	cli.handleGet(capability.String())
+	cli.handleGet(capability.String() + " -k " + filepath.Join(t.TempDir(), "missing.key"))
+
+	output := writer.String()
+	if !strings.Contains(output, "error: Usage: GET <capability> -k") {
+		t.Errorf("Expected usage error without a key file, got '%s'", output)
+	}
+	if !strings.Contains(output, "missing.key") {
+		t.Errorf("Expected an error for the missing key file, got '%s'", output)
+	}
+}
+
+func TestCutOption(t *testing.T) {
+	for _, test := range []struct {
+		arg, name, rest, value string
+	}{
+		{"hash -k key.txt -o out.bin", "-k", "hash -o out.bin", "key.txt"},
+		{"hash -k key.txt -o out.bin", "-o", "hash -k key.txt", "out.bin"},
+		{"hash -o out.bin", "-k", "hash -o out.bin", ""},
+	} {
+		rest, value := cutOption(test.arg, test.name)
+		if rest != test.rest || value != test.value {
+			t.Errorf("Expected %q and %q for %s in %q, got %q and %q", test.rest, test.value, test.name, test.arg, rest, value)
+		}
+	}
+}
+
+func TestHandlePut_InvalidRecipient(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	cli.handlePut("-to not-hex value")
+
+	if !strings.Contains(writer.String(), "error: invalid recipient public key") {
+		t.Errorf("Expected invalid recipient error, got '%s'", writer.String())
+	}
+}
+
+func TestHandleGet_InvalidCapability(t *testing.T) {
+	writer := &strings.Builder{}
+	cli := &CLI{writer: writer}
+
+	cli.handleGet("kademlia:a94a8fe5ccb19ba61c4c0873d391e987982fbbd3?key=short")
+
+	if !strings.Contains(writer.String(), "error: invalid capability") {
+		t.Errorf("Expected invalid capability error, got '%s'", writer.String())
+	}
+}
@@ -0,0 +1,242 @@
+// Command kademlia drives a running node through its control API so that
+// scripts can store and fetch values without the interactive CLI.
+//
+// Usage:
+//
+//	kademlia [-control network:address] [-token token] <command> [arguments]
+//
+// Commands:
+//
+//	put <file>           store the contents of file, "-" reads stdin,
+//	                     -erasure 4+2 stores it as Reed-Solomon shards
+//	get <hash> [-o out]  fetch a value to stdout or to out
+//	ping <addr>          check if the node at addr answers
+//	peers                list the routing table of the node
+//	rejections           list contacts refused by the IP limits of the node
+//	lookup <id>          find the closest nodes to an ID or key
+//
+// The exit code is 0 on success, 1 when the operation failed, 2 on
+// invalid usage and 3 when the node could not be reached.
+package main
+
+import (
+	"d7024e/control"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Exit codes of the command
+const (
+	exitOK          = 0
+	exitFailed      = 1
+	exitUsage       = 2
+	exitUnreachable = 3
+)
+
+// errUsage marks errors that are caused by invalid arguments
+var errUsage = errors.New("usage")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command in args and returns its exit code
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("kademlia", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	controlAddr := flags.String("control", "unix:/tmp/kademlia.sock", "network:address of the control API of the node")
+	token := flags.String("token", "", "token for a tcp control API")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: kademlia [-control network:address] [-token token] put|get|ping|peers|rejections|lookup [arguments]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	network, address, found := strings.Cut(*controlAddr, ":")
+	if !found {
+		fmt.Fprintln(stderr, "invalid -control, expected network:address:", *controlAddr)
+		return exitUsage
+	}
+	client, err := control.Dial(network, address, *token)
+	if err != nil {
+		fmt.Fprintln(stderr, "cannot reach node:", err)
+		return exitUnreachable
+	}
+	defer client.Close()
+
+	command := &command{client: client, stdin: stdin, stdout: stdout}
+	switch err := command.run(flags.Arg(0), flags.Args()[1:]); {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errUsage):
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	default:
+		fmt.Fprintln(stderr, "error:", err)
+		return exitFailed
+	}
+}
+
+// command runs a single subcommand against the node
+type command struct {
+	client *control.Client
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+func (command *command) run(name string, args []string) error {
+	switch name {
+	case "put":
+		return command.put(args)
+	case "get":
+		return command.get(args)
+	case "ping":
+		return command.ping(args)
+	case "peers":
+		return command.peers(args)
+	case "rejections":
+		return command.rejections(args)
+	case "lookup":
+		return command.lookup(args)
+	default:
+		return fmt.Errorf("%w: unknown command %q", errUsage, name)
+	}
+}
+
+func (command *command) put(args []string) error {
+	flags := flag.NewFlagSet("put", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	hashFunc := flags.String("hash", "", "hash function of the key")
+	erasure := flags.String("erasure", "", "data+parity Reed-Solomon shards, such as 4+2")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil || len(positional) != 1 {
+		return fmt.Errorf("%w: kademlia put [-hash name] [-erasure data+parity] <file>", errUsage)
+	}
+
+	var data []byte
+	if positional[0] == "-" {
+		data, err = io.ReadAll(command.stdin)
+	} else {
+		data, err = os.ReadFile(positional[0])
+	}
+	if err != nil {
+		return err
+	}
+
+	reply, err := command.client.Put(data, *hashFunc, *erasure)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(command.stdout, reply.Key)
+	fmt.Fprintln(command.stdout, "delete token:", reply.DeleteToken)
+	return nil
+}
+
+func (command *command) get(args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	output := flags.String("o", "", "file to write the value to instead of stdout")
+	positional, err := parseInterspersed(flags, args)
+	if err != nil || len(positional) != 1 {
+		return fmt.Errorf("%w: kademlia get <hash> [-o out]", errUsage)
+	}
+
+	reply, err := command.client.Get(positional[0])
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = command.stdout.Write(reply.Data)
+		return err
+	}
+	return os.WriteFile(*output, reply.Data, 0644)
+}
+
+func (command *command) ping(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: kademlia ping <addr>", errUsage)
+	}
+	reply, err := command.client.Ping(args[0])
+	if err != nil {
+		return err
+	}
+	if !reply.Alive {
+		return fmt.Errorf("%s did not answer", args[0])
+	}
+	fmt.Fprintf(command.stdout, "%s answered in %v\n", args[0], reply.RTT)
+	return nil
+}
+
+func (command *command) peers(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%w: kademlia peers", errUsage)
+	}
+	reply, err := command.client.RoutingTable()
+	if err != nil {
+		return err
+	}
+	for _, bucket := range reply.Buckets {
+		for _, contact := range bucket.Contacts {
+			fmt.Fprintf(command.stdout, "%d\t%s\t%s\n", bucket.Index, contact.ID, contact.Address)
+		}
+	}
+	return nil
+}
+
+func (command *command) rejections(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%w: kademlia rejections", errUsage)
+	}
+	rejections, err := command.client.Rejections()
+	if err != nil {
+		return err
+	}
+	for _, rejection := range rejections {
+		fmt.Fprintf(command.stdout, "%s\t%s\t%s\t%s\n", rejection.Time.Format(time.RFC3339), rejection.Reason, rejection.Contact.ID, rejection.Contact.Address)
+	}
+	return nil
+}
+
+func (command *command) lookup(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: kademlia lookup <id>", errUsage)
+	}
+	reply, err := command.client.Lookup(args[0])
+	if err != nil {
+		return err
+	}
+	if len(reply.Contacts) == 0 {
+		return fmt.Errorf("no contacts found")
+	}
+	for _, contact := range reply.Contacts {
+		fmt.Fprintf(command.stdout, "%s\t%s\n", contact.ID, contact.Address)
+	}
+	return nil
+}
+
+// parseInterspersed parses flags that may come before or after the
+// positional arguments and returns the positional arguments
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"d7024e/control"
+	"d7024e/kademlia"
+	"d7024e/kademlia/kademliatest"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestControl starts two connected nodes and serves the control API of
+// the first, it returns the -control flag for it and the second node
+func newTestControl(t *testing.T) (string, *kademlia.Kademlia) {
+	node, other := kademliatest.NewNode(t), kademliatest.NewNode(t)
+	node.RoutingTable.AddContact(other.RoutingTable.Me)
+	other.RoutingTable.AddContact(node.RoutingTable.Me)
+
+	server, err := control.NewServer(node, nil, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go server.Serve(listener, false)
+	return "-control=unix:" + socket, other
+}
+
+// runCommand runs the command and returns its exit code, stdout and stderr
+func runCommand(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestPutThenGet(t *testing.T) {
+	controlFlag, _ := newTestControl(t)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.txt")
+	output := filepath.Join(dir, "out.txt")
+	os.WriteFile(input, []byte("hello from a script"), 0644)
+
+	code, stdout, stderr := runCommand("", controlFlag, "put", input)
+	if code != exitOK {
+		t.Fatalf("Expected put to succeed, got %d: %s", code, stderr)
+	}
+	key := strings.SplitN(stdout, "\n", 2)[0]
+
+	code, _, stderr = runCommand("", controlFlag, "get", key, "-o", output)
+	if code != exitOK {
+		t.Fatalf("Expected get to succeed, got %d: %s", code, stderr)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "hello from a script" {
+		t.Errorf("Expected the stored value in %s, got '%s'", output, data)
+	}
+
+	code, stdout, _ = runCommand("", controlFlag, "get", key)
+	if code != exitOK || stdout != "hello from a script" {
+		t.Errorf("Expected the value on stdout, got %d '%s'", code, stdout)
+	}
+}
+
+func TestPut_Stdin(t *testing.T) {
+	controlFlag, _ := newTestControl(t)
+
+	code, stdout, stderr := runCommand("from stdin", controlFlag, "put", "-hash", "sha2-256", "-")
+	if code != exitOK || !strings.HasPrefix(stdout, "1220") {
+		t.Errorf("Expected a sha2-256 key, got %d '%s' '%s'", code, stdout, stderr)
+	}
+}
+
+func TestPut_Erasure(t *testing.T) {
+	controlFlag, _ := newTestControl(t)
+
+	code, stdout, stderr := runCommand("erasure coded", controlFlag, "put", "-", "-erasure", "2+1")
+	if code != exitOK {
+		t.Fatalf("Expected put to succeed, got %d: %s", code, stderr)
+	}
+	key := strings.SplitN(stdout, "\n", 2)[0]
+	if code, stdout, _ = runCommand("", controlFlag, "get", key); code != exitOK || stdout != "erasure coded" {
+		t.Errorf("Expected the value on stdout, got %d '%s'", code, stdout)
+	}
+
+	if code, _, _ = runCommand("data", controlFlag, "put", "-", "-erasure", "2"); code != exitFailed {
+		t.Errorf("Expected exit code %d for an invalid layout, got %d", exitFailed, code)
+	}
+}
+
+func TestGet_NotFound(t *testing.T) {
+	controlFlag, _ := newTestControl(t)
+
+	code, _, stderr := runCommand("", controlFlag, "get", "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	if code != exitFailed || !strings.Contains(stderr, "not found") {
+		t.Errorf("Expected exit code %d with not found, got %d '%s'", exitFailed, code, stderr)
+	}
+}
+
+func TestPingPeersAndLookup(t *testing.T) {
+	controlFlag, other := newTestControl(t)
+
+	if code, _, stderr := runCommand("", controlFlag, "ping", other.RoutingTable.Me.Address); code != exitOK {
+		t.Errorf("Expected ping to succeed, got %d: %s", code, stderr)
+	}
+	if code, stdout, _ := runCommand("", controlFlag, "peers"); code != exitOK || !strings.Contains(stdout, other.RoutingTable.Me.Address) {
+		t.Errorf("Expected peers to list %s, got %d '%s'", other.RoutingTable.Me.Address, code, stdout)
+	}
+	if code, stdout, _ := runCommand("", controlFlag, "rejections"); code != exitOK || stdout != "" {
+		t.Errorf("Expected no rejections, got %d '%s'", code, stdout)
+	}
+	if code, stdout, _ := runCommand("", controlFlag, "lookup", other.RoutingTable.Me.ID.String()); code != exitOK || !strings.Contains(stdout, other.RoutingTable.Me.ID.String()) {
+		t.Errorf("Expected lookup to find %s, got %d '%s'", other.RoutingTable.Me.ID, code, stdout)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	controlFlag, _ := newTestControl(t)
+
+	if code, _, _ := runCommand("", controlFlag); code != exitUsage {
+		t.Errorf("Expected exit code %d without a command, got %d", exitUsage, code)
+	}
+	if code, _, _ := runCommand("", controlFlag, "frobnicate"); code != exitUsage {
+		t.Errorf("Expected exit code %d for an unknown command, got %d", exitUsage, code)
+	}
+	if code, _, _ := runCommand("", controlFlag, "get"); code != exitUsage {
+		t.Errorf("Expected exit code %d for get without a hash, got %d", exitUsage, code)
+	}
+	if code, _, _ := runCommand("", "-control=unix:"+filepath.Join(t.TempDir(), "missing.sock"), "peers"); code != exitUnreachable {
+		t.Errorf("Expected exit code %d for a missing node, got %d", exitUnreachable, code)
+	}
+}
@@ -0,0 +1,119 @@
+package control
+
+import (
+	"bufio"
+	"d7024e/kademlia"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"strings"
+	"time"
+)
+
+// Client calls the control API of a running node
+type Client struct {
+	rpcClient *rpc.Client
+}
+
+// Dial connects to the control API on a "unix" socket path or a "tcp"
+// address. token is sent first on tcp connections.
+func Dial(network string, address string, token string) (*Client, error) {
+	conn, err := net.DialTimeout(network, address, authTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if network == "tcp" {
+		if err := login(conn, token); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return &Client{rpcClient: jsonrpc.NewClient(conn)}, nil
+}
+
+// login presents token and waits for the server to accept it
+func login(conn net.Conn, token string) error {
+	conn.SetDeadline(time.Now().Add(authTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := fmt.Fprintf(conn, "AUTH %s\n", token); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(&singleByteReader{conn}).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(line) != "OK" {
+		return fmt.Errorf("control API refused the token")
+	}
+	return nil
+}
+
+// Close closes the connection to the node
+func (client *Client) Close() error {
+	return client.rpcClient.Close()
+}
+
+// RoutingTable returns the routing table of the node
+func (client *Client) RoutingTable() (RoutingTableReply, error) {
+	var reply RoutingTableReply
+	err := client.rpcClient.Call("Node.RoutingTable", &Empty{}, &reply)
+	return reply, err
+}
+
+// Rejections returns the latest contacts the node kept out of its routing
+// table because of its IP limits
+func (client *Client) Rejections() ([]kademlia.Rejection, error) {
+	var reply RejectionsReply
+	err := client.rpcClient.Call("Node.Rejections", &Empty{}, &reply)
+	return reply.Rejections, err
+}
+
+// StoredKeys returns the values held by the node
+func (client *Client) StoredKeys() ([]StoredKey, error) {
+	var reply StoredKeysReply
+	err := client.rpcClient.Call("Node.StoredKeys", &Empty{}, &reply)
+	return reply.Keys, err
+}
+
+// Ping asks the node to ping the peer at address
+func (client *Client) Ping(address string) (PingReply, error) {
+	var reply PingReply
+	err := client.rpcClient.Call("Node.Ping", &PingArgs{Address: address}, &reply)
+	return reply, err
+}
+
+// Lookup asks the node to run a node lookup for target
+func (client *Client) Lookup(target string) (LookupReply, error) {
+	var reply LookupReply
+	err := client.rpcClient.Call("Node.Lookup", &LookupArgs{Target: target}, &reply)
+	return reply, err
+}
+
+// Put asks the node to store data, hashFunc may be empty for the default
+// and erasure empty to store the data without erasure coding
+func (client *Client) Put(data []byte, hashFunc string, erasure string) (PutReply, error) {
+	var reply PutReply
+	err := client.rpcClient.Call("Node.Put", &PutArgs{Data: data, HashFunc: hashFunc, Erasure: erasure}, &reply)
+	return reply, err
+}
+
+// Get asks the node to fetch the value stored under key
+func (client *Client) Get(key string) (GetReply, error) {
+	var reply GetReply
+	err := client.rpcClient.Call("Node.Get", &GetArgs{Key: key}, &reply)
+	return reply, err
+}
+
+// Republish asks the node to republish every value it holds
+func (client *Client) Republish() (RepublishReply, error) {
+	var reply RepublishReply
+	err := client.rpcClient.Call("Node.Republish", &Empty{}, &reply)
+	return reply, err
+}
+
+// Shutdown asks the node to stop
+func (client *Client) Shutdown() error {
+	return client.rpcClient.Call("Node.Shutdown", &Empty{}, &Empty{})
+}
@@ -0,0 +1,375 @@
+// Package control is a JSON-RPC API that lets operators inspect and steer
+// a running kademlia node. It is served on a local unix socket, or on TCP
+// where every connection has to present a shared token first.
+package control
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"d7024e/files"
+	"d7024e/kademlia"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// authTimeout is how long a TCP client has to present its token
+const authTimeout = 5 * time.Second
+
+// Empty is used for methods that take no arguments or return nothing
+type Empty struct{}
+
+// Bucket is one non-empty bucket of the routing table
+type Bucket struct {
+	Index    int                `json:"index"`
+	Contacts []kademlia.Contact `json:"contacts"`
+}
+
+// RoutingTableReply describes the routing table of the node
+type RoutingTableReply struct {
+	Me      kademlia.Contact `json:"me"`
+	Buckets []Bucket         `json:"buckets"`
+}
+
+// RejectionsReply lists the latest contacts the routing table refused
+// because of its IP limits, oldest first
+type RejectionsReply struct {
+	Rejections []kademlia.Rejection `json:"rejections"`
+}
+
+// StoredKey is a value held by the node
+type StoredKey struct {
+	Hash string `json:"hash"`
+	Size int    `json:"size"`
+}
+
+// StoredKeysReply lists the values held by the node
+type StoredKeysReply struct {
+	Keys []StoredKey `json:"keys"`
+}
+
+// PingArgs names the peer to ping
+type PingArgs struct {
+	Address string `json:"address"`
+}
+
+// PingReply tells if the peer answered and how long it took
+type PingReply struct {
+	Alive bool          `json:"alive"`
+	RTT   time.Duration `json:"rtt"`
+}
+
+// LookupArgs is the KademliaID or content key to look up
+type LookupArgs struct {
+	Target string `json:"target"`
+}
+
+// LookupReply is the closest contacts found by the lookup
+type LookupReply struct {
+	Contacts []kademlia.Contact `json:"contacts"`
+}
+
+// RepublishReply tells how many values were republished and how many of
+// them reached a majority of their closest contacts
+type RepublishReply struct {
+	Values int `json:"values"`
+	Stored int `json:"stored"`
+}
+
+// PutArgs is a value to store, the name of the hash function for its key
+// and an optional Reed-Solomon layout such as "4+2"
+type PutArgs struct {
+	Data     []byte `json:"data"`
+	HashFunc string `json:"hash_func,omitempty"`
+	Erasure  string `json:"erasure,omitempty"`
+}
+
+// PutReply is the key of the stored data, the token that deletes it and
+// how many values it was stored as
+type PutReply struct {
+	Key         string `json:"key"`
+	DeleteToken string `json:"delete_token"`
+	Values      int    `json:"values"`
+}
+
+// GetArgs is the key of the value to fetch
+type GetArgs struct {
+	Key string `json:"key"`
+}
+
+// GetReply is the value and the contact it was found on
+type GetReply struct {
+	Data    []byte           `json:"data"`
+	FoundOn kademlia.Contact `json:"found_on"`
+}
+
+// Node is the RPC service, its exported methods are callable as "Node.<Method>"
+type Node struct {
+	kademlia *kademlia.Kademlia
+	shutdown func()
+}
+
+// RoutingTable returns the contents of the routing table
+func (node *Node) RoutingTable(args *Empty, reply *RoutingTableReply) error {
+	reply.Me = node.kademlia.RoutingTable.Me
+	for index, contacts := range node.kademlia.RoutingTable.Buckets() {
+		reply.Buckets = append(reply.Buckets, Bucket{Index: index, Contacts: contacts})
+	}
+	sort.Slice(reply.Buckets, func(i, j int) bool {
+		return reply.Buckets[i].Index < reply.Buckets[j].Index
+	})
+	return nil
+}
+
+// Rejections returns the latest contacts kept out of the routing table by
+// its IP limits
+func (node *Node) Rejections(args *Empty, reply *RejectionsReply) error {
+	reply.Rejections = node.kademlia.RoutingTable.Rejections()
+	return nil
+}
+
+// StoredKeys returns the hash and size of every value held by the node
+func (node *Node) StoredKeys(args *Empty, reply *StoredKeysReply) error {
+	for _, value := range node.kademlia.StoredValues() {
+		reply.Keys = append(reply.Keys, StoredKey{Hash: value.Hash, Size: len(value.Data)})
+	}
+	sort.Slice(reply.Keys, func(i, j int) bool {
+		return reply.Keys[i].Hash < reply.Keys[j].Hash
+	})
+	return nil
+}
+
+// Ping sends a PING to the peer at args.Address
+func (node *Node) Ping(args *PingArgs, reply *PingReply) error {
+	if args.Address == "" {
+		return fmt.Errorf("missing address")
+	}
+	peer := kademlia.NewContact(nil, args.Address)
+	start := time.Now()
+	reply.Alive = node.kademlia.Network.SendPingMessage(&node.kademlia.RoutingTable.Me, &peer)
+	reply.RTT = time.Since(start)
+	return nil
+}
+
+// Lookup runs a node lookup for args.Target, which is either a KademliaID
+// of the network width or a content key
+func (node *Node) Lookup(args *LookupArgs, reply *LookupReply) error {
+	target, err := ParseTarget(args.Target, node.kademlia.RoutingTable.IDLength())
+	if err != nil {
+		return err
+	}
+	targetContact := kademlia.NewContact(target, "")
+	reply.Contacts, _, _ = node.kademlia.NodeLookup(&targetContact, "")
+	return nil
+}
+
+// Put stores args.Data on the closest contacts to its key, data larger
+// than a single value is chunked, or erasure coded if args.Erasure is set
+func (node *Node) Put(args *PutArgs, reply *PutReply) error {
+	hashFunc := kademlia.DefaultHashFunc
+	var err error
+	if args.HashFunc != "" {
+		if hashFunc, err = kademlia.ParseHashFunc(args.HashFunc); err != nil {
+			return err
+		}
+	}
+	var result files.PutResult
+	if args.Erasure != "" {
+		var coding *files.ErasureCoding
+		if coding, err = files.ParseErasure(args.Erasure); err != nil {
+			return err
+		}
+		result, err = files.PutErasure(node.kademlia, args.Data, hashFunc, coding.DataShards, coding.ParityShards, nil)
+	} else {
+		result, err = files.Put(node.kademlia, args.Data, hashFunc, nil)
+	}
+	if err != nil {
+		return err
+	}
+	reply.Key = result.Key.String()
+	reply.DeleteToken = hex.EncodeToString(result.DeleteToken)
+	reply.Values = result.Chunks
+	return nil
+}
+
+// Get looks up the data stored under args.Key
+func (node *Node) Get(args *GetArgs, reply *GetReply) error {
+	key, err := kademlia.ParseKey(args.Key)
+	if err != nil {
+		return err
+	}
+	reply.Data, reply.FoundOn, err = files.Get(node.kademlia, key, nil)
+	return err
+}
+
+// Republish stores every value held by the node on its closest contacts again
+func (node *Node) Republish(args *Empty, reply *RepublishReply) error {
+	reply.Values, reply.Stored = node.kademlia.Republish()
+	return nil
+}
+
+// Shutdown stops the node once the reply has been sent
+func (node *Node) Shutdown(args *Empty, reply *Empty) error {
+	if node.shutdown == nil {
+		return fmt.Errorf("shutdown is not supported by this node")
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		node.shutdown()
+	}()
+	return nil
+}
+
+// ParseTarget parses a hex KademliaID of length bytes, or a content key
+// that is mapped onto the keyspace
+func ParseTarget(target string, length int) (*kademlia.KademliaID, error) {
+	if len(target) == length*2 {
+		if id, err := kademlia.ParseKademliaID(target); err == nil {
+			return id, nil
+		}
+	}
+	key, err := kademlia.ParseKey(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target: %v", err)
+	}
+	return key.KademliaID(length), nil
+}
+
+// Server serves the control API for a node
+type Server struct {
+	rpcServer *rpc.Server
+	token     string
+}
+
+// NewServer returns a new instance of a Server for kademliaInstance.
+// shutdown is called when a client asks the node to stop. token is
+// required from clients connecting over TCP.
+func NewServer(kademliaInstance *kademlia.Kademlia, shutdown func(), token string) (*Server, error) {
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.Register(&Node{kademlia: kademliaInstance, shutdown: shutdown}); err != nil {
+		return nil, err
+	}
+	return &Server{rpcServer: rpcServer, token: token}, nil
+}
+
+// Listen serves the API on a "unix" socket path or a "tcp" address until
+// the listener fails. TCP is refused unless the server has a token.
+func (server *Server) Listen(network string, address string) error {
+	var listener net.Listener
+	var err error
+	switch network {
+	case "unix":
+		listener, err = listenUnix(address)
+	case "tcp":
+		if server.token == "" {
+			return fmt.Errorf("a token is required to serve the control API over tcp")
+		}
+		listener, err = net.Listen(network, address)
+	default:
+		return fmt.Errorf("unsupported control network: %s", network)
+	}
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	fmt.Println("Control API listening on", network, address)
+	return server.Serve(listener, network == "tcp")
+}
+
+// listenUnix listens on a socket at path that only the user of the node
+// can connect to. The socket is created in a directory only the user can
+// enter and moved to path once its mode is restricted, so that no other
+// user can connect before.
+func listenUnix(path string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".control-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "control.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	os.Remove(path)
+	if err := os.Rename(socket, path); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: listener.(*net.UnixListener), path: path}, nil
+}
+
+// unixListener removes the socket at path when it is closed
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (listener *unixListener) Close() error {
+	os.Remove(listener.path)
+	return listener.UnixListener.Close()
+}
+
+// Serve accepts connections on listener, checking the token first if
+// requireToken is set
+func (server *Server) Serve(listener net.Listener, requireToken bool) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go server.serveConn(conn, requireToken)
+	}
+}
+
+func (server *Server) serveConn(conn net.Conn, requireToken bool) {
+	if requireToken && !server.authenticate(conn) {
+		conn.Close()
+		return
+	}
+	server.rpcServer.ServeCodec(jsonrpc.NewServerCodec(conn))
+}
+
+// authenticate reads the "AUTH <token>" line a TCP client starts with
+func (server *Server) authenticate(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(authTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	line, err := bufio.NewReader(&singleByteReader{conn}).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	token, found := strings.CutPrefix(strings.TrimSpace(line), "AUTH ")
+	if !found || subtle.ConstantTimeCompare([]byte(token), []byte(server.token)) != 1 {
+		fmt.Fprintln(conn, "ERR unauthorized")
+		return false
+	}
+	fmt.Fprintln(conn, "OK")
+	return true
+}
+
+// singleByteReader stops bufio from reading past the AUTH line, so that
+// the rest of the stream is left for the JSON-RPC codec
+type singleByteReader struct {
+	conn net.Conn
+}
+
+func (reader *singleByteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return reader.conn.Read(p)
+}
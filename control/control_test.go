@@ -0,0 +1,196 @@
+package control
+
+import (
+	"d7024e/kademlia"
+	"d7024e/kademlia/kademliatest"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestClient starts two connected nodes, serves the control API of the
+// first over a unix socket and returns a client for it
+func newTestClient(t *testing.T, shutdown func()) (*Client, *kademlia.Kademlia, *kademlia.Kademlia) {
+	node, other := kademliatest.NewNode(t), kademliatest.NewNode(t)
+	node.RoutingTable.AddContact(other.RoutingTable.Me)
+	other.RoutingTable.AddContact(node.RoutingTable.Me)
+
+	server, err := NewServer(node, shutdown, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go server.Serve(listener, false)
+
+	client, err := Dial("unix", socket, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, node, other
+}
+
+func TestRoutingTable_ListsContacts(t *testing.T) {
+	client, node, other := newTestClient(t, nil)
+
+	reply, err := client.RoutingTable()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !reply.Me.ID.Equals(node.RoutingTable.Me.ID) {
+		t.Errorf("Expected me to be %s, got %s", node.RoutingTable.Me.ID, reply.Me.ID)
+	}
+	if len(reply.Buckets) != 1 || len(reply.Buckets[0].Contacts) != 1 {
+		t.Fatalf("Expected one bucket with one contact, got %v", reply.Buckets)
+	}
+	if !reply.Buckets[0].Contacts[0].ID.Equals(other.RoutingTable.Me.ID) {
+		t.Errorf("Expected contact %s, got %s", other.RoutingTable.Me.ID, reply.Buckets[0].Contacts[0].ID)
+	}
+}
+
+func TestRejections_ListsContactsOverLimit(t *testing.T) {
+	client, node, _ := newTestClient(t, nil)
+	node.RoutingTable.SetIPLimits(kademlia.IPLimits{PerIPTable: 1})
+	rejected := kademlia.NewRandomKademliaID()
+	node.UpdateRT(rejected, "127.0.0.1:1")
+
+	rejections, err := client.Rejections()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(rejections) != 1 || !rejections[0].Contact.ID.Equals(rejected) || rejections[0].Reason != kademlia.RejectedIPTable {
+		t.Errorf("Expected %s to be rejected by the table limit, got %v", rejected, rejections)
+	}
+}
+
+func TestStoredKeys_ThenRepublish(t *testing.T) {
+	client, node, other := newTestClient(t, nil)
+	data := []byte("hello world")
+	key, _ := kademlia.Sum(data, kademlia.SHA1)
+	node.ActionChannel <- kademlia.Action{Action: "Store", Hash: key.String()[4:], Data: data}
+
+	keys, err := client.StoredKeys()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(keys) != 1 || keys[0].Hash != key.String()[4:] || keys[0].Size != len(data) {
+		t.Fatalf("Expected the stored key, got %v", keys)
+	}
+
+	reply, err := client.Republish()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if reply.Values != 1 || reply.Stored != 1 {
+		t.Errorf("Expected one value republished, got %+v", reply)
+	}
+	if values := other.StoredValues(); len(values) != 1 {
+		t.Errorf("Expected the other node to hold the value, got %d values", len(values))
+	}
+}
+
+func TestPing_AndLookup(t *testing.T) {
+	client, _, other := newTestClient(t, nil)
+
+	ping, err := client.Ping(other.RoutingTable.Me.Address)
+	if err != nil || !ping.Alive {
+		t.Fatalf("Expected peer to be alive, got %+v, %v", ping, err)
+	}
+
+	lookup, err := client.Lookup(other.RoutingTable.Me.ID.String())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(lookup.Contacts) == 0 || !lookup.Contacts[0].ID.Equals(other.RoutingTable.Me.ID) {
+		t.Errorf("Expected lookup to find %s, got %v", other.RoutingTable.Me.ID, lookup.Contacts)
+	}
+
+	if _, err := client.Lookup("not a key"); err == nil {
+		t.Error("Expected an invalid target to fail")
+	}
+}
+
+func TestShutdown_CallsCallback(t *testing.T) {
+	called := make(chan struct{})
+	client, _, _ := newTestClient(t, func() { close(called) })
+
+	if err := client.Shutdown(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Error("Expected shutdown callback to be called")
+	}
+}
+
+func TestTCP_RequiresToken(t *testing.T) {
+	node := kademliatest.NewNode(t)
+	server, _ := NewServer(node, nil, "secret")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go server.Serve(listener, true)
+
+	if _, err := Dial("tcp", listener.Addr().String(), "wrong"); err == nil {
+		t.Error("Expected a wrong token to be refused")
+	}
+
+	client, err := Dial("tcp", listener.Addr().String(), "secret")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer client.Close()
+	if _, err := client.RoutingTable(); err != nil {
+		t.Errorf("Expected an authenticated call to succeed, got %v", err)
+	}
+}
+
+func TestListen_RefusesTCPWithoutToken(t *testing.T) {
+	server, _ := NewServer(kademliatest.NewNode(t), nil, "")
+	if err := server.Listen("tcp", "127.0.0.1:0"); err == nil {
+		t.Error("Expected tcp without a token to be refused")
+	}
+}
+
+func TestListenUnix_RestrictsSocket(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "control.sock")
+	if err := os.WriteFile(socket, nil, 0644); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	listener, err := listenUnix(socket)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatalf("Expected the socket at its path, got %v", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected a socket with mode 0600, got %v", info.Mode())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected only the socket in its directory, got %v", entries)
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("Expected to connect to the socket, got %v", err)
+	}
+	conn.Close()
+
+	listener.Close()
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket to be removed on close, got %v", err)
+	}
+}
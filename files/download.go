@@ -0,0 +1,70 @@
+package files
+
+import (
+	"d7024e/kademlia"
+	"io"
+	"os"
+)
+
+// partialSuffix is appended to the path of a download until it completes
+const partialSuffix = ".part"
+
+// Download fetches the data stored under key by Put into the file at
+// path and returns its size. Chunks are written to path+".part" as they
+// arrive, and chunks already in that file that match the manifest are not
+// fetched again, so an interrupted download resumes where it stopped.
+// The file is renamed to path once it is complete. progress may be nil.
+func Download(node *kademlia.Kademlia, key kademlia.Multihash, path string, progress Progress) (int64, kademlia.Contact, error) {
+	data, manifest, foundOn, err := resolve(node, key)
+	if err != nil {
+		return 0, foundOn, err
+	}
+	if manifest != nil && manifest.Erasure != nil {
+		// stripes are rebuilt in memory, so erasure-coded files do not resume
+		data, err = fetchErasure(node, manifest, progress)
+		if err != nil {
+			return 0, foundOn, err
+		}
+		manifest = nil
+	}
+	if manifest == nil {
+		return int64(len(data)), foundOn, os.WriteFile(path, data, 0644)
+	}
+
+	partialPath := path + partialSuffix
+	file, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, foundOn, err
+	}
+	defer file.Close()
+
+	keys, err := manifest.keys()
+	if err != nil {
+		return 0, foundOn, err
+	}
+	have := func(index int) bool {
+		chunk := make([]byte, manifest.chunkLength(index))
+		if _, err := file.ReadAt(chunk, int64(index)*int64(manifest.ChunkSize)); err != nil && err != io.EOF {
+			return false
+		}
+		return keys[index].Verify(chunk)
+	}
+	save := func(index int, chunk []byte) error {
+		_, err := file.WriteAt(chunk, int64(index)*int64(manifest.ChunkSize))
+		return err
+	}
+	if err := fetchChunks(node, manifest, have, save, progress); err != nil {
+		return 0, foundOn, err
+	}
+
+	if err := file.Truncate(manifest.Size); err != nil {
+		return 0, foundOn, err
+	}
+	if err := file.Sync(); err != nil {
+		return 0, foundOn, err
+	}
+	if err := file.Close(); err != nil {
+		return 0, foundOn, err
+	}
+	return manifest.Size, foundOn, os.Rename(partialPath, path)
+}
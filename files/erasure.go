@@ -0,0 +1,250 @@
+package files
+
+import (
+	"bytes"
+	"d7024e/kademlia"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// ErrTooFewShards is returned when fewer shards than the data shards of a
+// stripe could be fetched, so it cannot be reconstructed
+var ErrTooFewShards = errors.New("too few shards to reconstruct the data")
+
+// ErasureCoding is the Reed-Solomon layout of an erasure-coded file. The
+// file is split into stripes of DataShards chunks, and ParityShards parity
+// shards are added to every stripe. Any DataShards of the shards of a
+// stripe are enough to rebuild it.
+type ErasureCoding struct {
+	DataShards   int `json:"data_shards"`
+	ParityShards int `json:"parity_shards"`
+}
+
+// ShardReplicas is how many nodes every shard is stored on. The parity
+// shards make up for lost nodes, so shards are not replicated to the k
+// closest nodes like other values.
+const ShardReplicas = 1
+
+// PutErasure stores data as Reed-Solomon shards, each under its own hash
+// on ShardReplicas nodes, and returns the key of the manifest that lists
+// them. The shards of a stripe are stored on different nodes as long as
+// the network has enough of them, so that losing a node loses at most one
+// shard of every stripe. Every stripe of dataShards chunks gets parityShards parity shards,
+// so the data survives the loss of any parityShards shards per stripe
+// while taking up (dataShards+parityShards)/dataShards times its size on
+// the nodes. Only the manifest is replicated to the k closest nodes.
+// progress may be nil.
+func PutErasure(node *kademlia.Kademlia, data []byte, hashFunc kademlia.HashFunc, dataShards int, parityShards int, progress Progress) (PutResult, error) {
+	coding := &ErasureCoding{DataShards: dataShards, ParityShards: parityShards}
+	encoder, err := coding.encoder()
+	if err != nil {
+		return PutResult{}, err
+	}
+
+	token, _ := kademlia.NewDeleteToken()
+	result := PutResult{DeleteToken: token}
+	manifest := Manifest{Size: int64(len(data)), ChunkSize: ChunkSize, Erasure: coding}
+	stripeSize := dataShards * ChunkSize
+	total := manifest.chunkTotal()
+	var stripes [][][]byte
+	var keys []kademlia.Multihash
+	for start := 0; start < len(data); start += stripeSize {
+		shards, err := encoder.Split(bytes.Clone(data[start:min(start+stripeSize, len(data))]))
+		if err != nil {
+			return result, err
+		}
+		if err := encoder.Encode(shards); err != nil {
+			return result, err
+		}
+		for _, shard := range shards {
+			key, err := kademlia.Sum(shard, hashFunc)
+			if err != nil {
+				return result, err
+			}
+			keys = append(keys, key)
+			manifest.Chunks = append(manifest.Chunks, key.String())
+		}
+		stripes = append(stripes, shards)
+	}
+	manifest.Root = hex.EncodeToString(merkleRoot(keys))
+
+	// the placement of the shards depends on the root, so they are stored
+	// once every key is known
+	index := 0
+	for _, shards := range stripes {
+		used := make(map[string]bool)
+		for _, shard := range shards {
+			placement := manifest.shardPlacement(index, node.RoutingTable.IDLength())
+			if err := putShard(node, shard, hashFunc, token, placement, used, &result); err != nil {
+				return result, fmt.Errorf("storing shard %d of %d: %w", index+1, total, err)
+			}
+			index++
+			if progress != nil {
+				progress(index, total)
+			}
+		}
+	}
+
+	encoded, err := manifest.MarshalBinary()
+	if err != nil {
+		return result, err
+	}
+	if len(encoded) > kademlia.MaxValueSize {
+		result.Key, err = put(node, encoded, hashFunc, token, true, nil, &result)
+	} else {
+		result.Key, err = putValue(node, encoded, hashFunc, token, &result)
+	}
+	return result, err
+}
+
+// putShard stores a shard on ShardReplicas of the closest nodes to
+// placement, preferring nodes that are not in used, and adds the nodes it
+// was stored on to used
+func putShard(node *kademlia.Kademlia, shard []byte, hashFunc kademlia.HashFunc, token []byte, placement *kademlia.KademliaID, used map[string]bool, result *PutResult) error {
+	_, contacts, err := node.PutValueNear(shard, hashFunc, token, placement, ShardReplicas, used)
+	if err != nil {
+		return err
+	}
+	for _, contact := range contacts {
+		used[contact.ID.String()] = true
+	}
+	result.Chunks++
+	return nil
+}
+
+// shardPlacement is the ID that shard index is stored near rather than
+// its key. It is derived from the Merkle root over the shard keys and the
+// index, so that the manifest is enough to find a shard again.
+func (manifest *Manifest) shardPlacement(index int, length int) *kademlia.KademliaID {
+	key, _ := kademlia.Sum(fmt.Appendf(nil, "%s/%d", manifest.Root, index), kademlia.SHA2_256)
+	return key.KademliaID(length)
+}
+
+// fetchErasure rebuilds an erasure-coded file stripe by stripe. The data
+// shards of a stripe are fetched first, and parity shards only for those
+// that could not be fetched. progress counts stripes.
+func fetchErasure(node *kademlia.Kademlia, manifest *Manifest, progress Progress) ([]byte, error) {
+	keys, err := manifest.keys()
+	if err != nil {
+		return nil, err
+	}
+	coding := manifest.Erasure
+	encoder, err := coding.encoder()
+	if err != nil {
+		return nil, err
+	}
+
+	data := bytes.NewBuffer(make([]byte, 0, manifest.Size))
+	stripes := manifest.stripes()
+	for stripe := range stripes {
+		first := stripe * coding.shards()
+		shards := make([][]byte, coding.shards())
+		found := fetchShards(node, manifest, keys, first, shards, 0, coding.DataShards)
+		for next := coding.DataShards; found < coding.DataShards && next < len(shards); {
+			count := min(coding.DataShards-found, len(shards)-next)
+			found += fetchShards(node, manifest, keys, first, shards, next, next+count)
+			next += count
+		}
+		if found < coding.DataShards {
+			return nil, fmt.Errorf("stripe %d of %d: %w", stripe+1, stripes, ErrTooFewShards)
+		}
+
+		if err := encoder.ReconstructData(shards); err != nil {
+			return nil, fmt.Errorf("stripe %d of %d: %w", stripe+1, stripes, err)
+		}
+		stripeSize := min(int64(coding.DataShards*manifest.ChunkSize), manifest.Size-int64(data.Len()))
+		if err := encoder.Join(data, shards, int(stripeSize)); err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			progress(stripe+1, stripes)
+		}
+	}
+	return data.Bytes(), nil
+}
+
+// fetchShards fetches shards from to to of the stripe starting at chunk
+// first in parallel and returns how many of them were fetched. Shards that
+// could not be fetched are left nil.
+func fetchShards(node *kademlia.Kademlia, manifest *Manifest, keys []kademlia.Multihash, first int, shards [][]byte, from int, to int) int {
+	var waitGroup sync.WaitGroup
+	for i := from; i < to; i++ {
+		waitGroup.Add(1)
+		go func(i int) {
+			defer waitGroup.Done()
+			if shard, err := fetchChunk(node, manifest, keys, first+i); err == nil {
+				shards[i] = shard
+			}
+		}(i)
+	}
+	waitGroup.Wait()
+
+	found := 0
+	for _, shard := range shards[from:to] {
+		if shard != nil {
+			found++
+		}
+	}
+	return found
+}
+
+// stripes is how many stripes of data shards the file is split into
+func (manifest *Manifest) stripes() int {
+	return chunkCount(manifest.Size, manifest.Erasure.DataShards*manifest.ChunkSize)
+}
+
+// shardLength is the size of every shard in stripe. Shards of the last
+// stripe are shorter when the file does not fill it.
+func (manifest *Manifest) shardLength(stripe int) int {
+	stripeSize := int64(manifest.Erasure.DataShards * manifest.ChunkSize)
+	remaining := min(stripeSize, manifest.Size-int64(stripe)*stripeSize)
+	return chunkCount(remaining, manifest.Erasure.DataShards)
+}
+
+// shards is how many shards make up a stripe
+func (coding *ErasureCoding) shards() int {
+	return coding.DataShards + coding.ParityShards
+}
+
+// validate checks the layout, a nil ErasureCoding is valid
+func (coding *ErasureCoding) validate() error {
+	if coding == nil {
+		return nil
+	}
+	_, err := coding.encoder()
+	return err
+}
+
+func (coding *ErasureCoding) encoder() (reedsolomon.Encoder, error) {
+	if coding.DataShards < 1 || coding.ParityShards < 1 {
+		return nil, fmt.Errorf("erasure coding needs at least 1 data and 1 parity shard, got %d+%d", coding.DataShards, coding.ParityShards)
+	}
+	return reedsolomon.New(coding.DataShards, coding.ParityShards)
+}
+
+// ParseErasure parses a layout written as "data+parity", such as "4+2"
+func ParseErasure(s string) (*ErasureCoding, error) {
+	dataShards, parityShards, _ := strings.Cut(s, "+")
+	var coding ErasureCoding
+	var dataErr, parityErr error
+	coding.DataShards, dataErr = strconv.Atoi(dataShards)
+	coding.ParityShards, parityErr = strconv.Atoi(parityShards)
+	if dataErr != nil || parityErr != nil {
+		return nil, fmt.Errorf("invalid erasure coding %q, expected data+parity shards such as 4+2", s)
+	}
+	if err := coding.validate(); err != nil {
+		return nil, err
+	}
+	return &coding, nil
+}
+
+// String formats the layout as ParseErasure accepts it
+func (coding ErasureCoding) String() string {
+	return fmt.Sprintf("%d+%d", coding.DataShards, coding.ParityShards)
+}
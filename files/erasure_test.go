@@ -0,0 +1,163 @@
+package files
+
+import (
+	"bytes"
+	"d7024e/kademlia"
+	"d7024e/kademlia/kademliatest"
+	"errors"
+	"testing"
+)
+
+// deleteChunks deletes the given chunks of manifest from every node
+func deleteChunks(t *testing.T, node *kademlia.Kademlia, manifest *Manifest, token []byte, indexes ...int) {
+	for _, index := range indexes {
+		key, _ := kademlia.ParseKey(manifest.Chunks[index])
+		if deleted, _ := node.DeleteValue(key.KademliaID(node.RoutingTable.IDLength()), token); deleted == 0 {
+			t.Fatalf("Expected chunk %d to be deleted", index)
+		}
+	}
+}
+
+func TestPutErasure_ThenGet(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 3)
+	data := randomData(t, 5*ChunkSize+100)
+
+	result, err := PutErasure(nodes[0], data, kademlia.SHA1, 2, 2, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// 3 stripes of 2 data and 2 parity shards, and the manifest
+	if result.Chunks != 13 {
+		t.Errorf("Expected 13 values, got %d", result.Chunks)
+	}
+
+	var progress []int
+	fetched, _, err := Get(nodes[2], result.Key, func(done, total int) { progress = append(progress, done) })
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !bytes.Equal(fetched, data) {
+		t.Error("Expected fetched data to equal the stored data")
+	}
+	if len(progress) != 3 {
+		t.Errorf("Expected progress for 3 stripes, got %v", progress)
+	}
+}
+
+func TestPutErasure_StoresEveryShardOnce(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 6)
+	result, err := PutErasure(nodes[0], randomData(t, 3*ChunkSize), kademlia.SHA1, 2, 1, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, manifest, _, err := resolve(nodes[1], result.Key)
+	if err != nil || manifest == nil {
+		t.Fatalf("Expected a manifest, got %v (%v)", manifest, err)
+	}
+
+	copies := make(map[string]int)
+	for _, node := range nodes {
+		for _, value := range node.StoredValues() {
+			copies[value.Hash]++
+		}
+	}
+	for i, chunk := range manifest.Chunks {
+		key, _ := kademlia.ParseKey(chunk)
+		if count := copies[key.KademliaID(nodes[0].RoutingTable.IDLength()).String()]; count != ShardReplicas {
+			t.Errorf("Expected shard %d on %d node, got %d", i, ShardReplicas, count)
+		}
+	}
+}
+
+func TestPutErasure_SpreadsStripeOverNodes(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 8)
+	result, err := PutErasure(nodes[0], randomData(t, 6*ChunkSize), kademlia.SHA1, 3, 2, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, manifest, _, err := resolve(nodes[1], result.Key)
+	if err != nil || manifest == nil {
+		t.Fatalf("Expected a manifest, got %v (%v)", manifest, err)
+	}
+
+	holders := make(map[string]int)
+	for i, node := range nodes {
+		for _, value := range node.StoredValues() {
+			holders[value.Hash] = i
+		}
+	}
+	shards := manifest.Erasure.shards()
+	for stripe := range manifest.stripes() {
+		used := make(map[int]bool)
+		for i := stripe * shards; i < (stripe+1)*shards; i++ {
+			key, _ := kademlia.ParseKey(manifest.Chunks[i])
+			holder := holders[key.KademliaID(nodes[0].RoutingTable.IDLength()).String()]
+			if used[holder] {
+				t.Errorf("Expected the shards of stripe %d on different nodes, node %d holds several", stripe, holder)
+			}
+			used[holder] = true
+		}
+	}
+}
+
+func TestPutErasure_ReconstructsLostShards(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 3)
+	data := randomData(t, 3*ChunkSize)
+	result, err := PutErasure(nodes[0], data, kademlia.SHA1, 2, 2, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, manifest, _, err := resolve(nodes[1], result.Key)
+	if err != nil || manifest == nil {
+		t.Fatalf("Expected a manifest, got %v (%v)", manifest, err)
+	}
+
+	// both data shards of the first stripe and a parity shard of the second
+	deleteChunks(t, nodes[0], manifest, result.DeleteToken, 0, 1, 6)
+	fetched, _, err := Get(nodes[1], result.Key, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !bytes.Equal(fetched, data) {
+		t.Error("Expected the lost shards to be reconstructed")
+	}
+
+	deleteChunks(t, nodes[0], manifest, result.DeleteToken, 2)
+	if _, _, err := Get(nodes[1], result.Key, nil); !errors.Is(err, ErrTooFewShards) {
+		t.Errorf("Expected ErrTooFewShards, got %v", err)
+	}
+}
+
+func TestPutErasure_SmallValue(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 3)
+
+	result, err := PutErasure(nodes[0], []byte("data1"), kademlia.SHA1, 4, 2, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	fetched, _, err := Get(nodes[1], result.Key, nil)
+	if err != nil || string(fetched) != "data1" {
+		t.Errorf("Expected data1, got %q (%v)", fetched, err)
+	}
+}
+
+func TestPutErasure_InvalidShards(t *testing.T) {
+	if _, err := PutErasure(nil, []byte("data1"), kademlia.SHA1, 2, 0, nil); err == nil {
+		t.Error("Expected an error without parity shards")
+	}
+}
+
+func TestParseErasure(t *testing.T) {
+	coding, err := ParseErasure("4+2")
+	if err != nil || coding.DataShards != 4 || coding.ParityShards != 2 {
+		t.Errorf("Expected 4+2, got %v (%v)", coding, err)
+	}
+	if coding.String() != "4+2" {
+		t.Errorf("Expected 4+2, got %s", coding)
+	}
+	for _, invalid := range []string{"", "4", "4+0", "0+2", "a+b", "4+2+1"} {
+		if _, err := ParseErasure(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
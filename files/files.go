@@ -0,0 +1,347 @@
+// Package files stores data of any size in the DHT. Data that does not fit
+// in a single value is split into fixed-size chunks that are stored under
+// their own hashes, and a manifest listing the chunks together with the
+// Merkle root over them is stored under the returned key.
+package files
+
+import (
+	"bytes"
+	"d7024e/kademlia"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ChunkSize is the size of every chunk but the last
+const ChunkSize = kademlia.MaxValueSize
+
+// Parallelism is how many chunks are fetched at the same time
+const Parallelism = 8
+
+// manifestMagic starts every manifest so that GET can tell it from a plain value
+var manifestMagic = []byte("KADEMLIA-MANIFEST\n")
+
+// ErrInvalidManifest is returned for manifests whose chunk list does not
+// match their size or Merkle root
+var ErrInvalidManifest = errors.New("invalid manifest")
+
+// Progress is called after every chunk with how many of total are done
+type Progress func(done int, total int)
+
+// Manifest lists the chunks whose contents make up a file. Root is the
+// Merkle root over the chunk keys. If Nested is set, the chunks make up
+// another manifest rather than the file itself, which is how files with
+// more chunks than fit in one manifest are stored. If Erasure is set, the
+// chunks are Reed-Solomon shards rather than the file split in order.
+type Manifest struct {
+	Size      int64          `json:"size"`
+	ChunkSize int            `json:"chunk_size"`
+	Chunks    []string       `json:"chunks"`
+	Root      string         `json:"root"`
+	Nested    bool           `json:"nested,omitempty"`
+	Erasure   *ErasureCoding `json:"erasure,omitempty"`
+}
+
+// PutResult is the key of a stored file and the token that deletes every
+// value it was stored as
+type PutResult struct {
+	Key         kademlia.Multihash
+	DeleteToken []byte
+	Chunks      int
+}
+
+// Put stores data under a key that Get accepts. Data larger than one value
+// is chunked. progress may be nil.
+func Put(node *kademlia.Kademlia, data []byte, hashFunc kademlia.HashFunc, progress Progress) (PutResult, error) {
+	token, _ := kademlia.NewDeleteToken()
+	result := PutResult{DeleteToken: token}
+	key, err := put(node, data, hashFunc, token, false, progress, &result)
+	result.Key = key
+	return result, err
+}
+
+// put stores data as a single value when it fits, or as chunks and a manifest
+func put(node *kademlia.Kademlia, data []byte, hashFunc kademlia.HashFunc, token []byte, nested bool, progress Progress, result *PutResult) (kademlia.Multihash, error) {
+	if len(data) <= kademlia.MaxValueSize && !nested && !IsManifest(data) {
+		return putValue(node, data, hashFunc, token, result)
+	}
+
+	manifest := Manifest{Size: int64(len(data)), ChunkSize: ChunkSize, Nested: nested}
+	var keys []kademlia.Multihash
+	total := chunkCount(manifest.Size, ChunkSize)
+	for start := 0; start < len(data); start += ChunkSize {
+		chunk := data[start:min(start+ChunkSize, len(data))]
+		key, err := putValue(node, chunk, hashFunc, token, result)
+		if err != nil {
+			return nil, fmt.Errorf("storing chunk %d of %d: %w", len(keys)+1, total, err)
+		}
+		keys = append(keys, key)
+		manifest.Chunks = append(manifest.Chunks, key.String())
+		if progress != nil {
+			progress(len(keys), total)
+		}
+	}
+	manifest.Root = hex.EncodeToString(merkleRoot(keys))
+
+	encoded, err := manifest.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	if len(encoded) > kademlia.MaxValueSize {
+		// the manifest itself is stored as chunks, listed by a nested manifest
+		return put(node, encoded, hashFunc, token, true, nil, result)
+	}
+	return putValue(node, encoded, hashFunc, token, result)
+}
+
+func putValue(node *kademlia.Kademlia, data []byte, hashFunc kademlia.HashFunc, token []byte, result *PutResult) (kademlia.Multihash, error) {
+	stored, err := node.PutValueWithToken(data, hashFunc, token)
+	if err != nil {
+		return nil, err
+	}
+	result.Chunks++
+	return stored.Key, nil
+}
+
+// Get fetches the data stored under key by Put, following manifests. It
+// also returns the contact the value under key was found on. progress may
+// be nil.
+func Get(node *kademlia.Kademlia, key kademlia.Multihash, progress Progress) ([]byte, kademlia.Contact, error) {
+	data, manifest, foundOn, err := resolve(node, key)
+	if err != nil || manifest == nil {
+		return data, foundOn, err
+	}
+	data, err = fetchAll(node, manifest, progress)
+	return data, foundOn, err
+}
+
+// resolve fetches the value under key and follows nested manifests. It
+// returns either the value itself, or the manifest that lists the chunks
+// of the file.
+func resolve(node *kademlia.Kademlia, key kademlia.Multihash) ([]byte, *Manifest, kademlia.Contact, error) {
+	data, foundOn, err := node.GetValue(key)
+	if err != nil {
+		return nil, nil, foundOn, err
+	}
+	for IsManifest(data) {
+		manifest := &Manifest{}
+		if err := manifest.UnmarshalBinary(data); err != nil {
+			return nil, nil, foundOn, err
+		}
+		if !manifest.Nested {
+			return nil, manifest, foundOn, nil
+		}
+		if data, err = fetchAll(node, manifest, nil); err != nil {
+			return nil, nil, foundOn, err
+		}
+	}
+	return data, nil, foundOn, nil
+}
+
+// IsManifest returns true if value is a manifest rather than plain data
+func IsManifest(value []byte) bool {
+	return bytes.HasPrefix(value, manifestMagic)
+}
+
+// fetchAll fetches every chunk listed in manifest and joins them. Size
+// comes from the DHT, so nothing is allocated before the manifest is
+// verified.
+func fetchAll(node *kademlia.Kademlia, manifest *Manifest, progress Progress) ([]byte, error) {
+	if err := manifest.Verify(); err != nil {
+		return nil, err
+	}
+	if manifest.Erasure != nil {
+		return fetchErasure(node, manifest, progress)
+	}
+	data := make([]byte, manifest.Size)
+	err := fetchChunks(node, manifest, nil, func(index int, chunk []byte) error {
+		copy(data[int64(index)*int64(manifest.ChunkSize):], chunk)
+		return nil
+	}, progress)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// fetchChunks fetches the chunks listed in manifest with Parallelism
+// workers and passes each one to save once it has been verified against
+// its key. Chunks for which have returns true are skipped. save and
+// progress are only called from the calling goroutine.
+func fetchChunks(node *kademlia.Kademlia, manifest *Manifest, have func(index int) bool, save func(index int, chunk []byte) error, progress Progress) error {
+	keys, err := manifest.keys()
+	if err != nil {
+		return err
+	}
+
+	done := 0
+	var missing []int
+	for index := range keys {
+		if have != nil && have(index) {
+			done++
+		} else {
+			missing = append(missing, index)
+		}
+	}
+	if progress != nil && done > 0 {
+		progress(done, len(keys))
+	}
+
+	type fetched struct {
+		index int
+		data  []byte
+		err   error
+	}
+	indexes := make(chan int)
+	results := make(chan fetched)
+	stop := make(chan struct{})
+	var waitGroup sync.WaitGroup
+	for range min(Parallelism, len(missing)) {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			for index := range indexes {
+				data, err := fetchChunk(node, manifest, keys, index)
+				select {
+				case results <- fetched{index, data, err}:
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(indexes)
+		for _, index := range missing {
+			select {
+			case indexes <- index:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		waitGroup.Wait()
+	}()
+
+	for range missing {
+		result := <-results
+		if result.err != nil {
+			return result.err
+		}
+		if err := save(result.index, result.data); err != nil {
+			return err
+		}
+		done++
+		if progress != nil {
+			progress(done, len(keys))
+		}
+	}
+	return nil
+}
+
+// fetchChunk fetches chunk index and checks it against its key and the
+// size the manifest gives it. Shards are looked up near their placement.
+func fetchChunk(node *kademlia.Kademlia, manifest *Manifest, keys []kademlia.Multihash, index int) ([]byte, error) {
+	var data []byte
+	var err error
+	if manifest.Erasure != nil {
+		data, _, err = node.GetValueNear(keys[index], manifest.shardPlacement(index, node.RoutingTable.IDLength()))
+	} else {
+		data, _, err = node.GetValue(keys[index])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("fetching chunk %d of %d: %w", index+1, len(keys), err)
+	}
+	if !keys[index].Verify(data) || len(data) != manifest.chunkLength(index) {
+		return nil, fmt.Errorf("chunk %d of %d does not match the manifest", index+1, len(keys))
+	}
+	return data, nil
+}
+
+// Verify checks that the chunks add up to Size and that Root is the
+// Merkle root over them
+func (manifest *Manifest) Verify() error {
+	_, err := manifest.keys()
+	return err
+}
+
+// keys parses the chunk keys and verifies them against the rest of the
+// manifest
+func (manifest *Manifest) keys() ([]kademlia.Multihash, error) {
+	if manifest.ChunkSize <= 0 || manifest.ChunkSize > kademlia.MaxValueSize {
+		return nil, fmt.Errorf("%w: chunk size %d", ErrInvalidManifest, manifest.ChunkSize)
+	}
+	if err := manifest.Erasure.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidManifest, err)
+	}
+	if manifest.Size < 0 || manifest.chunkTotal() != len(manifest.Chunks) {
+		return nil, fmt.Errorf("%w: %d chunks for %d bytes", ErrInvalidManifest, len(manifest.Chunks), manifest.Size)
+	}
+	keys := make([]kademlia.Multihash, len(manifest.Chunks))
+	for i, chunk := range manifest.Chunks {
+		key, err := kademlia.ParseKey(chunk)
+		if err != nil {
+			return nil, fmt.Errorf("%w: chunk key: %v", ErrInvalidManifest, err)
+		}
+		keys[i] = key
+	}
+	if hex.EncodeToString(merkleRoot(keys)) != manifest.Root {
+		return nil, fmt.Errorf("%w: Merkle root does not match the chunks", ErrInvalidManifest)
+	}
+	return keys, nil
+}
+
+// chunkTotal is how many chunks the manifest should list for its size
+func (manifest *Manifest) chunkTotal() int {
+	if manifest.Erasure != nil {
+		return manifest.stripes() * manifest.Erasure.shards()
+	}
+	return chunkCount(manifest.Size, manifest.ChunkSize)
+}
+
+// chunkLength is the size of chunk index, only the last one may be shorter
+func (manifest *Manifest) chunkLength(index int) int {
+	if manifest.Erasure != nil {
+		return manifest.shardLength(index / manifest.Erasure.shards())
+	}
+	start := int64(index) * int64(manifest.ChunkSize)
+	return int(min(int64(manifest.ChunkSize), manifest.Size-start))
+}
+
+// chunkCount is how many chunks of chunkSize it takes to hold size bytes
+func chunkCount(size int64, chunkSize int) int {
+	return int((size + int64(chunkSize) - 1) / int64(chunkSize))
+}
+
+// merkleRoot is the Merkle root over the multihash bytes of keys
+func merkleRoot(keys []kademlia.Multihash) []byte {
+	leaves := make([][]byte, len(keys))
+	for i, key := range keys {
+		leaves[i] = key
+	}
+	return MerkleRoot(leaves)
+}
+
+// MarshalBinary encodes the manifest as it is stored in the DHT
+func (manifest Manifest) MarshalBinary() ([]byte, error) {
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{}, manifestMagic...), encoded...), nil
+}
+
+// UnmarshalBinary decodes a manifest stored in the DHT
+func (manifest *Manifest) UnmarshalBinary(data []byte) error {
+	if !IsManifest(data) {
+		return fmt.Errorf("not a manifest")
+	}
+	if err := json.Unmarshal(data[len(manifestMagic):], manifest); err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package files
+
+import (
+	"bytes"
+	"crypto/rand"
+	"d7024e/kademlia"
+	"d7024e/kademlia/kademliatest"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func randomData(t *testing.T, size int) []byte {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return data
+}
+
+func TestPut_SmallValueIsStoredAsIs(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 3)
+
+	result, err := Put(nodes[0], []byte("data1"), kademlia.SHA1, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Chunks != 1 {
+		t.Errorf("Expected 1 value, got %d", result.Chunks)
+	}
+	expected, _ := kademlia.Sum([]byte("data1"), kademlia.SHA1)
+	if result.Key.String() != expected.String() {
+		t.Errorf("Expected key %s, got %s", expected, result.Key)
+	}
+}
+
+func TestPutGet_LargeFile(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 3)
+	data := randomData(t, 3*ChunkSize+100)
+
+	var putProgress []int
+	result, err := Put(nodes[0], data, kademlia.SHA1, func(done, total int) {
+		if total != 4 {
+			t.Errorf("Expected 4 chunks in total, got %d", total)
+		}
+		putProgress = append(putProgress, done)
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Chunks != 5 || len(putProgress) != 4 {
+		t.Errorf("Expected 4 chunks and a manifest, got %d values and progress %v", result.Chunks, putProgress)
+	}
+
+	var getProgress int
+	fetched, _, err := Get(nodes[2], result.Key, func(done, total int) { getProgress = done })
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !bytes.Equal(fetched, data) {
+		t.Error("Expected fetched data to equal the stored data")
+	}
+	if getProgress != 4 {
+		t.Errorf("Expected progress up to 4 chunks, got %d", getProgress)
+	}
+}
+
+func TestPutGet_NestedManifest(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 3)
+	data := randomData(t, 100*ChunkSize)
+
+	result, err := Put(nodes[0], data, kademlia.SHA1, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Chunks <= 101 {
+		t.Errorf("Expected the manifest to be chunked, got %d values", result.Chunks)
+	}
+
+	fetched, _, err := Get(nodes[1], result.Key, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !bytes.Equal(fetched, data) {
+		t.Error("Expected fetched data to equal the stored data")
+	}
+}
+
+func TestPutGet_ValueThatLooksLikeManifest(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 3)
+	data := append(append([]byte{}, manifestMagic...), []byte(`{"size":1}`)...)
+
+	result, err := Put(nodes[0], data, kademlia.SHA1, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	fetched, _, err := Get(nodes[1], result.Key, nil)
+	if err != nil || !bytes.Equal(fetched, data) {
+		t.Errorf("Expected %q, got %q (%v)", data, fetched, err)
+	}
+}
+
+func TestManifest_UnmarshalBinary_NotAManifest(t *testing.T) {
+	var manifest Manifest
+	if err := manifest.UnmarshalBinary([]byte("data1")); err == nil {
+		t.Error("Expected an error for a plain value")
+	}
+}
+
+func TestManifest_Verify(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 3)
+	result, err := Put(nodes[0], randomData(t, 2*ChunkSize+1), kademlia.SHA1, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, manifest, _, err := resolve(nodes[1], result.Key)
+	if err != nil || manifest == nil {
+		t.Fatalf("Expected a manifest, got %v (%v)", manifest, err)
+	}
+	if err := manifest.Verify(); err != nil {
+		t.Errorf("Expected a valid manifest, got %v", err)
+	}
+
+	swapped := *manifest
+	swapped.Chunks = []string{manifest.Chunks[1], manifest.Chunks[0], manifest.Chunks[2]}
+	if err := swapped.Verify(); !errors.Is(err, ErrInvalidManifest) {
+		t.Errorf("Expected ErrInvalidManifest for reordered chunks, got %v", err)
+	}
+	truncated := *manifest
+	truncated.Size = ChunkSize
+	if err := truncated.Verify(); !errors.Is(err, ErrInvalidManifest) {
+		t.Errorf("Expected ErrInvalidManifest for a wrong size, got %v", err)
+	}
+}
+
+func TestGet_RejectsManifestWithWrongSize(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 3)
+	chunk, err := nodes[0].PutValue([]byte("data1"), kademlia.SHA1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	root := hex.EncodeToString(merkleRoot([]kademlia.Multihash{chunk.Key}))
+
+	for _, manifest := range []Manifest{
+		{Size: 1 << 62, ChunkSize: ChunkSize, Chunks: []string{chunk.Key.String()}, Root: root},
+		{Size: -1, ChunkSize: ChunkSize, Chunks: []string{chunk.Key.String()}, Root: root},
+		{Size: 1 << 62, ChunkSize: ChunkSize, Chunks: []string{chunk.Key.String()}, Root: root, Nested: true},
+	} {
+		encoded, _ := manifest.MarshalBinary()
+		stored, err := nodes[0].PutValue(encoded, kademlia.SHA1)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, _, err := Get(nodes[1], stored.Key, nil); !errors.Is(err, ErrInvalidManifest) {
+			t.Errorf("Expected ErrInvalidManifest for size %d, got %v", manifest.Size, err)
+		}
+	}
+}
+
+func TestDownload_WritesFile(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 3)
+	data := randomData(t, 5*ChunkSize+7)
+	result, err := Put(nodes[0], data, kademlia.SHA1, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "file.bin")
+	size, _, err := Download(nodes[2], result.Key, path, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	written, _ := os.ReadFile(path)
+	if size != int64(len(data)) || !bytes.Equal(written, data) {
+		t.Errorf("Expected %d bytes written, got %d", len(data), size)
+	}
+	if _, err := os.Stat(path + partialSuffix); !os.IsNotExist(err) {
+		t.Error("Expected the partial file to be removed")
+	}
+}
+
+func TestDownload_ResumesPartialFile(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 3)
+	data := randomData(t, 5*ChunkSize+7)
+	result, err := Put(nodes[0], data, kademlia.SHA1, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// the first two chunks arrived, the third was only partly written
+	path := filepath.Join(t.TempDir(), "file.bin")
+	partial := append(append([]byte{}, data[:2*ChunkSize]...), make([]byte, 100)...)
+	if err := os.WriteFile(path+partialSuffix, partial, 0644); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var progress []int
+	if _, _, err := Download(nodes[1], result.Key, path, func(done, total int) { progress = append(progress, done) }); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(progress) != 5 || progress[0] != 2 || progress[4] != 6 {
+		t.Errorf("Expected 2 chunks resumed and 4 fetched, got progress %v", progress)
+	}
+	written, _ := os.ReadFile(path)
+	if !bytes.Equal(written, data) {
+		t.Error("Expected the resumed file to equal the stored data")
+	}
+}
+
+func TestDownload_SmallValue(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 3)
+	result, err := Put(nodes[0], []byte("data1"), kademlia.SHA1, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if _, _, err := Download(nodes[1], result.Key, path, nil); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if written, _ := os.ReadFile(path); string(written) != "data1" {
+		t.Errorf("Expected data1, got %q", written)
+	}
+}
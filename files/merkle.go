@@ -0,0 +1,44 @@
+package files
+
+import "crypto/sha256"
+
+// Prefixes that keep leaf hashes and inner node hashes apart, so that an
+// inner node can never be passed off as a leaf
+const (
+	merkleLeaf  = 0x00
+	merkleInner = 0x01
+)
+
+// MerkleRoot returns the root of the binary Merkle tree over leaves. A
+// node without a sibling is carried up to the next level unchanged. The
+// root of no leaves is the hash of the empty leaf.
+func MerkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 0 {
+		return merkleHash(merkleLeaf)
+	}
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleHash(merkleLeaf, leaf)
+	}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, merkleHash(merkleInner, level[i], level[i+1]))
+		}
+		level = next
+	}
+	return level[0]
+}
+
+func merkleHash(prefix byte, parts ...[]byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte{prefix})
+	for _, part := range parts {
+		hash.Write(part)
+	}
+	return hash.Sum(nil)
+}
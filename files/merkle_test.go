@@ -0,0 +1,36 @@
+package files
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMerkleRoot_DependsOnLeavesAndOrder(t *testing.T) {
+	a, b, c := []byte("a"), []byte("b"), []byte("c")
+
+	root := MerkleRoot([][]byte{a, b, c})
+	if !bytes.Equal(root, MerkleRoot([][]byte{a, b, c})) {
+		t.Error("Expected the same root for the same leaves")
+	}
+	if bytes.Equal(root, MerkleRoot([][]byte{b, a, c})) {
+		t.Error("Expected a different root for reordered leaves")
+	}
+	if bytes.Equal(root, MerkleRoot([][]byte{a, b})) {
+		t.Error("Expected a different root without the last leaf")
+	}
+}
+
+func TestMerkleRoot_InnerNodeIsNotALeaf(t *testing.T) {
+	a, b := []byte("a"), []byte("b")
+	inner := append(merkleHash(merkleLeaf, a), merkleHash(merkleLeaf, b)...)
+
+	if bytes.Equal(MerkleRoot([][]byte{a, b}), MerkleRoot([][]byte{inner})) {
+		t.Error("Expected the concatenated children not to hash to the same root")
+	}
+}
+
+func TestMerkleRoot_Empty(t *testing.T) {
+	if len(MerkleRoot(nil)) != 32 {
+		t.Error("Expected a root for no leaves")
+	}
+}
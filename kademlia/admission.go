@@ -0,0 +1,229 @@
+package kademlia
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Rate is a token bucket: PerSecond tokens are added every second, up to
+// Burst, and every message takes one. A zero PerSecond is not limited.
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// AdmissionLimits bound how much work peers can make a node do. Messages
+// over a limit are dropped without a reply, and an IP that keeps exceeding
+// its rates is banned for a while. Zero limits are not enforced.
+type AdmissionLimits struct {
+	// PerIP limits the messages one source IP may send
+	PerIP Rate
+	// PerType limits the messages of a type, such as "FIND_NODE", one
+	// source IP may send. "HANDSHAKE" limits the encrypted sessions it
+	// may open.
+	PerType map[string]Rate
+	// Pings limits the PINGs that FIND_NODE and FIND_DATA make the node
+	// send to one IP. The sender address must be on the IP a message came
+	// from, but UDP source IPs can be forged, so without it messages with
+	// a victim's IP as their source make the node flood that victim.
+	Pings Rate
+	// MaxInFlight caps the messages that are handled at once, the ones
+	// that arrive while it is reached are dropped
+	MaxInFlight int
+	// BanAfter is how many messages of an IP may be dropped for exceeding
+	// a rate before the IP is banned for BanDuration. Zero never bans.
+	BanAfter    int
+	BanDuration time.Duration
+}
+
+// Reasons a message is dropped, as counted by the
+// kademlia_admission_dropped_total metric
+const (
+	DroppedBanned   = "banned"
+	DroppedIPRate   = "ip_rate"
+	DroppedTypeRate = "type_rate"
+	DroppedPingRate = "ping_rate"
+	DroppedInFlight = "in_flight"
+)
+
+// admissionPruneInterval is how often peers that sent nothing for as long
+// are forgotten
+const admissionPruneInterval = time.Minute
+
+// admission keeps the token buckets and bans of the peers of a node
+type admission struct {
+	mutex     sync.Mutex
+	limits    AdmissionLimits
+	peers     map[string]*peerAdmission
+	lastPrune time.Time
+	inFlight  atomic.Int64
+}
+
+type peerAdmission struct {
+	messages    tokenBucket
+	types       map[string]*tokenBucket
+	pings       tokenBucket
+	strikes     int
+	bannedUntil time.Time
+	lastSeen    time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take removes a token from the bucket if it has one, after refilling it
+// for the time since the last call
+func (bucket *tokenBucket) take(rate Rate, now time.Time) bool {
+	if rate.PerSecond <= 0 {
+		return true
+	}
+	if bucket.last.IsZero() {
+		bucket.tokens = float64(rate.Burst)
+	} else {
+		bucket.tokens = min(float64(rate.Burst), bucket.tokens+now.Sub(bucket.last).Seconds()*rate.PerSecond)
+	}
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+func (admission *admission) setLimits(limits AdmissionLimits) {
+	admission.mutex.Lock()
+	defer admission.mutex.Unlock()
+	admission.limits = limits
+}
+
+// currentLimits returns a copy of the limits, which may be changed while
+// the node runs
+func (admission *admission) currentLimits() AdmissionLimits {
+	admission.mutex.Lock()
+	defer admission.mutex.Unlock()
+	return admission.limits
+}
+
+// allow checks a message of msgType from ip against the limits. It returns
+// the reason the message is dropped, "" if it is admitted, and whether the
+// drop got ip banned.
+func (admission *admission) allow(ip string, msgType string, now time.Time) (string, bool) {
+	admission.mutex.Lock()
+	defer admission.mutex.Unlock()
+	limits := admission.limits
+	if limits.PerIP.PerSecond <= 0 && len(limits.PerType) == 0 {
+		return "", false
+	}
+	peer := admission.peer(ip, now)
+	if now.Before(peer.bannedUntil) {
+		return DroppedBanned, false
+	}
+
+	reason := ""
+	if !peer.messages.take(limits.PerIP, now) {
+		reason = DroppedIPRate
+	} else if rate, limited := limits.PerType[msgType]; limited {
+		bucket := peer.types[msgType]
+		if bucket == nil {
+			bucket = &tokenBucket{}
+			peer.types[msgType] = bucket
+		}
+		if !bucket.take(rate, now) {
+			reason = DroppedTypeRate
+		}
+	}
+	if reason == "" {
+		return "", false
+	}
+	peer.strikes++
+	if limits.BanAfter > 0 && peer.strikes >= limits.BanAfter {
+		peer.strikes = 0
+		peer.bannedUntil = now.Add(limits.BanDuration)
+		return reason, true
+	}
+	return reason, false
+}
+
+// allowPing returns true if a PING may be sent to ip
+func (admission *admission) allowPing(ip string, now time.Time) bool {
+	admission.mutex.Lock()
+	defer admission.mutex.Unlock()
+	rate := admission.limits.Pings
+	if rate.PerSecond <= 0 {
+		return true
+	}
+	return admission.peer(ip, now).pings.take(rate, now)
+}
+
+// peer returns the state of ip, pruning idle peers now and then. The
+// mutex must be held.
+func (admission *admission) peer(ip string, now time.Time) *peerAdmission {
+	if now.Sub(admission.lastPrune) > admissionPruneInterval {
+		for other, peer := range admission.peers {
+			if now.Sub(peer.lastSeen) > admissionPruneInterval && !now.Before(peer.bannedUntil) {
+				delete(admission.peers, other)
+			}
+		}
+		admission.lastPrune = now
+	}
+	if admission.peers == nil {
+		admission.peers = make(map[string]*peerAdmission)
+	}
+	peer := admission.peers[ip]
+	if peer == nil {
+		peer = &peerAdmission{types: make(map[string]*tokenBucket)}
+		admission.peers[ip] = peer
+	}
+	peer.lastSeen = now
+	return peer
+}
+
+// acquire counts a handler in flight, it returns false if MaxInFlight
+// handlers already are. Every successful acquire must be released.
+func (admission *admission) acquire() bool {
+	maxInFlight := admission.currentLimits().MaxInFlight
+	inFlight := admission.inFlight.Add(1)
+	if maxInFlight > 0 && inFlight > int64(maxInFlight) {
+		admission.inFlight.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (admission *admission) release() {
+	admission.inFlight.Add(-1)
+}
+
+// admit checks a message of msgType from addr against the admission
+// limits of the node and counts it if it is dropped
+func (network *Network) admit(addr net.Addr, msgType string) bool {
+	ip, _ := ipAndSubnet(addr.String())
+	reason, banned := network.admission.allow(ip, msgType, time.Now())
+	if reason == "" {
+		return true
+	}
+	admissionDropped.WithLabelValues(reason).Inc()
+	if banned {
+		admissionBans.Inc()
+		network.log().Warn("Banning peer that exceeds its rate", "peer", ip, "reason", reason, "duration", network.admission.currentLimits().BanDuration)
+	} else {
+		network.log().Debug("Dropping message", "type", msgType, "peer", addr.String(), "reason", reason)
+	}
+	return false
+}
+
+// pingAllowed returns true if the node may PING address in answer to a
+// message, which is not the case once it pinged its IP too often
+func (network *Network) pingAllowed(address string) bool {
+	ip, _ := ipAndSubnet(address)
+	if network.admission.allowPing(ip, time.Now()) {
+		return true
+	}
+	admissionDropped.WithLabelValues(DroppedPingRate).Inc()
+	network.log().Debug("Not pinging peer that was pinged too often", "peer", address)
+	return false
+}
@@ -0,0 +1,128 @@
+package kademlia
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTokenBucket_RefillsOverTime(t *testing.T) {
+	rate := Rate{PerSecond: 2, Burst: 2}
+	now := time.Now()
+	var bucket tokenBucket
+
+	if !bucket.take(rate, now) || !bucket.take(rate, now) {
+		t.Fatalf("Expected the burst to pass")
+	}
+	if bucket.take(rate, now) {
+		t.Fatalf("Expected the bucket to be empty after the burst")
+	}
+	if !bucket.take(rate, now.Add(500*time.Millisecond)) {
+		t.Errorf("Expected a token after half a second")
+	}
+	if !bucket.take(rate, now.Add(time.Hour)) || !bucket.take(rate, now.Add(time.Hour)) || bucket.take(rate, now.Add(time.Hour)) {
+		t.Errorf("Expected the bucket to refill up to its burst only")
+	}
+}
+
+func TestAllow_Limits(t *testing.T) {
+	now := time.Now()
+	for _, test := range []struct {
+		name    string
+		limits  AdmissionLimits
+		msgType string
+		reason  string
+	}{
+		{"IP rate", AdmissionLimits{PerIP: Rate{PerSecond: 1, Burst: 1}}, "PING", DroppedIPRate},
+		{"type rate", AdmissionLimits{PerType: map[string]Rate{"FIND_NODE": {PerSecond: 1, Burst: 1}}}, "FIND_NODE", DroppedTypeRate},
+		{"other type", AdmissionLimits{PerType: map[string]Rate{"FIND_NODE": {PerSecond: 1, Burst: 1}}}, "PING", ""},
+		{"no limits", AdmissionLimits{}, "PING", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var admission admission
+			admission.setLimits(test.limits)
+			if reason, _ := admission.allow("10.0.0.1", test.msgType, now); reason != "" {
+				t.Fatalf("Expected the first message to be admitted, got %s", reason)
+			}
+			if reason, _ := admission.allow("10.0.0.1", test.msgType, now); reason != test.reason {
+				t.Errorf("Expected reason %q, got %q", test.reason, reason)
+			}
+			if reason, _ := admission.allow("10.0.0.2", test.msgType, now); reason != "" {
+				t.Errorf("Expected another IP to be admitted, got %s", reason)
+			}
+		})
+	}
+}
+
+func TestAllow_BansUntilDurationPassed(t *testing.T) {
+	var admission admission
+	admission.setLimits(AdmissionLimits{PerIP: Rate{PerSecond: 1, Burst: 1}, BanAfter: 2, BanDuration: time.Minute})
+	now := time.Now()
+
+	admission.allow("10.0.0.1", "PING", now)
+	if _, banned := admission.allow("10.0.0.1", "PING", now); banned {
+		t.Fatalf("Expected no ban after the first dropped message")
+	}
+	if _, banned := admission.allow("10.0.0.1", "PING", now); !banned {
+		t.Fatalf("Expected a ban after the second dropped message")
+	}
+	if reason, _ := admission.allow("10.0.0.1", "PING", now.Add(30*time.Second)); reason != DroppedBanned {
+		t.Errorf("Expected the IP to stay banned, got %q", reason)
+	}
+	if reason, _ := admission.allow("10.0.0.1", "PING", now.Add(2*time.Minute)); reason != "" {
+		t.Errorf("Expected the ban to expire, got %q", reason)
+	}
+}
+
+func TestAllowPing_LimitsPerIP(t *testing.T) {
+	var admission admission
+	admission.setLimits(AdmissionLimits{Pings: Rate{PerSecond: 1, Burst: 1}})
+	now := time.Now()
+
+	if !admission.allowPing("10.0.0.1", now) {
+		t.Fatalf("Expected the first PING to be allowed")
+	}
+	if admission.allowPing("10.0.0.1", now) {
+		t.Errorf("Expected the second PING to the same IP to be refused")
+	}
+	if !admission.allowPing("10.0.0.2", now) {
+		t.Errorf("Expected a PING to another IP to be allowed")
+	}
+}
+
+func TestAcquire_CapsInFlight(t *testing.T) {
+	var admission admission
+	admission.setLimits(AdmissionLimits{MaxInFlight: 2})
+
+	if !admission.acquire() || !admission.acquire() {
+		t.Fatalf("Expected two handlers to be admitted")
+	}
+	if admission.acquire() {
+		t.Fatalf("Expected a third handler to be refused")
+	}
+	admission.release()
+	if !admission.acquire() {
+		t.Errorf("Expected a handler to be admitted once another finished")
+	}
+}
+
+func TestListen_DropsAndBansPeerOverRate(t *testing.T) {
+	node := newIdentityTestNode(t, Config{Admission: AdmissionLimits{
+		PerIP:       Rate{PerSecond: 0.01, Burst: 1},
+		BanAfter:    1,
+		BanDuration: time.Hour,
+	}})
+	peer := newTestNode(t)
+	bans := testutil.ToFloat64(admissionBans)
+
+	if !peer.Network.SendPingMessage(&peer.RoutingTable.Me, &node.RoutingTable.Me) {
+		t.Fatalf("Expected the first PING to be answered")
+	}
+	if peer.Network.SendPingMessage(&peer.RoutingTable.Me, &node.RoutingTable.Me) {
+		t.Errorf("Expected the PING over the rate to be dropped")
+	}
+	if got := testutil.ToFloat64(admissionBans); got != bans+1 {
+		t.Errorf("Expected the peer to be banned once, got %v bans", got-bans)
+	}
+}
@@ -43,7 +43,12 @@ func (bucket *bucket) AddContact(contact Contact) (bool, *Contact) {
 		return true, &lastContact
 
 	} else {
-		//moving found contact to front of list
+		//moving found contact to front of list, learning the proof of its ID
+		if contact.Proof != nil {
+			known := element.Value.(Contact)
+			known.Proof = contact.Proof
+			element.Value = known
+		}
 		bucket.list.MoveToFront(element)
 		return false, nil
 	}
@@ -0,0 +1,208 @@
+package kademlia
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression names the encoding of the Data of a STORE or of a FIND_DATA
+// reply. Keys are always the hash of the uncompressed value, which nodes
+// store in the encoding it arrived in.
+type Compression string
+
+const (
+	CompressionNone Compression = ""
+	CompressionZstd Compression = "zstd"
+	CompressionGzip Compression = "gzip"
+)
+
+// supportedCompressions are the encodings this node can decode, in order
+// of preference
+var supportedCompressions = []Compression{CompressionZstd, CompressionGzip}
+
+// minCompressSize is the smallest value worth compressing
+const minCompressSize = 128
+
+// maxDecompressedSize is the largest value a compressed value of at most
+// MaxValueSize may decompress to
+const maxDecompressedSize = 16 * MaxValueSize
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+)
+
+// compress encodes data with compression
+func compress(data []byte, compression Compression) ([]byte, error) {
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionZstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case CompressionGzip:
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// decompress decodes data that was encoded with compression. Values
+// larger than maxDecompressedSize once decompressed are refused.
+func decompress(data []byte, compression Compression) ([]byte, error) {
+	var decoded []byte
+	var err error
+	switch compression {
+	case CompressionNone:
+		return data, nil
+	case CompressionZstd:
+		decoded, err = zstdDecoder.DecodeAll(data, nil)
+	case CompressionGzip:
+		var reader *gzip.Reader
+		if reader, err = gzip.NewReader(bytes.NewReader(data)); err == nil {
+			decoded, err = io.ReadAll(io.LimitReader(reader, maxDecompressedSize+1))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s value: %w", compression, err)
+	}
+	if len(decoded) > maxDecompressedSize {
+		return nil, ErrValueTooLarge
+	}
+	return decoded, nil
+}
+
+// compressFor compresses data with the first encoding both this node and
+// a peer accepting accepted support. Data is sent as it is when it is
+// small, nothing is supported by both, or compressing does not shrink it.
+func (network *Network) compressFor(data []byte, accepted []Compression) ([]byte, Compression) {
+	if len(data) < minCompressSize {
+		return data, CompressionNone
+	}
+	for _, compression := range network.acceptedCompressions() {
+		if !slices.Contains(accepted, compression) {
+			continue
+		}
+		compressed, err := compress(data, compression)
+		if err != nil || len(compressed) >= len(data) {
+			return data, CompressionNone
+		}
+		return compressed, compression
+	}
+	return data, CompressionNone
+}
+
+// recompressFor returns a value stored encoded with compression in the
+// encoding for a peer accepting accepted. Every node decodes the supported
+// compressions, so the value is sent as stored when it would not fit in a
+// datagram in the encoding the peer asked for.
+func (network *Network) recompressFor(data []byte, compression Compression, accepted []Compression) ([]byte, Compression) {
+	if compression == CompressionNone {
+		return network.compressFor(data, accepted)
+	}
+	if slices.Contains(accepted, compression) {
+		return data, compression
+	}
+	value, err := decompress(data, compression)
+	if err != nil {
+		return data, compression
+	}
+	if recompressed, recompression := network.compressFor(value, accepted); len(recompressed) <= MaxValueSize {
+		return recompressed, recompression
+	}
+	return data, compression
+}
+
+// acceptedCompressions are the encodings advertised to peers, none if
+// compression is disabled
+func (network *Network) acceptedCompressions() []Compression {
+	if network.compressionDisabled {
+		return nil
+	}
+	return supportedCompressions
+}
+
+// maxPeerCompressions caps the peers whose encodings are remembered, the
+// one seen longest ago is forgotten to make room for another
+const maxPeerCompressions = 1024
+
+// peerCompressionsTTL is how long the encodings a peer advertised are
+// remembered
+const peerCompressionsTTL = time.Hour
+
+// peerCompressions remembers the encodings each peer advertised
+type peerCompressions struct {
+	mutex sync.Mutex
+	peers map[string]advertisedCompressions
+}
+
+type advertisedCompressions struct {
+	accepted []Compression
+	seen     time.Time
+}
+
+// observedAddress returns the address that a message received from addr
+// and claiming to be sent by claimed can be answered at: the port the
+// sender claims to listen on at the IP the message came from
+func observedAddress(claimed string, addr net.Addr) string {
+	ip, _ := ipAndSubnet(addr.String())
+	_, port, err := net.SplitHostPort(claimed)
+	if err != nil {
+		return addr.String()
+	}
+	return net.JoinHostPort(ip, port)
+}
+
+// rememberCompressions records the encodings advertised by the peer at address
+func (network *Network) rememberCompressions(address string, accepted []Compression) {
+	peers := &network.peerCompressions
+	peers.mutex.Lock()
+	defer peers.mutex.Unlock()
+	if peers.peers == nil {
+		peers.peers = make(map[string]advertisedCompressions)
+	}
+	now := time.Now()
+	if _, found := peers.peers[address]; !found && len(peers.peers) >= maxPeerCompressions {
+		oldest := ""
+		for other, advertised := range peers.peers {
+			if now.Sub(advertised.seen) > peerCompressionsTTL {
+				delete(peers.peers, other)
+			} else if oldest == "" || advertised.seen.Before(peers.peers[oldest].seen) {
+				oldest = other
+			}
+		}
+		if len(peers.peers) >= maxPeerCompressions {
+			delete(peers.peers, oldest)
+		}
+	}
+	peers.peers[address] = advertisedCompressions{accepted: accepted, seen: now}
+}
+
+// compressionsOf returns the encodings the peer at address advertised
+func (network *Network) compressionsOf(address string) []Compression {
+	peers := &network.peerCompressions
+	peers.mutex.Lock()
+	defer peers.mutex.Unlock()
+	advertised, found := peers.peers[address]
+	if !found || time.Since(advertised.seen) > peerCompressionsTTL {
+		return nil
+	}
+	return advertised.accepted
+}
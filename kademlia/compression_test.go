@@ -0,0 +1,253 @@
+package kademlia
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+var compressibleValue = bytes.Repeat([]byte("level=info msg=\"stored value\"\n"), 100)
+
+func TestCompress_RoundTrip(t *testing.T) {
+	for _, compression := range supportedCompressions {
+		compressed, err := compress(compressibleValue, compression)
+		if err != nil {
+			t.Fatalf("Expected no error for %s, got %v", compression, err)
+		}
+		if len(compressed) >= len(compressibleValue) {
+			t.Errorf("Expected %s to shrink the value, got %d bytes", compression, len(compressed))
+		}
+		decompressed, err := decompress(compressed, compression)
+		if err != nil || !bytes.Equal(decompressed, compressibleValue) {
+			t.Errorf("Expected the value back from %s, got %d bytes (%v)", compression, len(decompressed), err)
+		}
+	}
+}
+
+func TestDecompress_RejectsLargeValues(t *testing.T) {
+	for _, compression := range supportedCompressions {
+		compressed, _ := compress(make([]byte, maxDecompressedSize+1), compression)
+		if _, err := decompress(compressed, compression); err == nil {
+			t.Errorf("Expected %s value above maxDecompressedSize to be rejected", compression)
+		}
+	}
+	if _, err := decompress([]byte("data"), "brotli"); err == nil {
+		t.Error("Expected an unsupported compression to be rejected")
+	}
+}
+
+func TestCompressFor(t *testing.T) {
+	network := &Network{}
+	random := make([]byte, 1024)
+	rand.Read(random)
+
+	if _, compression := network.compressFor(compressibleValue, []Compression{CompressionGzip}); compression != CompressionGzip {
+		t.Errorf("Expected gzip, the only encoding the peer accepts, got %q", compression)
+	}
+	if _, compression := network.compressFor(compressibleValue, supportedCompressions); compression != CompressionZstd {
+		t.Errorf("Expected zstd to be preferred, got %q", compression)
+	}
+	if _, compression := network.compressFor(compressibleValue, nil); compression != CompressionNone {
+		t.Errorf("Expected no compression for a peer that accepts none, got %q", compression)
+	}
+	if _, compression := network.compressFor([]byte("short"), supportedCompressions); compression != CompressionNone {
+		t.Errorf("Expected short values to be sent as they are, got %q", compression)
+	}
+	if data, compression := network.compressFor(random, supportedCompressions); compression != CompressionNone || !bytes.Equal(data, random) {
+		t.Errorf("Expected incompressible values to be sent as they are, got %q", compression)
+	}
+
+	network.compressionDisabled = true
+	if _, compression := network.compressFor(compressibleValue, supportedCompressions); compression != CompressionNone {
+		t.Errorf("Expected no compression when disabled, got %q", compression)
+	}
+}
+
+func TestSendStoreMessage_CompressesForPeer(t *testing.T) {
+	node := newTestNode(t)
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer peer.Close()
+	peerContact := NewContact(NewRandomKademliaID(), peer.LocalAddr().String())
+	node.Network.rememberCompressions(peerContact.Address, []Compression{CompressionGzip})
+
+	go node.Network.SendStoreMessage(&node.RoutingTable.Me, &peerContact, NewRandomKademliaID(), compressibleValue, nil)
+
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	var buffer [8192]byte
+	n, _, err := peer.ReadFrom(buffer[:])
+	if err != nil {
+		t.Fatalf("Expected a STORE, got %v", err)
+	}
+	var msg Message
+	json.Unmarshal(buffer[:n], &msg)
+	if msg.Compression != CompressionGzip || len(msg.Data) >= len(compressibleValue) {
+		t.Fatalf("Expected a gzip compressed STORE, got %q with %d bytes", msg.Compression, len(msg.Data))
+	}
+	if len(msg.AcceptCompression) == 0 {
+		t.Error("Expected the STORE to advertise accepted compressions")
+	}
+	if data, err := decompress(msg.Data, msg.Compression); err != nil || !bytes.Equal(data, compressibleValue) {
+		t.Errorf("Expected the value back, got %v", err)
+	}
+}
+
+func TestPutValue_Compressed(t *testing.T) {
+	nodes := newTestNetwork(t, 3)
+
+	result, err := nodes[0].PutValue(compressibleValue, SHA1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if compressions := nodes[0].Network.compressionsOf(nodes[1].RoutingTable.Me.Address); len(compressions) == 0 {
+		t.Error("Expected the lookup to learn the compressions of the peer")
+	}
+	for _, value := range nodes[1].StoredValues() {
+		if !bytes.Equal(value.Data, compressibleValue) {
+			t.Error("Expected the stored value to decompress to the value")
+		}
+	}
+	if stored := lookupStored(nodes[1], result.Key.KademliaID(IDLength)); stored.Compression == CompressionNone || len(stored.Data) >= len(compressibleValue) {
+		t.Errorf("Expected the value to be stored compressed, got %q with %d bytes", stored.Compression, len(stored.Data))
+	}
+
+	data, _, err := nodes[2].GetValue(result.Key)
+	if err != nil || !bytes.Equal(data, compressibleValue) {
+		t.Errorf("Expected the value back, got %d bytes (%v)", len(data), err)
+	}
+}
+
+func TestHandleStore_RejectsInvalidCompression(t *testing.T) {
+	node := newTestNode(t)
+	peer := newTestNode(t)
+	msg := Message{
+		Type:        "STORE",
+		SenderID:    peer.RoutingTable.Me.ID,
+		SenderIP:    peer.RoutingTable.Me.Address,
+		DataID:      NewRandomKademliaID(),
+		Data:        []byte("not zstd"),
+		Compression: CompressionZstd,
+	}
+
+	response, err := peer.Network.SendMessage(&peer.RoutingTable.Me, &node.RoutingTable.Me, msg)
+	if err != nil {
+		t.Fatalf("Expected a reply, got %v", err)
+	}
+	var reply Message
+	json.Unmarshal(response, &reply)
+	if reply.Type != "STORE_REJECTED" {
+		t.Errorf("Expected STORE_REJECTED, got %s", reply.Type)
+	}
+	if values := node.StoredValues(); len(values) != 0 {
+		t.Errorf("Expected nothing stored, got %d values", len(values))
+	}
+}
+
+// lookupStored returns the value node stores under dataID as it is stored
+func lookupStored(node *Kademlia, dataID *KademliaID) Response {
+	reply := make(chan Response, 1)
+	node.ActionChannel <- Action{Action: "LookupData", Hash: dataID.String(), Reply: reply}
+	return <-reply
+}
+
+func TestPutValue_LimitsCompressedSize(t *testing.T) {
+	nodes := newTestNetwork(t, 3)
+	value := bytes.Repeat(compressibleValue, 4)
+
+	result, err := nodes[0].PutValue(value, SHA1)
+	if err != nil {
+		t.Fatalf("Expected a value that compresses below MaxValueSize to be stored, got %v", err)
+	}
+	if stored := lookupStored(nodes[1], result.Key.KademliaID(IDLength)); len(stored.Data) > MaxValueSize {
+		t.Errorf("Expected at most MaxValueSize bytes to be stored, got %d", len(stored.Data))
+	}
+	data, _, err := nodes[2].GetValue(result.Key)
+	if err != nil || !bytes.Equal(data, value) {
+		t.Errorf("Expected the value back, got %d bytes (%v)", len(data), err)
+	}
+}
+
+func TestHandleStore_RejectsValuesAboveMaxValueSize(t *testing.T) {
+	node := newTestNode(t)
+	peer := newTestNode(t)
+	data := make([]byte, MaxValueSize+1)
+	rand.Read(data)
+	msg := Message{
+		Type:     "STORE",
+		SenderID: peer.RoutingTable.Me.ID,
+		SenderIP: peer.RoutingTable.Me.Address,
+		DataID:   NewRandomKademliaID(),
+		Data:     data,
+	}
+
+	response, err := peer.Network.SendMessage(&peer.RoutingTable.Me, &node.RoutingTable.Me, msg)
+	if err != nil {
+		t.Fatalf("Expected a reply, got %v", err)
+	}
+	var reply Message
+	json.Unmarshal(response, &reply)
+	if reply.Type != "STORE_REJECTED" {
+		t.Errorf("Expected STORE_REJECTED, got %s", reply.Type)
+	}
+}
+
+func TestRecompressFor(t *testing.T) {
+	network := &Network{}
+	zstdValue, _ := compress(compressibleValue, CompressionZstd)
+
+	if data, compression := network.recompressFor(zstdValue, CompressionZstd, supportedCompressions); compression != CompressionZstd || !bytes.Equal(data, zstdValue) {
+		t.Errorf("Expected the stored form for a peer accepting it, got %q", compression)
+	}
+	if _, compression := network.recompressFor(zstdValue, CompressionZstd, []Compression{CompressionGzip}); compression != CompressionGzip {
+		t.Errorf("Expected gzip, the only encoding the peer accepts, got %q", compression)
+	}
+	if data, compression := network.recompressFor(zstdValue, CompressionZstd, nil); compression != CompressionNone || !bytes.Equal(data, compressibleValue) {
+		t.Errorf("Expected the value uncompressed for a peer accepting none, got %q", compression)
+	}
+	large, _ := compress(bytes.Repeat(compressibleValue, 4), CompressionZstd)
+	if _, compression := network.recompressFor(large, CompressionZstd, nil); compression != CompressionZstd {
+		t.Errorf("Expected the stored form when the value does not fit uncompressed, got %q", compression)
+	}
+}
+
+func TestRememberCompressions_ForgetsOldestPeer(t *testing.T) {
+	network := &Network{}
+	for i := 0; i < maxPeerCompressions; i++ {
+		network.rememberCompressions(fmt.Sprintf("10.0.%d.%d:4000", i/256, i%256), supportedCompressions)
+	}
+	network.rememberCompressions("10.1.0.1:4000", supportedCompressions)
+
+	if len(network.peerCompressions.peers) != maxPeerCompressions {
+		t.Errorf("Expected %d peers to be remembered, got %d", maxPeerCompressions, len(network.peerCompressions.peers))
+	}
+	if network.compressionsOf("10.0.0.0:4000") != nil {
+		t.Error("Expected the oldest peer to be forgotten")
+	}
+	if network.compressionsOf("10.1.0.1:4000") == nil {
+		t.Error("Expected the newest peer to be remembered")
+	}
+
+	network.peerCompressions.peers["10.1.0.1:4000"] = advertisedCompressions{accepted: supportedCompressions, seen: time.Now().Add(-2 * peerCompressionsTTL)}
+	if network.compressionsOf("10.1.0.1:4000") != nil {
+		t.Error("Expected expired compressions to be ignored")
+	}
+}
+
+func TestObservedAddress(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 50123}
+	if got := observedAddress("10.0.0.1:4000", addr); got != "10.0.0.1:4000" {
+		t.Errorf("Expected the claimed port on the observed IP, got %s", got)
+	}
+	if got := observedAddress("10.9.9.9:4000", addr); got != "10.0.0.1:4000" {
+		t.Errorf("Expected the observed IP rather than the claimed one, got %s", got)
+	}
+	if got := observedAddress("garbage", addr); got != addr.String() {
+		t.Errorf("Expected the observed address without a claimed port, got %s", got)
+	}
+}
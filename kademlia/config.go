@@ -0,0 +1,127 @@
+package kademlia
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Config holds the optional settings of a node
+type Config struct {
+	// Logger receives the logs of the node. Nil uses slog.Default(), use
+	// DiscardLogger to silence the node.
+	Logger *slog.Logger
+	// TracerProvider creates the spans of the node. Nil uses the global
+	// provider from otel.GetTracerProvider().
+	TracerProvider trace.TracerProvider
+	// ValueTTL is how long a stored value is kept unless it is stored
+	// again. Zero keeps values until they are deleted.
+	ValueTTL time.Duration
+	// DisableCompression stops the node from advertising zstd and gzip, so
+	// that peers send it values uncompressed
+	DisableCompression bool
+	// Identity is the Ed25519 key every message of the node is signed
+	// with. The ID of the node must be NodeID of its public key. It also
+	// authenticates the encrypted sessions the node opens with its peers.
+	// Nil sends messages unsigned and unencrypted.
+	Identity ed25519.PrivateKey
+	// RequireSignatures rejects messages that are not signed, signed
+	// messages are always verified
+	RequireSignatures bool
+	// RequireEncryption drops plain messages, so that only peers with an
+	// identity can reach the node. It needs an Identity.
+	RequireEncryption bool
+	// Puzzle holds the difficulties of the crypto puzzles every node ID
+	// must solve. Messages and contacts whose IDs fail them are rejected,
+	// and they need an Identity that solves the static puzzle.
+	Puzzle Puzzle
+	// DisjointPaths is how many disjoint lookups PutValue, GetValue and
+	// DeleteValue run, see DisjointLookup. Zero or one runs a single
+	// NodeLookup.
+	DisjointPaths int
+	// IPLimits bounds how many contacts of the routing table may share an
+	// IP address or subnet, zero limits are not enforced
+	IPLimits IPLimits
+	// Admission rate limits the messages of every peer and caps the ones
+	// handled at once, zero limits are not enforced
+	Admission AdmissionLimits
+}
+
+// ErrNoIdentity is returned when encryption is required of a node without
+// an identity
+var ErrNoIdentity = errors.New("encryption requires an identity")
+
+// NewKademliaWithConfig returns a new instance of Kademlia set up with config.
+// Every log line of the node carries its ID in the "node" field. It fails
+// rather than run the node with weaker security than config asks for.
+func NewKademliaWithConfig(rTable *RoutingTable, conn net.PacketConn, config Config) (*Kademlia, error) {
+	if config.RequireEncryption && config.Identity == nil {
+		return nil, ErrNoIdentity
+	}
+	kademlia := NewKademlia(rTable, conn)
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger = logger.With(slog.String("node", rTable.Me.ID.String()))
+	kademlia.logger = logger
+	kademlia.Network.logger = logger
+	kademlia.Network.tracerProvider = config.TracerProvider
+	kademlia.valueTTL = config.ValueTTL
+	kademlia.disjointPaths = config.DisjointPaths
+	rTable.SetIPLimits(config.IPLimits)
+	kademlia.Network.admission.setLimits(config.Admission)
+	kademlia.Network.compressionDisabled = config.DisableCompression
+	kademlia.Network.identity = config.Identity
+	kademlia.Network.requireSignatures = config.RequireSignatures
+	kademlia.Network.puzzle = config.Puzzle
+	if config.Identity != nil {
+		publicKey := config.Identity.Public().(ed25519.PublicKey)
+		if !NodeID(publicKey, rTable.IDLength()).Equals(rTable.Me.ID) {
+			return nil, ErrIDMismatch
+		}
+		if !config.Puzzle.SolvesStatic(publicKey) {
+			return nil, fmt.Errorf("%w: the identity fails the static puzzle", ErrPuzzle)
+		}
+		nonce, err := config.Puzzle.Solve(rTable.Me.ID)
+		if err != nil {
+			return nil, fmt.Errorf("solving the dynamic puzzle: %w", err)
+		}
+		kademlia.Network.puzzleNonce = nonce
+		rTable.Me.Proof = &Proof{PublicKey: publicKey, Nonce: nonce}
+		transport, err := newTransport(kademlia.Network, config.Identity, config.RequireEncryption)
+		if err != nil {
+			return nil, fmt.Errorf("setting up encryption: %w", err)
+		}
+		kademlia.Network.transport = transport
+		kademlia.Network.connection = &secureConn{PacketConn: conn, transport: transport}
+	}
+	return kademlia, nil
+}
+
+// DiscardLogger returns a logger that drops everything written to it
+func DiscardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+// log returns the logger of the node
+func (kademlia *Kademlia) log() *slog.Logger {
+	if kademlia.logger == nil {
+		return slog.Default()
+	}
+	return kademlia.logger
+}
+
+// log returns the logger of the node the network belongs to
+func (network *Network) log() *slog.Logger {
+	if network.logger == nil {
+		return slog.Default()
+	}
+	return network.logger
+}
@@ -0,0 +1,69 @@
+package kademlia
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"testing"
+)
+
+func TestNewKademliaWithConfig_LogsJSONWithNodeAndPeer(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer conn.Close()
+	var output bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&output, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	me := NewContact(NewRandomKademliaID(), conn.LocalAddr().String())
+	node, err := NewKademliaWithConfig(NewRoutingTable(me), conn, Config{Logger: logger})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	node.UpdateRT(NewRandomKademliaIDWithLength(8), "127.0.0.1:1")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(output.Bytes(), &line); err != nil {
+		t.Fatalf("Expected a JSON log line, got '%s'", output.String())
+	}
+	if line["level"] != "WARN" || line["node"] != me.ID.String() || line["peer"] != "127.0.0.1:1" {
+		t.Errorf("Expected a WARN line with node and peer fields, got %v", line)
+	}
+}
+
+func TestDiscardLogger_Silences(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer conn.Close()
+	me := NewContact(NewRandomKademliaID(), conn.LocalAddr().String())
+	node, err := NewKademliaWithConfig(NewRoutingTable(me), conn, Config{Logger: DiscardLogger()})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if node.log().Enabled(context.Background(), slog.LevelError) {
+		t.Error("Expected the discard logger to drop errors")
+	}
+}
+
+func TestNewKademliaWithConfig_DisableCompression(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer conn.Close()
+	me := NewContact(NewRandomKademliaID(), conn.LocalAddr().String())
+	node, err := NewKademliaWithConfig(NewRoutingTable(me), conn, Config{Logger: DiscardLogger(), DisableCompression: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if accepted := node.Network.acceptedCompressions(); len(accepted) != 0 {
+		t.Errorf("Expected no compressions to be advertised, got %v", accepted)
+	}
+}
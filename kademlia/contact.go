@@ -8,14 +8,17 @@ import (
 // Contact definition
 // stores the KademliaID, the ip address and the distance
 type Contact struct {
-	ID       *KademliaID `json:"id"`
-	Address  string      `json:"address"`
+	ID      *KademliaID `json:"id"`
+	Address string      `json:"address"`
+	// Proof shows that ID solves the crypto puzzles, it is nil for
+	// contacts of nodes without an identity
+	Proof    *Proof `json:"proof,omitempty"`
 	distance *KademliaID
 }
 
 // NewContact returns a new instance of a Contact
 func NewContact(id *KademliaID, address string) Contact {
-	return Contact{id, address, nil}
+	return Contact{ID: id, Address: address}
 }
 
 // CalcDistance calculates the distance to the target and
@@ -0,0 +1,166 @@
+package kademlia
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// tombstoneTTL is how long a deleted key keeps refusing new STOREs, it
+// must outlive the interval that publishers republish values at
+const tombstoneTTL = 48 * time.Hour
+
+// NewDeleteToken returns a random token for a PUT and the hash of it that
+// is sent along with the STORE. Only whoever holds the token can delete
+// the value later.
+func NewDeleteToken() ([]byte, []byte) {
+	token := make([]byte, 16)
+	rand.Read(token)
+	return token, HashDeleteToken(token)
+}
+
+// HashDeleteToken returns the hash of token that the storing nodes keep
+func HashDeleteToken(token []byte) []byte {
+	sum := sha256.Sum256(token)
+	return sum[:]
+}
+
+// tombstone stops STOREs of a deleted value until it expires
+type tombstone struct {
+	expires time.Time
+	// tokenHash is set when the DELETE reached a node without the value,
+	// only STOREs carrying that token hash are refused then
+	tokenHash []byte
+}
+
+// refuses returns true if the tombstone refuses a STORE with tokenHash
+func (tombstone tombstone) refuses(tokenHash []byte) bool {
+	return tombstone.tokenHash == nil || subtle.ConstantTimeCompare(tombstone.tokenHash, tokenHash) == 1
+}
+
+// StoreWithToken stores data under hash and remembers tokenHash as a proof
+// needed to delete it. Every token stored for a hash is kept, so each
+// publisher of a value can delete it. Values that have been deleted are
+// refused until the tombstone expires.
+func (kademlia *Kademlia) StoreWithToken(hash string, data []byte, tokenHash []byte) error {
+	return kademlia.storeWithToken(hash, data, CompressionNone, tokenHash)
+}
+
+// storeWithToken is StoreWithToken for data encoded with compression
+func (kademlia *Kademlia) storeWithToken(hash string, data []byte, compression Compression, tokenHash []byte) error {
+	if tombstone, found := kademlia.tombstone(hash); found && tombstone.refuses(tokenHash) {
+		return fmt.Errorf("value has been deleted")
+	}
+	kademlia.storeCompressed(hash, data, compression)
+
+	if tokenHash != nil {
+		if kademlia.deleteTokens == nil {
+			kademlia.deleteTokens = make(map[string][][]byte)
+		}
+		for _, stored := range kademlia.deleteTokens[hash] {
+			if bytes.Equal(stored, tokenHash) {
+				return nil
+			}
+		}
+		kademlia.deleteTokens[hash] = append(kademlia.deleteTokens[hash], tokenHash)
+	}
+	return nil
+}
+
+// Delete removes the value stored under hash if token matches one of the
+// token hashes it was stored with, and leaves a tombstone so the value
+// cannot be stored again by republishing nodes. Without the value the
+// token cannot be checked, the tombstone then only refuses STOREs that
+// carry the hash of token.
+func (kademlia *Kademlia) Delete(hash string, token []byte) error {
+	value, found := (*kademlia.Data)[hash]
+	if !found {
+		kademlia.addTombstone(hash, HashDeleteToken(token))
+		return nil
+	}
+	tokenHashes := kademlia.deleteTokens[hash]
+	if len(tokenHashes) == 0 {
+		return fmt.Errorf("value was stored without a delete token")
+	}
+	matches := false
+	for _, tokenHash := range tokenHashes {
+		if subtle.ConstantTimeCompare(tokenHash, HashDeleteToken(token)) == 1 {
+			matches = true
+		}
+	}
+	if !matches {
+		return fmt.Errorf("invalid delete token")
+	}
+
+	kademlia.storedKeys.Add(-1)
+	kademlia.storedBytes.Add(-int64(len(value)))
+	delete(*kademlia.Data, hash)
+	delete(kademlia.deleteTokens, hash)
+	delete(kademlia.expires, hash)
+	delete(kademlia.compressions, hash)
+	kademlia.addTombstone(hash, nil)
+	return nil
+}
+
+// addTombstone refuses STOREs of hash with tokenHash, or all of them if
+// tokenHash is nil, for tombstoneTTL. A tombstone refusing all STOREs is
+// not narrowed.
+func (kademlia *Kademlia) addTombstone(hash string, tokenHash []byte) {
+	if kademlia.tombstones == nil {
+		kademlia.tombstones = make(map[string]tombstone)
+	}
+	if existing, found := kademlia.tombstone(hash); found && existing.tokenHash == nil {
+		tokenHash = nil
+	}
+	kademlia.tombstones[hash] = tombstone{expires: time.Now().Add(tombstoneTTL), tokenHash: tokenHash}
+}
+
+// tombstone returns the unexpired tombstone of hash
+func (kademlia *Kademlia) tombstone(hash string) (tombstone, bool) {
+	tombstone, found := kademlia.tombstones[hash]
+	if !found {
+		return tombstone, false
+	}
+	if time.Now().After(tombstone.expires) {
+		delete(kademlia.tombstones, hash)
+		return tombstone, false
+	}
+	return tombstone, true
+}
+
+// IsDeleted returns true if hash has an unexpired tombstone that refuses
+// every STORE
+func (kademlia *Kademlia) IsDeleted(hash string) bool {
+	tombstone, found := kademlia.tombstone(hash)
+	return found && tombstone.tokenHash == nil
+}
+
+// DeleteValue sends a DELETE carrying token to the k closest contacts to
+// target and returns how many of them accepted it
+func (kademlia *Kademlia) DeleteValue(target *KademliaID, token []byte) (int, int) {
+	contacts, _, _ := kademlia.lookup(target, nil)
+
+	resultChan := make(chan bool, len(contacts))
+	var waitGroup sync.WaitGroup
+	for _, contact := range contacts {
+		waitGroup.Add(1)
+		go func(contact Contact) {
+			defer waitGroup.Done()
+			resultChan <- kademlia.Network.SendDeleteMessage(&kademlia.RoutingTable.Me, &contact, target, token)
+		}(contact)
+	}
+	waitGroup.Wait()
+	close(resultChan)
+
+	successCount := 0
+	for success := range resultChan {
+		if success {
+			successCount++
+		}
+	}
+	return successCount, len(contacts)
+}
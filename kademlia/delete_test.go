@@ -0,0 +1,120 @@
+package kademlia
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelete_RequiresMatchingToken(t *testing.T) {
+	kademlia := &Kademlia{Data: &map[string][]byte{}}
+	token, tokenHash := NewDeleteToken()
+	otherToken, _ := NewDeleteToken()
+
+	if err := kademlia.StoreWithToken("hash1", []byte("data1"), tokenHash); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := kademlia.Delete("hash1", otherToken); err == nil {
+		t.Error("Expected delete with the wrong token to fail")
+	}
+	if err := kademlia.Delete("hash1", token); err != nil {
+		t.Errorf("Expected delete with the right token to succeed, got %v", err)
+	}
+	if _, found := (*kademlia.Data)["hash1"]; found {
+		t.Error("Expected value to be removed")
+	}
+}
+
+func TestDelete_KeepsEveryToken(t *testing.T) {
+	for _, deleter := range []int{0, 1} {
+		kademlia := &Kademlia{Data: &map[string][]byte{}}
+		first, firstHash := NewDeleteToken()
+		second, secondHash := NewDeleteToken()
+
+		kademlia.StoreWithToken("hash1", []byte("data1"), firstHash)
+		kademlia.StoreWithToken("hash1", []byte("data1"), secondHash)
+
+		if err := kademlia.Delete("hash1", [][]byte{first, second}[deleter]); err != nil {
+			t.Errorf("Expected publisher %d to be able to delete, got %v", deleter, err)
+		}
+	}
+}
+
+func TestDelete_ValueWithoutToken(t *testing.T) {
+	kademlia := &Kademlia{Data: &map[string][]byte{}}
+	token, _ := NewDeleteToken()
+	kademlia.Store("hash1", []byte("data1"))
+
+	if err := kademlia.Delete("hash1", token); err == nil {
+		t.Error("Expected value stored without a token to not be deletable")
+	}
+}
+
+func TestDelete_MissingValueRefusesStoresWithToken(t *testing.T) {
+	kademlia := &Kademlia{Data: &map[string][]byte{}}
+	token, tokenHash := NewDeleteToken()
+	_, otherHash := NewDeleteToken()
+
+	if err := kademlia.Delete("hash1", token); err != nil {
+		t.Fatalf("Expected deleting a missing value to be accepted, got %v", err)
+	}
+	if err := kademlia.StoreWithToken("hash1", []byte("data1"), tokenHash); err == nil {
+		t.Error("Expected store with the token of the delete to be refused")
+	}
+	if err := kademlia.StoreWithToken("hash1", []byte("data1"), otherHash); err != nil {
+		t.Errorf("Expected store with another token to succeed, got %v", err)
+	}
+}
+
+func TestDelete_TombstoneBlocksStore(t *testing.T) {
+	kademlia := &Kademlia{Data: &map[string][]byte{}}
+	token, tokenHash := NewDeleteToken()
+	kademlia.StoreWithToken("hash1", []byte("data1"), tokenHash)
+	kademlia.Delete("hash1", token)
+
+	if err := kademlia.StoreWithToken("hash1", []byte("data1"), nil); err == nil {
+		t.Error("Expected store of a deleted value to be refused")
+	}
+
+	kademlia.tombstones["hash1"] = tombstone{expires: time.Now().Add(-time.Second)}
+	if kademlia.IsDeleted("hash1") {
+		t.Error("Expected expired tombstone to be dropped")
+	}
+	if err := kademlia.StoreWithToken("hash1", []byte("data1"), nil); err != nil {
+		t.Errorf("Expected store after the tombstone expired to succeed, got %v", err)
+	}
+}
+
+func TestSendDeleteMessage_RemovesValueAndRefusesRepublish(t *testing.T) {
+	nodes := newTestNetwork(t, 2)
+	publisher, storer := nodes[0], nodes[1]
+	dataID := NewRandomKademliaID()
+	token, tokenHash := NewDeleteToken()
+
+	if !publisher.Network.SendStoreMessage(&publisher.RoutingTable.Me, &storer.RoutingTable.Me, dataID, []byte("data1"), tokenHash) {
+		t.Fatal("Expected STORE to be acknowledged")
+	}
+	wrongToken, _ := NewDeleteToken()
+	if publisher.Network.SendDeleteMessage(&publisher.RoutingTable.Me, &storer.RoutingTable.Me, dataID, wrongToken) {
+		t.Error("Expected DELETE with the wrong token to be rejected")
+	}
+	if !publisher.Network.SendDeleteMessage(&publisher.RoutingTable.Me, &storer.RoutingTable.Me, dataID, token) {
+		t.Fatal("Expected DELETE to be acknowledged")
+	}
+	if publisher.Network.SendStoreMessage(&publisher.RoutingTable.Me, &storer.RoutingTable.Me, dataID, []byte("data1"), nil) {
+		t.Error("Expected STORE of a deleted value to be rejected")
+	}
+}
+
+func TestSendDeleteMessage_NodeWithoutValueRefusesRepublish(t *testing.T) {
+	nodes := newTestNetwork(t, 2)
+	publisher, storer := nodes[0], nodes[1]
+	dataID := NewRandomKademliaID()
+	token, tokenHash := NewDeleteToken()
+
+	if !publisher.Network.SendDeleteMessage(&publisher.RoutingTable.Me, &storer.RoutingTable.Me, dataID, token) {
+		t.Fatal("Expected DELETE of a missing value to be acknowledged")
+	}
+	if publisher.Network.SendStoreMessage(&publisher.RoutingTable.Me, &storer.RoutingTable.Me, dataID, []byte("data1"), tokenHash) {
+		t.Error("Expected republished STORE of the deleted value to be rejected")
+	}
+}
@@ -0,0 +1,113 @@
+package kademlia
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// DisjointLookup looks up target the S/Kademlia way: the closest contacts
+// in the routing table are split between paths lookups that run in
+// parallel, and a node queried by one path is never queried by another.
+// An adversary that returns made up contacts can then only mislead the
+// paths that reach it. The k closest contacts found by all paths are
+// returned. If key is not nil, the paths look for the value stored under
+// it, and the first value that matches key is returned together with the
+// contact it was found on, so a GET succeeds as long as one path only
+// reaches honest nodes.
+func (kademlia *Kademlia) DisjointLookup(target *KademliaID, key Multihash, paths int) ([]Contact, Contact, []byte) {
+	start := time.Now()
+	defer func() { lookupDuration.Observe(time.Since(start).Seconds()) }()
+
+	var mutex sync.Mutex
+	var foundOn Contact
+	var found []byte
+	contacts := kademlia.lookupDisjoint(target, paths, func(path int, contact Contact) ([]Contact, error) {
+		mutex.Lock()
+		done := found != nil
+		mutex.Unlock()
+		if done {
+			return nil, errStopLookup
+		}
+		if key == nil {
+			return kademlia.Network.SendFindContactMessage(&kademlia.RoutingTable.Me, &contact, &Contact{ID: target})
+		}
+
+		closestContacts, data, err := kademlia.Network.SendFindDataMessage(&kademlia.RoutingTable.Me, &contact, target.String())
+		if err != nil || data == nil {
+			return closestContacts, err
+		}
+		if !key.Verify(data) {
+			kademlia.log().Warn("Discarding data that does not match key", "peer", contact.Address, "key", key.String(), "path", path)
+			return closestContacts, nil
+		}
+		mutex.Lock()
+		if found == nil {
+			found, foundOn = data, contact
+		}
+		mutex.Unlock()
+		return nil, errStopLookup
+	})
+	return contacts, foundOn, found
+}
+
+// lookupDisjoint runs paths lookups for target that share no contacts and
+// returns the k closest contacts found by any of them. query is called
+// with the index of the path it belongs to.
+func (kademlia *Kademlia) lookupDisjoint(target *KademliaID, paths int, query func(path int, contact Contact) ([]Contact, error)) []Contact {
+	paths = max(paths, 1)
+	initial := make([][]Contact, paths)
+	for i, contact := range kademlia.RoutingTable.FindClosestContacts(target, k*paths) {
+		initial[i%paths] = append(initial[i%paths], contact)
+	}
+
+	// owners records the path every contact seen so far belongs to
+	var mutex sync.Mutex
+	owners := make(map[string]int)
+	results := make([][]Contact, paths)
+	var waitGroup sync.WaitGroup
+	for path := range paths {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			claim := func(contact Contact) bool {
+				mutex.Lock()
+				defer mutex.Unlock()
+				owner, known := owners[contact.ID.String()]
+				if !known {
+					owners[contact.ID.String()] = path
+					return true
+				}
+				return owner == path
+			}
+			results[path] = kademlia.lookupPath(target, initial[path], claim, func(contact Contact) ([]Contact, error) {
+				return query(path, contact)
+			})
+		}()
+	}
+	waitGroup.Wait()
+
+	var merged []Contact
+	for _, contacts := range results {
+		for _, contact := range contacts {
+			contact.CalcDistance(target)
+			merged = append(merged, contact)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Less(&merged[j]) })
+	return merged[:min(len(merged), k)]
+}
+
+// lookup finds the k closest contacts to target and, if key is not nil,
+// the value stored under it. It runs DisjointLookup if the node was set up
+// with several disjoint paths and NodeLookup otherwise.
+func (kademlia *Kademlia) lookup(target *KademliaID, key Multihash) ([]Contact, Contact, []byte) {
+	if kademlia.disjointPaths > 1 {
+		return kademlia.DisjointLookup(target, key, kademlia.disjointPaths)
+	}
+	hash := ""
+	if key != nil {
+		hash = key.String()
+	}
+	return kademlia.NodeLookup(&Contact{ID: target}, hash)
+}
@@ -0,0 +1,114 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+)
+
+// newAdversary answers every message with contacts at its own address
+// whose IDs are close to target, and with data that does not match any key
+func newAdversary(t *testing.T, target *KademliaID) Contact {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	var fakes []Contact
+	for i := 0; i < k; i++ {
+		id := append(KademliaID{}, *target...)
+		id[len(id)-1] ^= byte(i + 1)
+		fakes = append(fakes, NewContact(&id, conn.LocalAddr().String()))
+	}
+	reply, _ := json.Marshal(Response{ClosestContacts: fakes, Data: []byte("forged")})
+	go func() {
+		buffer := make([]byte, 8192)
+		for {
+			_, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(reply, addr)
+		}
+	}()
+	return NewContact(NewRandomKademliaID(), conn.LocalAddr().String())
+}
+
+func TestLookupDisjoint_SharesNoContacts(t *testing.T) {
+	nodes := newTestNetwork(t, 10)
+	target := NewRandomKademliaID()
+
+	var mutex sync.Mutex
+	queriedBy := make(map[string]int)
+	contacts := nodes[0].lookupDisjoint(target, 3, func(path int, contact Contact) ([]Contact, error) {
+		mutex.Lock()
+		if owner, queried := queriedBy[contact.ID.String()]; queried && owner != path {
+			t.Errorf("Expected %s to be queried by path %d only, also queried by %d", contact.Address, owner, path)
+		}
+		queriedBy[contact.ID.String()] = path
+		mutex.Unlock()
+		return nodes[0].Network.SendFindContactMessage(&nodes[0].RoutingTable.Me, &contact, &Contact{ID: target})
+	})
+
+	if len(contacts) == 0 || len(contacts) > k {
+		t.Fatalf("Expected between 1 and %d contacts, got %d", k, len(contacts))
+	}
+	for i := 1; i < len(contacts); i++ {
+		if contacts[i].Less(&contacts[i-1]) {
+			t.Errorf("Expected contacts sorted by distance to the target, got %v", contacts)
+		}
+	}
+	paths := make(map[int]bool)
+	for _, path := range queriedBy {
+		paths[path] = true
+	}
+	if len(paths) != 3 {
+		t.Errorf("Expected all 3 paths to query contacts, got %v", paths)
+	}
+}
+
+func TestDisjointLookup_FindsValueDespiteAdversary(t *testing.T) {
+	searcher, honest, holder := newTestNode(t), newTestNode(t), newTestNode(t)
+	data := []byte("data1")
+	key, _ := Sum(data, SHA2_256)
+	target := key.KademliaID(IDLength)
+	if !honest.Network.SendStoreMessage(&honest.RoutingTable.Me, &holder.RoutingTable.Me, target, data, nil) {
+		t.Fatal("Expected the value to be stored")
+	}
+
+	// one path starts at the adversary and the other at the honest node,
+	// which knows the holder of the value
+	searcher.RoutingTable.AddContact(newAdversary(t, target))
+	searcher.RoutingTable.AddContact(honest.RoutingTable.Me)
+	honest.RoutingTable.AddContact(holder.RoutingTable.Me)
+
+	_, foundOn, found := searcher.DisjointLookup(target, key, 2)
+	if string(found) != "data1" {
+		t.Fatalf("Expected data1, got %q", found)
+	}
+	if !foundOn.ID.Equals(holder.RoutingTable.Me.ID) {
+		t.Errorf("Expected the value to be found on the holder, got %s", foundOn.Address)
+	}
+}
+
+func TestGetValue_DisjointPaths(t *testing.T) {
+	nodes := newTestNetwork(t, 6)
+	for _, node := range nodes {
+		node.disjointPaths = 2
+	}
+
+	result, err := nodes[0].PutValue([]byte("data1"), SHA1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, _, err := nodes[5].GetValue(result.Key)
+	if err != nil || string(data) != "data1" {
+		t.Errorf("Expected data1, got %q (%v)", data, err)
+	}
+	missing, _ := Sum([]byte("data2"), SHA1)
+	if _, _, err := nodes[5].GetValue(missing); err != ErrNotFound {
+		t.Error("Expected an unknown key not to be found")
+	}
+}
@@ -0,0 +1,146 @@
+package kademlia
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// IPLimits bounds how many contacts of the routing table may share an IP
+// address or a subnet, so that a single host or network cannot fill a
+// bucket or the whole table with Sybil nodes. Subnets are /24 for IPv4 and
+// /64 for IPv6. A zero limit is not enforced.
+type IPLimits struct {
+	PerIPBucket     int
+	PerSubnetBucket int
+	PerIPTable      int
+	PerSubnetTable  int
+}
+
+// Reasons a contact is rejected, as recorded in Rejection.Reason and the
+// kademlia_routing_table_rejections_total metric
+const (
+	RejectedIPBucket     = "ip_bucket"
+	RejectedSubnetBucket = "subnet_bucket"
+	RejectedIPTable      = "ip_table"
+	RejectedSubnetTable  = "subnet_table"
+)
+
+// maxRejections is how many of the latest rejections the routing table keeps
+const maxRejections = 100
+
+// ErrIPLimit is returned for contacts that would exceed an IP limit
+var ErrIPLimit = errors.New("IP diversity limit reached")
+
+// ErrSenderAddress is returned for messages that claim a sender address
+// on another IP than the one they were received from
+var ErrSenderAddress = errors.New("sender address does not match the source IP")
+
+// Rejection is a contact the routing table refused because of its IP limits
+type Rejection struct {
+	Contact Contact   `json:"contact"`
+	Reason  string    `json:"reason"`
+	Time    time.Time `json:"time"`
+}
+
+// SetIPLimits sets the limits new contacts are checked against
+func (routingTable *RoutingTable) SetIPLimits(limits IPLimits) {
+	routingTable.mutex.Lock()
+	defer routingTable.mutex.Unlock()
+	routingTable.limits = limits
+}
+
+// Rejections returns the latest contacts refused because of the IP
+// limits, oldest first
+func (routingTable *RoutingTable) Rejections() []Rejection {
+	routingTable.mutex.RLock()
+	defer routingTable.mutex.RUnlock()
+	return append([]Rejection{}, routingTable.rejections...)
+}
+
+// admit checks a contact that is not in the routing table yet against the
+// IP limits. A contact that exceeds one is recorded as a rejection.
+func (routingTable *RoutingTable) admit(contact Contact) error {
+	routingTable.mutex.Lock()
+	defer routingTable.mutex.Unlock()
+	reason := routingTable.exceededLimit(contact)
+	if reason == "" {
+		return nil
+	}
+
+	routingTableRejections.WithLabelValues(reason).Inc()
+	routingTable.rejections = append(routingTable.rejections, Rejection{Contact: contact, Reason: reason, Time: time.Now()})
+	if len(routingTable.rejections) > maxRejections {
+		routingTable.rejections = routingTable.rejections[len(routingTable.rejections)-maxRejections:]
+	}
+	return fmt.Errorf("%w: %s", ErrIPLimit, reason)
+}
+
+// exceededLimit returns the reason contact exceeds a limit, or "" if it
+// fits. The mutex must be held.
+func (routingTable *RoutingTable) exceededLimit(contact Contact) string {
+	limits := routingTable.limits
+	if limits == (IPLimits{}) {
+		return ""
+	}
+	ip, subnet := ipAndSubnet(contact.Address)
+	bucketIndex := routingTable.getBucketIndex(contact.ID)
+
+	var sameIPBucket, sameSubnetBucket, sameIPTable, sameSubnetTable int
+	for index, bucket := range routingTable.buckets {
+		for element := bucket.list.Front(); element != nil; element = element.Next() {
+			otherIP, otherSubnet := ipAndSubnet(element.Value.(Contact).Address)
+			if otherIP == ip {
+				sameIPTable++
+				if index == bucketIndex {
+					sameIPBucket++
+				}
+			}
+			if otherSubnet == subnet {
+				sameSubnetTable++
+				if index == bucketIndex {
+					sameSubnetBucket++
+				}
+			}
+		}
+	}
+
+	switch {
+	case limits.PerIPBucket > 0 && sameIPBucket >= limits.PerIPBucket:
+		return RejectedIPBucket
+	case limits.PerSubnetBucket > 0 && sameSubnetBucket >= limits.PerSubnetBucket:
+		return RejectedSubnetBucket
+	case limits.PerIPTable > 0 && sameIPTable >= limits.PerIPTable:
+		return RejectedIPTable
+	case limits.PerSubnetTable > 0 && sameSubnetTable >= limits.PerSubnetTable:
+		return RejectedSubnetTable
+	}
+	return ""
+}
+
+// claimsObservedIP returns true if the sender address a message claims is
+// on the IP it was received from. The IP limits are checked against the
+// sender address, so a host that could claim any IP would escape them.
+func claimsObservedIP(claimed string, addr net.Addr) bool {
+	claimedIP, _ := ipAndSubnet(claimed)
+	observedIP, _ := ipAndSubnet(addr.String())
+	return claimedIP == observedIP
+}
+
+// ipAndSubnet returns the IP of address and its /24 or /64 subnet. Hosts
+// that are not IP addresses are their own subnet.
+func ipAndSubnet(address string) (string, string) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host, host
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return ipv4.String(), ipv4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.String(), ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
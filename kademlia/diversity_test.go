@@ -0,0 +1,157 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// idInBucket returns a random ID that falls into bucket 0 of routingTable
+func idInBucket(routingTable *RoutingTable) *KademliaID {
+	id := NewRandomKademliaID()
+	(*id)[0] = ^(*routingTable.Me.ID)[0]
+	return id
+}
+
+func TestIPAndSubnet(t *testing.T) {
+	for _, test := range []struct {
+		address string
+		ip      string
+		subnet  string
+	}{
+		{"172.20.0.6:8000", "172.20.0.6", "172.20.0.0/24"},
+		{"[2001:db8:1:2:3:4:5:6]:8000", "2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64"},
+		{"[::ffff:10.0.0.1]:8000", "10.0.0.1", "10.0.0.0/24"},
+		{"node1:8000", "node1", "node1"},
+	} {
+		ip, subnet := ipAndSubnet(test.address)
+		if ip != test.ip || subnet != test.subnet {
+			t.Errorf("Expected %s in %s for %s, got %s in %s", test.ip, test.subnet, test.address, ip, subnet)
+		}
+	}
+}
+
+func TestAdmit_Limits(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		limits   IPLimits
+		existing string
+		// sameBucket puts the existing contact into the bucket of the new one
+		sameBucket bool
+		address    string
+		reason     string
+	}{
+		{"IP in bucket", IPLimits{PerIPBucket: 1}, "10.0.0.1:1", true, "10.0.0.1:2", RejectedIPBucket},
+		{"IP in other bucket", IPLimits{PerIPBucket: 1}, "10.0.0.1:1", false, "10.0.0.1:2", ""},
+		{"subnet in bucket", IPLimits{PerSubnetBucket: 1}, "10.0.0.1:1", true, "10.0.0.2:1", RejectedSubnetBucket},
+		{"other subnet in bucket", IPLimits{PerSubnetBucket: 1}, "10.0.1.1:1", true, "10.0.0.2:1", ""},
+		{"IP in table", IPLimits{PerIPTable: 1}, "10.0.0.1:1", false, "10.0.0.1:2", RejectedIPTable},
+		{"subnet in table", IPLimits{PerSubnetTable: 1}, "10.0.0.1:1", false, "10.0.0.2:1", RejectedSubnetTable},
+		{"IPv6 subnet in table", IPLimits{PerSubnetTable: 1}, "[2001:db8::1]:1", false, "[2001:db8::2]:1", RejectedSubnetTable},
+		{"no limits", IPLimits{}, "10.0.0.1:1", true, "10.0.0.1:2", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			routingTable := NewRoutingTable(NewContact(NewRandomKademliaID(), "127.0.0.1:8000"))
+			routingTable.SetIPLimits(test.limits)
+			existing := idInBucket(routingTable)
+			if !test.sameBucket {
+				existing = routingTable.Me.ID.CalcDistance(NewKademliaID("00000000000000000000000000000000000000ff"))
+			}
+			routingTable.AddContact(NewContact(existing, test.existing))
+
+			err := routingTable.admit(NewContact(idInBucket(routingTable), test.address))
+			if test.reason == "" {
+				if err != nil {
+					t.Errorf("Expected the contact to be admitted, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrIPLimit) {
+				t.Fatalf("Expected ErrIPLimit, got %v", err)
+			}
+			if rejections := routingTable.Rejections(); len(rejections) != 1 || rejections[0].Reason != test.reason {
+				t.Errorf("Expected a %s rejection, got %v", test.reason, rejections)
+			}
+		})
+	}
+}
+
+func TestAdmit_KeepsLatestRejections(t *testing.T) {
+	routingTable := NewRoutingTable(NewContact(NewRandomKademliaID(), "127.0.0.1:8000"))
+	routingTable.SetIPLimits(IPLimits{PerIPTable: 1})
+	routingTable.AddContact(NewContact(idInBucket(routingTable), "10.0.0.1:1"))
+
+	for i := 0; i < maxRejections+5; i++ {
+		routingTable.admit(NewContact(idInBucket(routingTable), "10.0.0.1:2"))
+	}
+	if rejections := routingTable.Rejections(); len(rejections) != maxRejections {
+		t.Errorf("Expected the latest %d rejections, got %d", maxRejections, len(rejections))
+	}
+}
+
+func TestUpdateRT_RejectsContactsOverLimit(t *testing.T) {
+	kademlia := &Kademlia{RoutingTable: NewRoutingTable(NewContact(NewRandomKademliaID(), "127.0.0.1:8000"))}
+	kademlia.RoutingTable.SetIPLimits(IPLimits{PerIPTable: 2})
+	events, unsubscribe := kademlia.Events().Subscribe(4, ContactRejected)
+	defer unsubscribe()
+
+	known := NewRandomKademliaID()
+	kademlia.UpdateRT(known, "10.0.0.1:1")
+	kademlia.UpdateRT(NewRandomKademliaID(), "10.0.0.1:2")
+	rejected := NewRandomKademliaID()
+	kademlia.UpdateRT(rejected, "10.0.0.1:3")
+	// contacts already in the table are still refreshed
+	kademlia.UpdateRT(known, "10.0.0.1:1")
+
+	if kademlia.RoutingTable.Contains(rejected) {
+		t.Error("Expected the third contact on the same IP to be rejected")
+	}
+	if event := nextEvent(t, events); event.Type != ContactRejected || !event.Contact.ID.Equals(rejected) {
+		t.Errorf("Expected ContactRejected for %s, got %+v", rejected, event)
+	}
+	if len(events) != 0 {
+		t.Error("Expected only one contact to be rejected")
+	}
+}
+
+func TestListen_RejectsClaimedIPsOfOneHost(t *testing.T) {
+	node := newIdentityTestNode(t, Config{IPLimits: IPLimits{PerIPTable: 1}})
+	events, unsubscribe := node.Events().Subscribe(4, ContactRejected)
+	defer unsubscribe()
+	sybil, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer sybil.Close()
+	receiver, _ := net.ResolveUDPAddr("udp", node.RoutingTable.Me.Address)
+
+	expected := map[string]string{"10.0.0.1:8000": "ERROR", "10.0.0.2:8000": "ERROR", "127.0.0.1:8001": "PONG", "127.0.0.1:8002": "PONG"}
+	for _, claimed := range []string{"10.0.0.1:8000", "10.0.0.2:8000", "127.0.0.1:8001", "127.0.0.1:8002"} {
+		data, _ := json.Marshal(Message{Type: "PING", SenderID: NewRandomKademliaID(), SenderIP: claimed})
+		sybil.WriteTo(data, receiver)
+		sybil.SetReadDeadline(time.Now().Add(time.Second))
+		var buffer [8192]byte
+		n, _, err := sybil.ReadFrom(buffer[:])
+		if err != nil {
+			t.Fatalf("Expected a reply for %s, got %v", claimed, err)
+		}
+		var reply Message
+		json.Unmarshal(buffer[:n], &reply)
+		if reply.Type != expected[claimed] {
+			t.Errorf("Expected %s for %s, got %s", expected[claimed], claimed, reply.Type)
+		}
+	}
+
+	// only the claims on the real IP count, and the second one reaches the limit
+	if event := nextEvent(t, events); !strings.HasPrefix(event.Contact.Address, "127.0.0.1:") {
+		t.Errorf("Expected a contact on 127.0.0.1 to be rejected, got %+v", event)
+	}
+	for _, contact := range node.RoutingTable.FindClosestContacts(node.RoutingTable.Me.ID, k) {
+		if ip, _ := ipAndSubnet(contact.Address); ip != "127.0.0.1" {
+			t.Errorf("Expected no contact on a claimed IP, got %s", contact.Address)
+		}
+	}
+}
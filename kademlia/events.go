@@ -0,0 +1,107 @@
+package kademlia
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies what an Event is about
+type EventType string
+
+const (
+	// ContactAdded is published when a new contact enters the routing table
+	ContactAdded EventType = "ContactAdded"
+	// ContactEvicted is published when an unresponsive contact is replaced
+	ContactEvicted EventType = "ContactEvicted"
+	// ContactRejected is published when a contact exceeds the IP limits
+	ContactRejected EventType = "ContactRejected"
+	// ValueStored is published when a value is stored on this node
+	ValueStored EventType = "ValueStored"
+	// ValueExpired is published when a value is dropped after its TTL
+	ValueExpired EventType = "ValueExpired"
+	// LookupCompleted is published when a node lookup started here ends
+	LookupCompleted EventType = "LookupCompleted"
+)
+
+// Event describes a change on a node. Only the fields that apply to the
+// type of the event are set.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Contact is the contact that was added, evicted or rejected
+	Contact *Contact
+	// Reason is why a contact was rejected
+	Reason string
+	// Hash and Size describe the value that was stored or expired
+	Hash string
+	Size int
+	// Target, Contacts, Hops and DataFound describe a completed lookup
+	Target    *KademliaID
+	Contacts  []Contact
+	Hops      int
+	DataFound bool
+}
+
+// EventBus delivers events to subscribers without ever blocking the node,
+// events are dropped for subscribers whose channel is full
+type EventBus struct {
+	mutex       sync.RWMutex
+	subscribers map[chan Event]map[EventType]bool
+	dropped     atomic.Int64
+}
+
+// Subscribe returns a channel with room for buffer events that receives
+// events of the given types, or of every type if none are given. The
+// returned function unsubscribes and closes the channel.
+func (bus *EventBus) Subscribe(buffer int, types ...EventType) (<-chan Event, func()) {
+	events := make(chan Event, buffer)
+	filter := make(map[EventType]bool)
+	for _, eventType := range types {
+		filter[eventType] = true
+	}
+
+	bus.mutex.Lock()
+	if bus.subscribers == nil {
+		bus.subscribers = make(map[chan Event]map[EventType]bool)
+	}
+	bus.subscribers[events] = filter
+	bus.mutex.Unlock()
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			bus.mutex.Lock()
+			delete(bus.subscribers, events)
+			bus.mutex.Unlock()
+			close(events)
+		})
+	}
+}
+
+// Dropped returns how many events were dropped because a subscriber was full
+func (bus *EventBus) Dropped() int64 {
+	return bus.dropped.Load()
+}
+
+// publish hands event to every subscriber that wants its type
+func (bus *EventBus) publish(event Event) {
+	event.Time = time.Now()
+	bus.mutex.RLock()
+	defer bus.mutex.RUnlock()
+	for events, filter := range bus.subscribers {
+		if len(filter) > 0 && !filter[event.Type] {
+			continue
+		}
+		select {
+		case events <- event:
+		default:
+			bus.dropped.Add(1)
+		}
+	}
+}
+
+// Events returns the event bus of the node
+func (kademlia *Kademlia) Events() *EventBus {
+	return &kademlia.events
+}
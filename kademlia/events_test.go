@@ -0,0 +1,122 @@
+package kademlia
+
+import (
+	"testing"
+	"time"
+)
+
+// nextEvent waits for the next event on events
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("Expected an event")
+		return Event{}
+	}
+}
+
+func TestEventBus_FiltersByType(t *testing.T) {
+	var bus EventBus
+	stored, unsubscribe := bus.Subscribe(1, ValueStored)
+	defer unsubscribe()
+
+	bus.publish(Event{Type: LookupCompleted})
+	bus.publish(Event{Type: ValueStored, Hash: "a"})
+
+	if event := nextEvent(t, stored); event.Type != ValueStored || event.Hash != "a" {
+		t.Errorf("Expected the ValueStored event, got %+v", event)
+	}
+}
+
+func TestEventBus_DropsWhenFull(t *testing.T) {
+	var bus EventBus
+	events, unsubscribe := bus.Subscribe(1)
+
+	bus.publish(Event{Type: ValueStored})
+	bus.publish(Event{Type: ValueStored})
+
+	if bus.Dropped() != 1 {
+		t.Errorf("Expected 1 dropped event, got %d", bus.Dropped())
+	}
+	unsubscribe()
+	unsubscribe()
+	<-events
+	if _, open := <-events; open {
+		t.Error("Expected the channel to be closed after unsubscribing")
+	}
+	bus.publish(Event{Type: ValueStored})
+}
+
+func TestUpdateRT_PublishesContactAdded(t *testing.T) {
+	kademlia := &Kademlia{RoutingTable: NewRoutingTable(NewContact(NewRandomKademliaID(), "127.0.0.1:8000"))}
+	events, unsubscribe := kademlia.Events().Subscribe(2)
+	defer unsubscribe()
+	id := NewRandomKademliaID()
+
+	kademlia.UpdateRT(id, "127.0.0.1:8001")
+	kademlia.UpdateRT(id, "127.0.0.1:8001")
+
+	if event := nextEvent(t, events); event.Type != ContactAdded || !event.Contact.ID.Equals(id) {
+		t.Errorf("Expected ContactAdded for %s, got %+v", id, event)
+	}
+	if len(events) != 0 {
+		t.Error("Expected a known contact not to be added again")
+	}
+}
+
+func TestUpdateRT_PublishesContactEvicted(t *testing.T) {
+	node := newTestNode(t)
+	events, unsubscribe := node.Events().Subscribe(bucketSize+2, ContactEvicted)
+	defer unsubscribe()
+	// fill the bucket that holds every ID with the opposite first bit
+	var stale Contact
+	for i := 0; i < bucketSize; i++ {
+		id := NewRandomKademliaID()
+		(*id)[0] = ^(*node.RoutingTable.Me.ID)[0]
+		stale = NewContact(id, "127.0.0.1:1")
+		node.RoutingTable.AddContact(stale)
+	}
+	newID := NewRandomKademliaID()
+	(*newID)[0] = ^(*node.RoutingTable.Me.ID)[0]
+
+	node.UpdateRT(newID, "127.0.0.1:2")
+
+	if event := nextEvent(t, events); event.Type != ContactEvicted || event.Contact.Address != "127.0.0.1:1" {
+		t.Errorf("Expected an unresponsive contact to be evicted, got %+v", event)
+	}
+}
+
+func TestStore_PublishesValueStoredAndExpired(t *testing.T) {
+	kademlia := &Kademlia{Data: &map[string][]byte{}, valueTTL: time.Hour}
+	events, unsubscribe := kademlia.Events().Subscribe(2)
+	defer unsubscribe()
+
+	kademlia.Store("a", []byte("hello"))
+	kademlia.expireValues(time.Now())
+	kademlia.expireValues(time.Now().Add(2 * time.Hour))
+
+	if event := nextEvent(t, events); event.Type != ValueStored || event.Hash != "a" || event.Size != 5 {
+		t.Errorf("Expected ValueStored for a, got %+v", event)
+	}
+	if event := nextEvent(t, events); event.Type != ValueExpired || event.Hash != "a" {
+		t.Errorf("Expected ValueExpired for a, got %+v", event)
+	}
+	if _, found := (*kademlia.Data)["a"]; found || kademlia.storedKeys.Load() != 0 {
+		t.Error("Expected the expired value to be removed")
+	}
+}
+
+func TestNodeLookup_PublishesLookupCompleted(t *testing.T) {
+	nodes := newTestNetwork(t, 2)
+	events, unsubscribe := nodes[0].Events().Subscribe(1, LookupCompleted)
+	defer unsubscribe()
+
+	nodes[0].NodeLookup(&nodes[1].RoutingTable.Me, "")
+
+	event := nextEvent(t, events)
+	if !event.Target.Equals(nodes[1].RoutingTable.Me.ID) || event.Hops == 0 || len(event.Contacts) == 0 {
+		t.Errorf("Expected LookupCompleted for %s, got %+v", nodes[1].RoutingTable.Me.ID, event)
+	}
+}
@@ -0,0 +1,105 @@
+package kademlia
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotListening is returned by CheckHealth when Listen is not running
+	ErrNotListening = errors.New("network listener is not running")
+	// ErrActionLoopBlocked is returned by CheckHealth when the action loop
+	// did not answer in time
+	ErrActionLoopBlocked = errors.New("action loop is not responding")
+)
+
+// CheckHealth returns nil if the network listener is running and the
+// action loop answers within timeout
+func (kademlia *Kademlia) CheckHealth(timeout time.Duration) error {
+	if !kademlia.Network.listening.Load() {
+		return ErrNotListening
+	}
+
+	deadline := time.After(timeout)
+	reply := make(chan Response, 1)
+	select {
+	case kademlia.ActionChannel <- Action{Action: "Health", Reply: reply}:
+	case <-deadline:
+		return ErrActionLoopBlocked
+	}
+	select {
+	case <-reply:
+		return nil
+	case <-deadline:
+		return ErrActionLoopBlocked
+	}
+}
+
+// readinessTTL is how long CheckReady reuses the live contacts it counted
+// and its last attempt to join, so that probes do not each ping the whole
+// routing table
+const readinessTTL = 10 * time.Second
+
+// readiness caches the checks of CheckReady
+type readiness struct {
+	mutex   sync.Mutex
+	checked time.Time
+	live    int
+}
+
+// CheckReady returns nil once the node has joined the network: at least
+// minContacts contacts in its routing table answer a PING and another node
+// answered a lookup of its own ID. The contacts are pinged, and the
+// self-lookup run if it has not succeeded yet, at most every readinessTTL.
+func (kademlia *Kademlia) CheckReady(minContacts int) error {
+	readiness := &kademlia.readiness
+	readiness.mutex.Lock()
+	if time.Since(readiness.checked) > readinessTTL {
+		readiness.live = kademlia.liveContacts()
+		if !kademlia.joined.Load() {
+			kademlia.NodeLookup(&kademlia.RoutingTable.Me, "")
+		}
+		readiness.checked = time.Now()
+	}
+	live := readiness.live
+	readiness.mutex.Unlock()
+
+	if live < minContacts {
+		return fmt.Errorf("%d of %d required contacts are live", live, minContacts)
+	}
+	if !kademlia.joined.Load() {
+		return fmt.Errorf("no other node answered a lookup of own ID")
+	}
+	return nil
+}
+
+// liveContacts pings every contact in the routing table and returns how
+// many of them answered
+func (kademlia *Kademlia) liveContacts() int {
+	var contacts []Contact
+	for _, bucket := range kademlia.RoutingTable.Buckets() {
+		contacts = append(contacts, bucket...)
+	}
+
+	resultChan := make(chan bool, len(contacts))
+	var waitGroup sync.WaitGroup
+	for _, contact := range contacts {
+		waitGroup.Add(1)
+		go func(contact Contact) {
+			defer waitGroup.Done()
+			resultChan <- kademlia.Network.SendPingMessage(&kademlia.RoutingTable.Me, &contact)
+		}(contact)
+	}
+	waitGroup.Wait()
+	close(resultChan)
+
+	liveCount := 0
+	for alive := range resultChan {
+		if alive {
+			liveCount++
+		}
+	}
+	return liveCount
+}
@@ -0,0 +1,104 @@
+package kademlia
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// waitForListening waits until Listen has started on node
+func waitForListening(t *testing.T, node *Kademlia) {
+	deadline := time.Now().Add(time.Second)
+	for !node.Network.listening.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the node to start listening")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCheckHealth_Healthy(t *testing.T) {
+	node := newTestNode(t)
+	waitForListening(t, node)
+
+	if err := node.CheckHealth(time.Second); err != nil {
+		t.Errorf("Expected a healthy node, got %v", err)
+	}
+}
+
+func TestCheckHealth_ListenerStopped(t *testing.T) {
+	node := newTestNode(t)
+	waitForListening(t, node)
+	node.Network.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	if err := node.CheckHealth(time.Second); !errors.Is(err, ErrNotListening) {
+		t.Errorf("Expected ErrNotListening, got %v", err)
+	}
+}
+
+func TestCheckHealth_ActionLoopBlocked(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer conn.Close()
+	node := NewKademlia(NewRoutingTable(NewContact(NewRandomKademliaID(), conn.LocalAddr().String())), conn)
+	go node.Network.Listen(node)
+	waitForListening(t, node)
+
+	if err := node.CheckHealth(50 * time.Millisecond); !errors.Is(err, ErrActionLoopBlocked) {
+		t.Errorf("Expected ErrActionLoopBlocked, got %v", err)
+	}
+}
+
+func TestCheckReady_IsolatedNode(t *testing.T) {
+	node := newTestNode(t)
+
+	if err := node.CheckReady(1); err == nil {
+		t.Error("Expected an isolated node not to be ready")
+	}
+}
+
+func TestCheckReady_JoinedNode(t *testing.T) {
+	nodes := newTestNetwork(t, 3)
+
+	if err := nodes[0].CheckReady(2); err != nil {
+		t.Errorf("Expected the node to be ready, got %v", err)
+	}
+	if !nodes[0].joined.Load() {
+		t.Error("Expected the self-lookup to be remembered")
+	}
+	if err := nodes[0].CheckReady(3); err == nil {
+		t.Error("Expected the node not to be ready with fewer live contacts than required")
+	}
+}
+
+func TestCheckReady_NotJoinedWithoutAnswers(t *testing.T) {
+	node := newTestNode(t)
+	node.RoutingTable.AddContact(NewContact(NewRandomKademliaID(), "127.0.0.1:1"))
+
+	if err := node.CheckReady(0); err == nil {
+		t.Error("Expected a node whose self-lookup got no answer not to be ready")
+	}
+	if node.joined.Load() {
+		t.Error("Expected the node not to count as joined")
+	}
+}
+
+func TestCheckReady_ReusesLiveContacts(t *testing.T) {
+	node := newTestNode(t)
+	if err := node.CheckReady(1); err == nil {
+		t.Fatal("Expected an isolated node not to be ready")
+	}
+
+	node.RoutingTable.AddContact(newTestNode(t).RoutingTable.Me)
+	if err := node.CheckReady(1); err == nil {
+		t.Error("Expected the live contacts to be reused within readinessTTL")
+	}
+	node.readiness.checked = time.Now().Add(-2 * readinessTTL)
+	if err := node.CheckReady(1); err != nil {
+		t.Errorf("Expected the node to be ready once the contacts were pinged again, got %v", err)
+	}
+}
@@ -0,0 +1,397 @@
+package kademlia
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrUnsigned is returned for messages without a signature when
+	// signatures are required
+	ErrUnsigned = errors.New("message is not signed")
+	// ErrBadSignature is returned for messages whose signature does not
+	// match their contents and public key
+	ErrBadSignature = errors.New("invalid message signature")
+	// ErrIDMismatch is returned for messages whose SenderID is not the ID
+	// derived from their public key
+	ErrIDMismatch = errors.New("sender ID is not derived from its public key")
+	// ErrStale is returned for signed messages whose timestamp is further
+	// from the local clock than maxClockSkew
+	ErrStale = errors.New("message is too old or from the future")
+	// ErrWrongReceiver is returned for signed messages that were signed
+	// for another node, or that change state and were signed for none
+	ErrWrongReceiver = errors.New("message was not signed for this node")
+)
+
+// NodeID derives the KademliaID of length bytes that belongs to publicKey.
+// Nodes with an identity use it as their ID, so that it cannot be claimed
+// without the private key.
+func NodeID(publicKey ed25519.PublicKey, length int) *KademliaID {
+	key, _ := Sum(publicKey, SHA2_256)
+	return key.KademliaID(length)
+}
+
+// NewIdentity returns a new random Ed25519 private key for a node
+func NewIdentity() (ed25519.PrivateKey, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	return privateKey, err
+}
+
+// LoadOrCreateIdentity reads the Ed25519 private key of the node from the
+// PEM file at path. A new key that solves the static puzzle is created and
+// written there, readable only by the owner, if the file does not exist.
+func LoadOrCreateIdentity(path string, puzzle Puzzle) (ed25519.PrivateKey, error) {
+	encoded, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		privateKey, err := puzzle.NewIdentity()
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		encoded = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(path, encoded, 0600); err != nil {
+			return nil, err
+		}
+		return privateKey, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(encoded)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s does not hold a PEM private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s does not hold an Ed25519 key", path)
+	}
+	if !puzzle.SolvesStatic(privateKey.Public().(ed25519.PublicKey)) {
+		return nil, fmt.Errorf("the key in %s does not solve the static puzzle of difficulty %d, remove it to create a new one", path, puzzle.Static)
+	}
+	return privateKey, nil
+}
+
+// maxClockSkew is how far the timestamp of a signed message may be from
+// the local clock. Signed messages cannot be replayed once it has passed.
+const maxClockSkew = time.Minute
+
+// sign adds the public key of the node and its signature over the rest
+// of msg, which is signed for receiver if its ID is known. Messages are
+// sent unsigned by nodes without an identity.
+func (network *Network) sign(msg Message, receiver *KademliaID) Message {
+	if network.identity == nil {
+		return msg
+	}
+	msg.PublicKey = network.identity.Public().(ed25519.PublicKey)
+	msg.PuzzleNonce = network.puzzleNonce
+	msg.Timestamp = time.Now().UnixMilli()
+	msg.ReceiverID = receiver
+	msg.Signature = ed25519.Sign(network.identity, signedBytes(msg))
+	return msg
+}
+
+// signResponse signs a reply to a FIND_* or GET_PROVIDERS request of
+// receiver the way sign signs messages
+func (network *Network) signResponse(response Response, receiver *KademliaID) Response {
+	if network.identity == nil {
+		return response
+	}
+	response.PublicKey = network.identity.Public().(ed25519.PublicKey)
+	response.Timestamp = time.Now().UnixMilli()
+	response.ReceiverID = receiver
+	response.Signature = ed25519.Sign(network.identity, response.signedBytes())
+	return response
+}
+
+// verify checks that msg is signed by the key its SenderID is derived
+// from, recently and for the node me. Messages that change the state of
+// the receiver must name it, so that they cannot be replayed to other
+// nodes. Unsigned messages are accepted unless signatures are required.
+func (network *Network) verify(msg Message, me *KademliaID) error {
+	if msg.Signature == nil && msg.PublicKey == nil {
+		if network.requireSignatures {
+			return ErrUnsigned
+		}
+		return nil
+	}
+	if len(msg.PublicKey) != ed25519.PublicKeySize || msg.SenderID == nil {
+		return ErrBadSignature
+	}
+	if !NodeID(msg.PublicKey, msg.SenderID.Len()).Equals(msg.SenderID) {
+		return ErrIDMismatch
+	}
+	if msg.ReceiverID == nil && changesState(msg.Type) {
+		return ErrWrongReceiver
+	}
+	if err := checkFreshness(msg.Timestamp, msg.ReceiverID, me); err != nil {
+		return err
+	}
+	if !ed25519.Verify(msg.PublicKey, signedBytes(msg), msg.Signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// verifyResponse checks that response is signed by the key the ID of the
+// node it came from is derived from, if it is known, recently and for the
+// node me
+func (network *Network) verifyResponse(response Response, sender *KademliaID, me *KademliaID) error {
+	if response.Signature == nil && response.PublicKey == nil {
+		if network.requireSignatures {
+			return ErrUnsigned
+		}
+		return nil
+	}
+	if len(response.PublicKey) != ed25519.PublicKeySize {
+		return ErrBadSignature
+	}
+	if sender != nil && !NodeID(response.PublicKey, sender.Len()).Equals(sender) {
+		return ErrIDMismatch
+	}
+	if err := checkFreshness(response.Timestamp, response.ReceiverID, me); err != nil {
+		return err
+	}
+	if !ed25519.Verify(response.PublicKey, response.signedBytes(), response.Signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// verifyReply checks the signature of the reply of receiver to a request
+// of requestType that sender sent. FIND_* and GET_PROVIDERS are answered
+// with a Response, the other requests with a Message.
+func (network *Network) verifyReply(requestType string, sender *Contact, receiver *Contact, reply []byte) error {
+	switch requestType {
+	case "FIND_NODE", "FIND_DATA", "FIND_RECORD", "GET_PROVIDERS":
+		var response Response
+		if err := json.Unmarshal(reply, &response); err != nil {
+			return err
+		}
+		return network.verifyResponse(response, receiver.ID, sender.ID)
+	}
+	var msg Message
+	if err := json.Unmarshal(reply, &msg); err != nil {
+		return err
+	}
+	if err := network.verify(msg, sender.ID); err != nil {
+		return err
+	}
+	if msg.PublicKey != nil && receiver.ID != nil && !receiver.ID.Equals(msg.SenderID) {
+		return ErrIDMismatch
+	}
+	return nil
+}
+
+// checkFreshness checks that a message signed at timestamp, in Unix
+// milliseconds, is recent and, if it names a receiver, that it is me
+func checkFreshness(timestamp int64, receiver *KademliaID, me *KademliaID) error {
+	if receiver != nil && (me == nil || !receiver.Equals(me)) {
+		return ErrWrongReceiver
+	}
+	if age := time.Since(time.UnixMilli(timestamp)); age > maxClockSkew || age < -maxClockSkew {
+		return ErrStale
+	}
+	return nil
+}
+
+// changesState returns true for the requests that store or delete data
+func changesState(msgType string) bool {
+	return msgType == "STORE" || msgType == "ADD_PROVIDER" || msgType == "DELETE"
+}
+
+// signedBytes is the encoding of msg that its signature covers: every
+// field but the signature in a fixed order, so that the signature does not
+// depend on how the message was encoded on the wire
+func signedBytes(msg Message) []byte {
+	var encoder signedEncoder
+	encoder.string("type", msg.Type)
+	encoder.id("sender_id", msg.SenderID)
+	encoder.string("sender_ip", msg.SenderIP)
+	encoder.string("target_id", msg.TargetID)
+	encoder.string("target_ip", msg.TargetIP)
+	encoder.id("data_id", msg.DataID)
+	encoder.bytes("data", msg.Data)
+	encoder.record("record", msg.Record)
+	encoder.bytes("token_hash", msg.TokenHash)
+	encoder.bytes("token", msg.Token)
+	encoder.strings("trace_context", msg.TraceContext)
+	encoder.string("compression", string(msg.Compression))
+	encoder.compressions("accept_compression", msg.AcceptCompression)
+	encoder.bytes("public_key", msg.PublicKey)
+	encoder.bytes("puzzle_nonce", msg.PuzzleNonce)
+	encoder.int("timestamp", msg.Timestamp)
+	encoder.id("receiver_id", msg.ReceiverID)
+	return encoder
+}
+
+// signedBytes is the encoding of response that its signature covers, laid
+// out the way signedBytes lays out a Message
+func (response *Response) signedBytes() []byte {
+	var encoder signedEncoder
+	encoder.bytes("data", response.Data)
+	encoder.contacts("closest_contacts", response.ClosestContacts)
+	if response.Target != nil {
+		encoder.contact("target", *response.Target)
+	}
+	encoder.record("record", response.Record)
+	encoder.contacts("providers", response.Providers)
+	encoder.string("error", response.Error)
+	encoder.string("compression", string(response.Compression))
+	encoder.compressions("accept_compression", response.AcceptCompression)
+	encoder.bytes("public_key", response.PublicKey)
+	encoder.int("timestamp", response.Timestamp)
+	encoder.id("receiver_id", response.ReceiverID)
+	return encoder
+}
+
+// signedEncoder writes named fields prefixed with their length, in the
+// style of bencode. Absent IDs, contacts and records are left out.
+type signedEncoder []byte
+
+func (encoder *signedEncoder) key(name string) {
+	*encoder = fmt.Appendf(*encoder, "%d:%s", len(name), name)
+}
+
+func (encoder *signedEncoder) bytes(name string, value []byte) {
+	encoder.key(name)
+	*encoder = fmt.Appendf(*encoder, "%d:", len(value))
+	*encoder = append(*encoder, value...)
+}
+
+func (encoder *signedEncoder) string(name string, value string) {
+	encoder.bytes(name, []byte(value))
+}
+
+func (encoder *signedEncoder) int(name string, value int64) {
+	encoder.key(name)
+	*encoder = fmt.Appendf(*encoder, "i%de", value)
+}
+
+func (encoder *signedEncoder) id(name string, id *KademliaID) {
+	if id != nil {
+		encoder.bytes(name, *id)
+	}
+}
+
+// strings writes values sorted by key
+func (encoder *signedEncoder) strings(name string, values map[string]string) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	encoder.key(name)
+	*encoder = append(*encoder, 'd')
+	for _, key := range keys {
+		encoder.string(key, values[key])
+	}
+	*encoder = append(*encoder, 'e')
+}
+
+func (encoder *signedEncoder) compressions(name string, compressions []Compression) {
+	encoder.key(name)
+	*encoder = append(*encoder, 'l')
+	for _, compression := range compressions {
+		encoder.string("", string(compression))
+	}
+	*encoder = append(*encoder, 'e')
+}
+
+func (encoder *signedEncoder) contact(name string, contact Contact) {
+	encoder.key(name)
+	*encoder = append(*encoder, 'd')
+	encoder.id("id", contact.ID)
+	encoder.string("address", contact.Address)
+	if contact.Proof != nil {
+		encoder.bytes("public_key", contact.Proof.PublicKey)
+		encoder.bytes("nonce", contact.Proof.Nonce)
+	}
+	*encoder = append(*encoder, 'e')
+}
+
+func (encoder *signedEncoder) contacts(name string, contacts []Contact) {
+	encoder.key(name)
+	*encoder = append(*encoder, 'l')
+	for _, contact := range contacts {
+		encoder.contact("", contact)
+	}
+	*encoder = append(*encoder, 'e')
+}
+
+func (encoder *signedEncoder) record(name string, record *MutableRecord) {
+	if record == nil {
+		return
+	}
+	encoder.key(name)
+	*encoder = append(*encoder, 'd')
+	encoder.bytes("public_key", record.PublicKey)
+	encoder.bytes("salt", record.Salt)
+	encoder.int("seq", record.Seq)
+	encoder.bytes("value", record.Value)
+	encoder.bytes("signature", record.Signature)
+	*encoder = append(*encoder, 'e')
+}
+
+// Identify sends a PING to address and returns the contact of the node
+// that answered, with the ID its PONG was signed for
+func (network *Network) Identify(sender *Contact, address string) (Contact, error) {
+	PING := Message{
+		Type:              "PING",
+		SenderID:          sender.ID,
+		SenderIP:          sender.Address,
+		AcceptCompression: network.acceptedCompressions(),
+	}
+	response, err := network.SendMessage(sender, &Contact{Address: address}, PING)
+	if err != nil {
+		return Contact{}, err
+	}
+	var PONG Message
+	if err := json.Unmarshal(response, &PONG); err != nil {
+		return Contact{}, fmt.Errorf("malformed reply to PING: %w", err)
+	}
+	if PONG.Type != "PONG" || PONG.SenderID == nil || PONG.SenderID.Len() != sender.ID.Len() {
+		return Contact{}, fmt.Errorf("unexpected reply to PING: %s", PONG.Type)
+	}
+	if err := network.verify(PONG, sender.ID); err != nil {
+		return Contact{}, err
+	}
+	if err := network.checkPuzzle(PONG.SenderID, PONG.proof()); err != nil {
+		return Contact{}, err
+	}
+	network.rememberCompressions(address, PONG.AcceptCompression)
+	contact := NewContact(PONG.SenderID, address)
+	contact.Proof = PONG.proof()
+	return contact, nil
+}
+
+// Bootstrap adds the node at address to the routing table under the ID it
+// proves with its signature, so that the ID of a bootstrap node does not
+// have to be known in advance
+func (kademlia *Kademlia) Bootstrap(address string) (Contact, error) {
+	contact, err := kademlia.Network.Identify(&kademlia.RoutingTable.Me, address)
+	if err != nil {
+		return Contact{}, err
+	}
+	kademlia.ActionChannel <- Action{Action: "UpdateRT", SenderId: contact.ID, SenderIp: contact.Address, Proof: contact.Proof}
+	// the action loop answers once the contact has been added
+	reply := make(chan Response, 1)
+	kademlia.ActionChannel <- Action{Action: "Health", Reply: reply}
+	<-reply
+	return contact, nil
+}
@@ -0,0 +1,288 @@
+package kademlia
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSignedTestNode starts a node whose ID is derived from its identity
+// and that only accepts signed messages
+func newSignedTestNode(t *testing.T) *Kademlia {
+	return newIdentityTestNode(t, Config{RequireSignatures: true})
+}
+
+// newIdentityTestNode starts a node with config whose ID is derived from
+// a new identity
+func newIdentityTestNode(t *testing.T, config Config) *Kademlia {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	identity, err := config.Puzzle.NewIdentity()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	me := NewContact(NodeID(identity.Public().(ed25519.PublicKey), IDLength), conn.LocalAddr().String())
+	config.Logger = DiscardLogger()
+	config.Identity = identity
+	node, err := NewKademliaWithConfig(NewRoutingTable(me), conn, config)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	go node.ListenActionChannel()
+	go node.Network.Listen(node)
+	return node
+}
+
+func TestNodeID_DerivedFromPublicKey(t *testing.T) {
+	identity, _ := NewIdentity()
+	publicKey := identity.Public().(ed25519.PublicKey)
+
+	if !NodeID(publicKey, 20).Equals(NodeID(publicKey, 20)) {
+		t.Error("Expected the same ID for the same key")
+	}
+	if NodeID(publicKey, 32).Len() != 32 || NodeID(publicKey, 64).Len() != 64 {
+		t.Error("Expected IDs of the requested length")
+	}
+	other, _ := NewIdentity()
+	if NodeID(publicKey, 20).Equals(NodeID(other.Public().(ed25519.PublicKey), 20)) {
+		t.Error("Expected different IDs for different keys")
+	}
+}
+
+func TestLoadOrCreateIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.key")
+
+	created, err := LoadOrCreateIdentity(path, Puzzle{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the key to be written with mode 0600, got %v", info.Mode().Perm())
+	}
+	loaded, err := LoadOrCreateIdentity(path, Puzzle{})
+	if err != nil || !created.Equal(loaded) {
+		t.Errorf("Expected the same key to be loaded again, got %v", err)
+	}
+
+	puzzle := Puzzle{Static: 1}
+	for puzzle.SolvesStatic(loaded.Public().(ed25519.PublicKey)) {
+		puzzle.Static++
+	}
+	if _, err := LoadOrCreateIdentity(path, puzzle); err == nil {
+		t.Error("Expected an error for a key that does not solve the static puzzle")
+	}
+
+	os.WriteFile(path, []byte("not a key"), 0600)
+	if _, err := LoadOrCreateIdentity(path, Puzzle{}); err == nil {
+		t.Error("Expected an error for a file without a key")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	identity, _ := NewIdentity()
+	network := &Network{identity: identity}
+	msg := Message{
+		Type:         "STORE",
+		SenderID:     NodeID(identity.Public().(ed25519.PublicKey), IDLength),
+		SenderIP:     "127.0.0.1:8000",
+		DataID:       NewRandomKademliaID(),
+		Data:         []byte("data1"),
+		TokenHash:    []byte("token"),
+		TraceContext: map[string]string{"traceparent": "00-1", "tracestate": "a=b"},
+	}
+	receiver := NewRandomKademliaID()
+	signed := network.sign(msg, receiver)
+
+	// the receiver sees the message after it went through JSON
+	encoded, _ := json.Marshal(signed)
+	var received Message
+	json.Unmarshal(encoded, &received)
+	if err := network.verify(received, receiver); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+
+	tampered := received
+	tampered.Data = []byte("data2")
+	if err := network.verify(tampered, receiver); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected ErrBadSignature for changed data, got %v", err)
+	}
+	spoofed := received
+	spoofed.SenderID = NewRandomKademliaID()
+	if err := network.verify(spoofed, receiver); !errors.Is(err, ErrIDMismatch) {
+		t.Errorf("Expected ErrIDMismatch for another ID, got %v", err)
+	}
+	if err := network.verify(received, NewRandomKademliaID()); !errors.Is(err, ErrWrongReceiver) {
+		t.Errorf("Expected ErrWrongReceiver when replayed to another node, got %v", err)
+	}
+	if err := network.verify(network.sign(msg, nil), receiver); !errors.Is(err, ErrWrongReceiver) {
+		t.Errorf("Expected ErrWrongReceiver for a STORE signed for no node, got %v", err)
+	}
+	stale := msg
+	stale.Timestamp = time.Now().Add(-2 * maxClockSkew).UnixMilli()
+	stale.ReceiverID = receiver
+	stale.PublicKey = identity.Public().(ed25519.PublicKey)
+	stale.Signature = ed25519.Sign(identity, signedBytes(stale))
+	if err := network.verify(stale, receiver); !errors.Is(err, ErrStale) {
+		t.Errorf("Expected ErrStale for an old message, got %v", err)
+	}
+
+	if err := network.verify(msg, receiver); err != nil {
+		t.Errorf("Expected unsigned messages to be accepted, got %v", err)
+	}
+	network.requireSignatures = true
+	if err := network.verify(msg, receiver); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("Expected ErrUnsigned when signatures are required, got %v", err)
+	}
+}
+
+func TestVerifyResponse(t *testing.T) {
+	identity, _ := NewIdentity()
+	network := &Network{identity: identity}
+	sender := NodeID(identity.Public().(ed25519.PublicKey), IDLength)
+	receiver := NewRandomKademliaID()
+	signed := network.signResponse(Response{Data: []byte("data1"), ClosestContacts: []Contact{NewContact(NewRandomKademliaID(), "127.0.0.1:8001")}}, receiver)
+
+	encoded, _ := json.Marshal(signed)
+	var received Response
+	json.Unmarshal(encoded, &received)
+	if err := network.verifyResponse(received, sender, receiver); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+	if err := network.verifyResponse(received, NewRandomKademliaID(), receiver); !errors.Is(err, ErrIDMismatch) {
+		t.Errorf("Expected ErrIDMismatch for a reply from another node, got %v", err)
+	}
+	tampered := received
+	tampered.ClosestContacts = []Contact{NewContact(NewRandomKademliaID(), "127.0.0.1:8002")}
+	if err := network.verifyResponse(tampered, sender, receiver); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected ErrBadSignature for changed contacts, got %v", err)
+	}
+}
+
+func TestSignedBytes_Layout(t *testing.T) {
+	msg := Message{
+		Type:              "PING",
+		SenderID:          NewKademliaID("0102030405060708"),
+		SenderIP:          "127.0.0.1:8000",
+		AcceptCompression: []Compression{CompressionZstd},
+		Timestamp:         5,
+		Signature:         []byte("not signed"),
+	}
+
+	// nodes of every version must agree on this layout
+	expected := "4:type4:PING9:sender_id8:\x01\x02\x03\x04\x05\x06\x07\x089:sender_ip14:127.0.0.1:8000" +
+		"9:target_id0:9:target_ip0:4:data0:10:token_hash0:5:token0:13:trace_contextde11:compression0:" +
+		"18:accept_compressionl0:4:zstde10:public_key0:12:puzzle_nonce0:9:timestampi5e"
+	if got := string(signedBytes(msg)); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestVerify_IgnoresWireEncoding(t *testing.T) {
+	identity, _ := NewIdentity()
+	network := &Network{identity: identity}
+	receiver := NewRandomKademliaID()
+	contact := NewContact(NewRandomKademliaID(), "127.0.0.1:8001")
+	signed := network.sign(Message{
+		Type:     "STORE",
+		SenderID: NodeID(identity.Public().(ed25519.PublicKey), IDLength),
+		SenderIP: "127.0.0.1:8000",
+		DataID:   NewRandomKademliaID(),
+		Data:     []byte("data1"),
+	}, receiver)
+	signedResponse := network.signResponse(Response{ClosestContacts: []Contact{contact}}, receiver)
+
+	// a newer node may send fields this one does not know, and IDs in
+	// upper case hex
+	reencode := func(value interface{}, ids ...*KademliaID) []byte {
+		encoded, _ := json.Marshal(value)
+		for _, id := range ids {
+			encoded = bytes.ReplaceAll(encoded, []byte(id.String()), []byte(strings.ToUpper(id.String())))
+		}
+		return append(encoded[:len(encoded)-1], []byte(`,"NewField":"value"}`)...)
+	}
+	var received Message
+	if err := json.Unmarshal(reencode(signed, signed.SenderID, signed.DataID), &received); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := network.verify(received, receiver); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+	var receivedResponse Response
+	if err := json.Unmarshal(reencode(signedResponse, contact.ID), &receivedResponse); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := network.verifyResponse(receivedResponse, signed.SenderID, receiver); err != nil {
+		t.Errorf("Expected a valid response signature, got %v", err)
+	}
+}
+
+func TestSendPingMessage_RejectsUnsignedPong(t *testing.T) {
+	node := newSignedTestNode(t)
+	// send the PING in plain, so that the peer without an identity answers
+	node.Network.transport = nil
+	peer := newTestNode(t)
+
+	if node.Network.SendPingMessage(&node.RoutingTable.Me, &peer.RoutingTable.Me) {
+		t.Error("Expected the unsigned PONG to be rejected")
+	}
+}
+
+func TestNewKademliaWithConfig_RejectsIDNotDerivedFromIdentity(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer conn.Close()
+	identity, _ := NewIdentity()
+	me := NewContact(NewRandomKademliaID(), conn.LocalAddr().String())
+
+	if _, err := NewKademliaWithConfig(NewRoutingTable(me), conn, Config{Logger: DiscardLogger(), Identity: identity}); !errors.Is(err, ErrIDMismatch) {
+		t.Errorf("Expected ErrIDMismatch, got %v", err)
+	}
+}
+
+func TestHandleMessage_RejectsUnsigned(t *testing.T) {
+	node := newSignedTestNode(t)
+	peer := newTestNode(t)
+
+	if peer.Network.SendPingMessage(&peer.RoutingTable.Me, &node.RoutingTable.Me) {
+		t.Error("Expected the unsigned PING to be rejected")
+	}
+	if node.RoutingTable.Contains(peer.RoutingTable.Me.ID) {
+		t.Error("Expected the unsigned peer not to be added to the routing table")
+	}
+}
+
+func TestSignedNodes_StoreAndBootstrap(t *testing.T) {
+	nodes := []*Kademlia{newSignedTestNode(t), newSignedTestNode(t), newSignedTestNode(t)}
+	for _, node := range nodes[1:] {
+		contact, err := node.Bootstrap(nodes[0].RoutingTable.Me.Address)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !contact.ID.Equals(nodes[0].RoutingTable.Me.ID) || !node.RoutingTable.Contains(contact.ID) {
+			t.Errorf("Expected the bootstrap node to be added as %s, got %s", nodes[0].RoutingTable.Me.ID, contact.ID)
+		}
+		node.NodeLookup(&node.RoutingTable.Me, "")
+	}
+
+	result, err := nodes[1].PutValue([]byte("data1"), SHA1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, _, err := nodes[2].GetValue(result.Key)
+	if err != nil || string(data) != "data1" {
+		t.Errorf("Expected data1, got %q (%v)", data, err)
+	}
+}
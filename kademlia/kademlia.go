@@ -1,10 +1,18 @@
 package kademlia
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"log/slog"
 	"net"
 	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
 type Kademlia struct {
@@ -12,6 +20,20 @@ type Kademlia struct {
 	Network       *Network
 	Data          *map[string][]byte
 	ActionChannel chan Action
+	records       map[string]MutableRecord
+	providers     map[string][]ProviderRecord
+	deleteTokens  map[string][][]byte
+	tombstones    map[string]tombstone
+	storedKeys    atomic.Int64
+	storedBytes   atomic.Int64
+	logger        *slog.Logger
+	events        EventBus
+	valueTTL      time.Duration
+	disjointPaths int
+	expires       map[string]time.Time
+	compressions  map[string]Compression
+	joined        atomic.Bool
+	readiness     readiness
 }
 
 type Action struct {
@@ -19,8 +41,15 @@ type Action struct {
 	Target   *Contact
 	Hash     string
 	Data     []byte
+	Record   *MutableRecord
+	Token    []byte
 	SenderId *KademliaID
 	SenderIp string
+	// Compression is the encoding of Data for Store
+	Compression Compression
+	// Proof is the proof of the ID of the sender for UpdateRT
+	Proof *Proof
+	Reply chan Response
 }
 
 type ContactListItem struct {
@@ -45,6 +74,8 @@ func (kademlia *Kademlia) LookupContact(target *Contact) []Contact {
 	return closestContacts
 }
 
+// LookupData returns the value stored under hash as it is stored, encoded
+// with the compression kept for hash, or the closest contacts to hash
 func (kademlia *Kademlia) LookupData(hash string) ([]byte, []Contact) {
 	if value, found := (*kademlia.Data)[hash]; found {
 		return value, nil
@@ -56,33 +87,119 @@ func (kademlia *Kademlia) LookupData(hash string) ([]byte, []Contact) {
 }
 
 func (kademlia *Kademlia) Store(hash string, data []byte) {
+	kademlia.storeCompressed(hash, data, CompressionNone)
+}
+
+// storeCompressed stores data, which is encoded with compression, under hash
+func (kademlia *Kademlia) storeCompressed(hash string, data []byte, compression Compression) {
+	if current, found := (*kademlia.Data)[hash]; found {
+		kademlia.storedBytes.Add(-int64(len(current)))
+	} else {
+		kademlia.storedKeys.Add(1)
+	}
+	kademlia.storedBytes.Add(int64(len(data)))
 	(*kademlia.Data)[hash] = data
+	if compression != CompressionNone {
+		if kademlia.compressions == nil {
+			kademlia.compressions = make(map[string]Compression)
+		}
+		kademlia.compressions[hash] = compression
+	} else {
+		delete(kademlia.compressions, hash)
+	}
+	if kademlia.valueTTL > 0 {
+		if kademlia.expires == nil {
+			kademlia.expires = make(map[string]time.Time)
+		}
+		kademlia.expires[hash] = time.Now().Add(kademlia.valueTTL)
+	}
+	kademlia.events.publish(Event{Type: ValueStored, Hash: hash, Size: len(data)})
+}
+
+// StoreRecord keeps a verified mutable record unless a version with
+// the same or a higher sequence number is already stored
+func (kademlia *Kademlia) StoreRecord(hash string, record MutableRecord) error {
+	if kademlia.records == nil {
+		kademlia.records = make(map[string]MutableRecord)
+	}
+	if current, found := kademlia.records[hash]; found && current.Seq >= record.Seq {
+		if current.Seq == record.Seq && bytes.Equal(current.Signature, record.Signature) {
+			return nil
+		}
+		return fmt.Errorf("sequence number %d is not newer than %d", record.Seq, current.Seq)
+	}
+	kademlia.records[hash] = record
+	return nil
+}
+
+// LookupRecord returns the mutable record stored under hash, if any,
+// together with the closest contacts to hash
+func (kademlia *Kademlia) LookupRecord(hash string) (*MutableRecord, []Contact) {
+	targetContact := NewContact(NewKademliaID(hash), "")
+	nearestContacts := kademlia.LookupContact(&targetContact)
+	if record, found := kademlia.records[hash]; found {
+		return &record, nearestContacts
+	}
+	return nil, nearestContacts
 }
 
 func (kademlia *Kademlia) NodeLookup(target *Contact, hash string) ([]Contact, Contact, []byte) {
+	return kademlia.NodeLookupContext(context.Background(), target, hash)
+}
+
+// NodeLookupContext is NodeLookup in a span that is a child of the span in
+// ctx, the queries it sends carry the span on to the nodes they reach
+func (kademlia *Kademlia) NodeLookupContext(ctx context.Context, target *Contact, hash string) ([]Contact, Contact, []byte) {
+	start := time.Now()
+	contacts, dataProvider, data, hops := kademlia.nodeLookup(ctx, target, hash, nil)
+	lookupHops.Observe(float64(hops))
+	lookupDuration.Observe(time.Since(start).Seconds())
+	return contacts, dataProvider, data
+}
+
+// nodeLookup runs the lookup for NodeLookup and also returns how many
+// rounds of queries it took. Every round is recorded in trace unless it is nil.
+func (kademlia *Kademlia) nodeLookup(ctx context.Context, target *Contact, hash string, trace *LookupTrace) (contacts []Contact, dataProvider Contact, data []byte, hops int) {
+	ctx, span := kademlia.tracer().Start(ctx, "kademlia.NodeLookup", oteltrace.WithAttributes(
+		attribute.String("kademlia.target", target.ID.String()),
+		attribute.Bool("kademlia.find_data", hash != ""),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("kademlia.lookup.hops", hops), attribute.Bool("kademlia.data_found", data != nil))
+		span.End()
+		kademlia.events.publish(Event{Type: LookupCompleted, Target: target.ID, Contacts: contacts, Hops: hops, DataFound: data != nil})
+	}()
+
 	initialContacts := kademlia.RoutingTable.FindClosestContacts(target.ID, alpha)
 	var candidateList []ContactListItem
 	for _, contact := range initialContacts {
 		candidateList = UpdateContactList(candidateList, contact, target.ID)
 	}
+	if len(candidateList) == 0 {
+		trace.terminate(TerminationNoContacts)
+		return nil, Contact{}, nil, hops
+	}
 
 	nearestContact := candidateList[0]
 
 	for {
 		remainingUnprobed := kademlia.GetAlpha(candidateList)
 		if len(remainingUnprobed) == 0 {
-			return GetAllContactsFromContactList(candidateList), Contact{}, nil
+			trace.terminate(TerminationAllProbed)
+			return GetAllContactsFromContactList(candidateList), Contact{}, nil, hops
 		}
 
 		unprobedNodes := kademlia.GetAlpha(candidateList)
 		var dataProvider Contact
 		var retrievedData []byte
 
-		candidateList, dataProvider, retrievedData = kademlia.SendAlphaFindNodeMessages(candidateList, target, hash, unprobedNodes)
+		candidateList, dataProvider, retrievedData = kademlia.sendAlphaFindNodeMessages(ctx, candidateList, target, hash, unprobedNodes, trace.newRound(unprobedNodes))
+		hops++
 
 		if retrievedData != nil {
-			fmt.Println("Node lookup complete: data found")
-			return GetAllContactsFromContactList(candidateList), dataProvider, retrievedData
+			trace.terminate(TerminationDataFound)
+			kademlia.log().Debug("Node lookup complete: data found", "target", target.ID, "provider", dataProvider.Address, "hops", hops)
+			return GetAllContactsFromContactList(candidateList), dataProvider, retrievedData, hops
 		}
 
 		newNearestContact := candidateList[0]
@@ -90,34 +207,58 @@ func (kademlia *Kademlia) NodeLookup(target *Contact, hash string) ([]Contact, C
 		if nearestContact.Contact.ID.Equals(newNearestContact.Contact.ID) {
 			moreUnprobed := kademlia.GetAlpha(candidateList)
 			if CountProbedInContactList(candidateList) >= k || len(moreUnprobed) == 0 {
+				trace.terminate(TerminationConverged)
 				break
 			} else {
 				closestUnprobed := kademlia.GetAlphaFromKClosest(candidateList, target)
-				updatedList, _, _ := kademlia.SendAlphaFindNodeMessages(candidateList, target, hash, closestUnprobed)
+				updatedList, _, _ := kademlia.sendAlphaFindNodeMessages(ctx, candidateList, target, hash, closestUnprobed, trace.newRound(closestUnprobed))
 				candidateList = updatedList
+				hops++
 			}
 		} else {
 			nearestContact = newNearestContact
 		}
 	}
-	fmt.Println("Node lookup completed without finding data")
-	return GetAllContactsFromContactList(candidateList), Contact{}, nil
+	kademlia.log().Debug("Node lookup completed", "target", target.ID, "hops", hops)
+	return GetAllContactsFromContactList(candidateList), Contact{}, nil, hops
 }
 
 func (kademlia *Kademlia) UpdateRT(id *KademliaID, ip string) {
-	newContact := NewContact(id, ip)
+	kademlia.updateContact(NewContact(id, ip))
+}
+
+// updateContact adds newContact to the routing table, or moves it to the
+// front of its bucket if it is known
+func (kademlia *Kademlia) updateContact(newContact Contact) {
+	if newContact.ID.Len() != kademlia.RoutingTable.IDLength() {
+		kademlia.log().Warn("Rejecting contact with mismatching ID width", "peer", newContact.Address, "bits", newContact.ID.Len()*8, "network_bits", kademlia.RoutingTable.IDLength()*8)
+		return
+	}
 	if !newContact.ID.Equals(kademlia.RoutingTable.Me.ID) {
-		fmt.Printf("Inserting contact to routing table with ID: %s and IP: %s on %s\n", newContact.ID.String(), newContact.Address, kademlia.RoutingTable.Me.Address)
+		kademlia.log().Debug("Inserting contact to routing table", "peer", newContact.Address, "peer_id", newContact.ID)
 		newContact.CalcDistance(kademlia.RoutingTable.Me.ID)
 
+		known := kademlia.RoutingTable.Contains(newContact.ID)
+		if !known {
+			if err := kademlia.RoutingTable.admit(newContact); err != nil {
+				kademlia.log().Info("Rejecting contact", "peer", newContact.Address, "peer_id", newContact.ID, "reason", err)
+				kademlia.events.publish(Event{Type: ContactRejected, Contact: &newContact, Reason: err.Error()})
+				return
+			}
+		}
 		isBucketFull, previousContact := kademlia.RoutingTable.AddContact(newContact)
+		if !isBucketFull && !known {
+			kademlia.events.publish(Event{Type: ContactAdded, Contact: &newContact})
+		}
 		if isBucketFull {
 			if kademlia.Network.SendPingMessage(&kademlia.RoutingTable.Me, previousContact) {
-				fmt.Println("Previous contact is responsive, discarding the new contact")
+				kademlia.log().Debug("Previous contact is responsive, discarding the new contact", "peer", newContact.Address, "previous", previousContact.Address)
 			} else {
-				fmt.Println("Previous contact is unresponsive, replacing with the new contact")
+				kademlia.log().Info("Previous contact is unresponsive, replacing with the new contact", "peer", newContact.Address, "previous", previousContact.Address)
 				kademlia.RoutingTable.RemoveContact(previousContact)
 				kademlia.RoutingTable.AddContact(newContact)
+				kademlia.events.publish(Event{Type: ContactEvicted, Contact: previousContact})
+				kademlia.events.publish(Event{Type: ContactAdded, Contact: &newContact})
 			}
 		}
 	}
@@ -152,7 +293,7 @@ func GetAllContactsFromContactList(contactList []ContactListItem) []Contact {
 	return contactsList
 }
 
-func (kademlia *Kademlia) probeContacts(unprobedContacts []ContactListItem, target *Contact, hashKey string, contactChannel chan Contact, dataChannel chan []byte, contactDataChannel chan Contact) {
+func (kademlia *Kademlia) probeContacts(ctx context.Context, unprobedContacts []ContactListItem, target *Contact, hashKey string, contactChannel chan Contact, dataChannel chan []byte, contactDataChannel chan Contact, round *TraceRound) {
 	var waitGroup sync.WaitGroup
 
 	for _, contactItem := range unprobedContacts {
@@ -160,9 +301,9 @@ func (kademlia *Kademlia) probeContacts(unprobedContacts []ContactListItem, targ
 		go func(contactInfo Contact) {
 			defer waitGroup.Done()
 			if hashKey == "" {
-				kademlia.findContact(contactInfo, target, contactChannel, dataChannel, contactDataChannel)
+				kademlia.findContact(ctx, contactInfo, target, contactChannel, dataChannel, contactDataChannel, round)
 			} else {
-				kademlia.findData(contactInfo, hashKey, dataChannel, contactDataChannel)
+				kademlia.findData(ctx, contactInfo, hashKey, dataChannel, contactDataChannel, round)
 			}
 		}(contactItem.Contact)
 	}
@@ -224,11 +365,16 @@ func markProbedContacts(contactList []ContactListItem, unmarkedContacts []Contac
 	return contactList
 }
 func (kademlia *Kademlia) SendAlphaFindNodeMessages(contactList []ContactListItem, target *Contact, hash string, unqueriedNodes []ContactListItem) ([]ContactListItem, Contact, []byte) {
+	return kademlia.sendAlphaFindNodeMessages(context.Background(), contactList, target, hash, unqueriedNodes, nil)
+}
+
+// sendAlphaFindNodeMessages queries unqueriedNodes and records each query in round unless it is nil
+func (kademlia *Kademlia) sendAlphaFindNodeMessages(ctx context.Context, contactList []ContactListItem, target *Contact, hash string, unqueriedNodes []ContactListItem, round *TraceRound) ([]ContactListItem, Contact, []byte) {
 	nodeChannel := make(chan Contact, alpha*k)
 	dataChannel := make(chan []byte, alpha*k)
 	foundContactChannel := make(chan Contact, alpha*k)
 
-	kademlia.probeContacts(unqueriedNodes, target, hash, nodeChannel, dataChannel, foundContactChannel)
+	kademlia.probeContacts(ctx, unqueriedNodes, target, hash, nodeChannel, dataChannel, foundContactChannel, round)
 
 	closeChannels(nodeChannel, dataChannel, foundContactChannel)
 
@@ -243,28 +389,50 @@ func (kademlia *Kademlia) SendAlphaFindNodeMessages(contactList []ContactListIte
 	return contactList, Contact{}, nil
 }
 
-func (kademlia *Kademlia) findContact(contact Contact, target *Contact, nodeChannel chan Contact, responseDataChan chan []byte, responseContactChan chan Contact) {
-	retrievedContacts, err := kademlia.Network.SendFindContactMessage(&kademlia.RoutingTable.Me, &contact, target)
+func (kademlia *Kademlia) findContact(ctx context.Context, contact Contact, target *Contact, nodeChannel chan Contact, responseDataChan chan []byte, responseContactChan chan Contact, round *TraceRound) {
+	start := time.Now()
+	retrievedContacts, err := kademlia.Network.sendFindContactMessage(ctx, &kademlia.RoutingTable.Me, &contact, target)
+	round.record(contact, start, retrievedContacts, false, err)
 	if err != nil {
-		fmt.Printf("Error occurred while sending FIND_NODE message: %v\n", err)
+		kademlia.log().Warn("FIND_NODE failed", "peer", contact.Address, "error", err)
 		return
 	}
+	if target.ID.Equals(kademlia.RoutingTable.Me.ID) {
+		// the node has joined once another node answers a lookup of its own ID
+		kademlia.joined.Store(true)
+	}
 
 	for _, retrievedContact := range retrievedContacts {
+		if retrievedContact.ID == nil || retrievedContact.ID.Len() != kademlia.RoutingTable.IDLength() {
+			kademlia.log().Warn("Ignoring contact with mismatching ID width", "peer", contact.Address)
+			continue
+		}
 		select {
 		case nodeChannel <- retrievedContact:
 			responseDataChan <- nil
 			responseContactChan <- Contact{}
 		default:
-			fmt.Printf("Channel buffer full, could not send contact: %s\n", retrievedContact.String())
+			kademlia.log().Debug("Channel buffer full, dropping contact", "peer", contact.Address, "contact", retrievedContact.Address)
 		}
 	}
 }
 
-func (kademlia *Kademlia) findData(contact Contact, hashValue string, dataChannel chan []byte, responseContactChan chan Contact) {
-	_, retrievedData, err := kademlia.Network.SendFindDataMessage(&kademlia.RoutingTable.Me, &contact, hashValue)
+func (kademlia *Kademlia) findData(ctx context.Context, contact Contact, hashValue string, dataChannel chan []byte, responseContactChan chan Contact, round *TraceRound) {
+	targetHash := hashValue
+	key, keyErr := ParseKey(hashValue)
+	if keyErr == nil {
+		targetHash = key.KademliaID(kademlia.RoutingTable.IDLength()).String()
+	}
+
+	start := time.Now()
+	retrievedContacts, retrievedData, err := kademlia.Network.sendFindDataMessage(ctx, &kademlia.RoutingTable.Me, &contact, targetHash)
+	if retrievedData != nil && keyErr == nil && !key.Verify(retrievedData) {
+		kademlia.log().Warn("Discarding data that does not match key", "peer", contact.Address, "key", key.String())
+		retrievedData = nil
+	}
+	round.record(contact, start, retrievedContacts, retrievedData != nil, err)
 	if err != nil {
-		fmt.Printf("Error during FIND_DATA message: %v\n", err)
+		kademlia.log().Warn("FIND_DATA failed", "peer", contact.Address, "error", err)
 		return
 	}
 
@@ -308,32 +476,91 @@ func (kademlia *Kademlia) GetAlphaFromKClosest(candidateList []ContactListItem,
 }
 
 func (kademlia *Kademlia) ListenActionChannel() {
+	if kademlia.valueTTL > 0 {
+		go kademlia.expireValuesPeriodically()
+	}
 	for {
 		currentAction := <-kademlia.ActionChannel
 		switch currentAction.Action {
 		case "UpdateRT":
-			kademlia.UpdateRT(currentAction.SenderId, currentAction.SenderIp)
+			contact := NewContact(currentAction.SenderId, currentAction.SenderIp)
+			contact.Proof = currentAction.Proof
+			kademlia.updateContact(contact)
 		case "Store":
-			kademlia.Store(currentAction.Hash, currentAction.Data)
+			err := kademlia.storeWithToken(currentAction.Hash, currentAction.Data, currentAction.Compression, currentAction.Token)
+			if currentAction.Reply != nil {
+				currentAction.Reply <- errorResponse(err)
+			}
+		case "Health":
+			currentAction.Reply <- Response{}
+		case "ExpireValues":
+			kademlia.expireValues(time.Now())
+		case "StoredValues":
+			currentAction.Reply <- Response{Values: kademlia.storedValues()}
+		case "Delete":
+			err := kademlia.Delete(currentAction.Hash, currentAction.Token)
+			currentAction.Reply <- errorResponse(err)
+		case "StoreRecord":
+			storeResponse := Response{}
+			if err := kademlia.StoreRecord(currentAction.Hash, *currentAction.Record); err != nil {
+				storeResponse.Error = err.Error()
+			}
+			kademlia.respond(currentAction, storeResponse)
+		case "AddProvider":
+			provider := NewContact(currentAction.SenderId, currentAction.SenderIp)
+			provider.Proof = currentAction.Proof
+			kademlia.AddProvider(currentAction.Hash, provider)
+		case "GetProviders":
+			providers, nodesList := kademlia.GetProviders(currentAction.Hash)
+			providersResponse := Response{
+				Providers:       providers,
+				ClosestContacts: nodesList,
+			}
+			kademlia.respond(currentAction, providersResponse)
+		case "LookupRecord":
+			foundRecord, nodesList := kademlia.LookupRecord(currentAction.Hash)
+			recordResponse := Response{
+				Record:          foundRecord,
+				ClosestContacts: nodesList,
+			}
+			kademlia.respond(currentAction, recordResponse)
 		case "LookupContact":
 			closestNodes := kademlia.LookupContact(currentAction.Target)
 			lookupResponse := Response{
 				ClosestContacts: closestNodes,
 			}
-			kademlia.Network.responseChan <- lookupResponse
+			kademlia.respond(currentAction, lookupResponse)
 		case "LookupData":
 			foundData, nodesList := kademlia.LookupData(currentAction.Hash)
 			dataResponse := Response{
 				Data:            foundData,
+				Compression:     kademlia.compressions[currentAction.Hash],
 				ClosestContacts: nodesList,
 			}
-			kademlia.Network.responseChan <- dataResponse
+			kademlia.respond(currentAction, dataResponse)
 		case "PRINT":
 			kademlia.RoutingTable.PrintIPs()
 		}
 	}
 }
 
+// respond sends the response to an action to its Reply channel, or to the
+// network if it has none
+func (kademlia *Kademlia) respond(action Action, response Response) {
+	if action.Reply != nil {
+		action.Reply <- response
+		return
+	}
+	kademlia.Network.responseChan <- response
+}
+
+func errorResponse(err error) Response {
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	return Response{}
+}
+
 func CountProbedInContactList(contactList []ContactListItem) int {
 	probedCount := 0
 	for _, contact := range contactList {
@@ -69,3 +69,15 @@ func (kademlia *Kademlia) Get(hash string) ([]byte, error) {
 	}
 	return nil, fmt.Errorf("data not found for hash: %s", hash)
 }
+
+func TestKademlia_UpdateRT_RejectsMismatchingWidth(t *testing.T) {
+	me := NewContact(NewRandomKademliaIDWithLength(32), "172.20.0.10:8000")
+	k := &Kademlia{RoutingTable: NewRoutingTable(me), Network: &Network{}}
+
+	k.UpdateRT(NewRandomKademliaID(), "172.20.0.11:8000")
+
+	contacts := k.RoutingTable.FindClosestContacts(NewRandomKademliaIDWithLength(32), 1)
+	if len(contacts) != 0 {
+		t.Error("Contact with mismatching ID width should not be added to routing table")
+	}
+}
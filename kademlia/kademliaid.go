@@ -1,52 +1,95 @@
 package kademlia
 
 import (
+	"crypto/rand"
 	"encoding/hex"
-	"math/rand"
+	"fmt"
 )
 
-// the static number of bytes in a KademliaID
+// the default number of bytes in a KademliaID
 const IDLength = 20
 
-// type definition of a KademliaID
-type KademliaID [IDLength]byte
+// the smallest and largest supported number of bytes in a KademliaID
+const (
+	MinIDLength = 8
+	MaxIDLength = 64
+)
+
+// type definition of a KademliaID, the number of bytes decides the
+// width of the keyspace and must be the same for every node in a network
+type KademliaID []byte
 
-// NewKademliaID returns a new instance of a KademliaID based on the string input
+// NewKademliaID returns a new instance of a KademliaID based on the string input,
+// the length of the KademliaID is decided by the number of hex encoded bytes.
+// Invalid hex is not reported, strings from peers or users go through
+// ParseKademliaID.
 func NewKademliaID(data string) *KademliaID {
 	decoded, _ := hex.DecodeString(data)
 
-	newKademliaID := KademliaID{}
-	for i := 0; i < IDLength; i++ {
-		newKademliaID[i] = decoded[i]
-	}
+	newKademliaID := make(KademliaID, len(decoded))
+	copy(newKademliaID, decoded)
 
 	return &newKademliaID
 }
 
-// NewRandomKademliaID returns a new instance of a random KademliaID,
-// change this to a better version if you like
-func NewRandomKademliaID() *KademliaID {
-	newKademliaID := KademliaID{}
-	for i := 0; i < IDLength; i++ {
-		newKademliaID[i] = uint8(rand.Intn(256))
+// ParseKademliaID returns the KademliaID hex encoded in data, or an error if
+// data is not hex or not a supported width
+func ParseKademliaID(data string) (*KademliaID, error) {
+	decoded, err := hex.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KademliaID: %v", err)
+	}
+	if err := ValidateIDLength(len(decoded)); err != nil {
+		return nil, fmt.Errorf("invalid KademliaID: %v", err)
 	}
+	newKademliaID := KademliaID(decoded)
+	return &newKademliaID, nil
+}
+
+// NewRandomKademliaID returns a new instance of a random KademliaID
+func NewRandomKademliaID() *KademliaID {
+	return NewRandomKademliaIDWithLength(IDLength)
+}
+
+// NewRandomKademliaIDWithLength returns a new instance of a random KademliaID
+// that is length bytes long, read from crypto/rand so that it cannot be
+// predicted
+func NewRandomKademliaIDWithLength(length int) *KademliaID {
+	newKademliaID := make(KademliaID, length)
+	rand.Read(newKademliaID)
 	return &newKademliaID
 }
 
+// ValidateIDLength returns an error if length bytes is not a supported KademliaID width
+func ValidateIDLength(length int) error {
+	if length < MinIDLength || length > MaxIDLength {
+		return fmt.Errorf("ID length must be between %d and %d bits, got %d", MinIDLength*8, MaxIDLength*8, length*8)
+	}
+	return nil
+}
+
+// Len returns the number of bytes in the KademliaID
+func (kademliaID KademliaID) Len() int {
+	return len(kademliaID)
+}
+
 // Less returns true if kademliaID < otherKademliaID (bitwise)
 func (kademliaID KademliaID) Less(otherKademliaID *KademliaID) bool {
-	for i := 0; i < IDLength; i++ {
-		if kademliaID[i] != otherKademliaID[i] {
-			return kademliaID[i] < otherKademliaID[i]
+	for i := 0; i < len(kademliaID) && i < len(*otherKademliaID); i++ {
+		if kademliaID[i] != (*otherKademliaID)[i] {
+			return kademliaID[i] < (*otherKademliaID)[i]
 		}
 	}
-	return false
+	return len(kademliaID) < len(*otherKademliaID)
 }
 
 // Equals returns true if kademliaID == otherKademliaID (bitwise)
 func (kademliaID KademliaID) Equals(otherKademliaID *KademliaID) bool {
-	for i := 0; i < IDLength; i++ {
-		if kademliaID[i] != otherKademliaID[i] {
+	if len(kademliaID) != len(*otherKademliaID) {
+		return false
+	}
+	for i := 0; i < len(kademliaID); i++ {
+		if kademliaID[i] != (*otherKademliaID)[i] {
 			return false
 		}
 	}
@@ -54,16 +97,35 @@ func (kademliaID KademliaID) Equals(otherKademliaID *KademliaID) bool {
 }
 
 // CalcDistance returns a new instance of a KademliaID that is built
-// through a bitwise XOR operation betweeen kademliaID and target
+// through a bitwise XOR operation betweeen kademliaID and target,
+// the result has the same length as kademliaID
 func (kademliaID KademliaID) CalcDistance(target *KademliaID) *KademliaID {
-	result := KademliaID{}
-	for i := 0; i < IDLength; i++ {
-		result[i] = kademliaID[i] ^ target[i]
+	result := make(KademliaID, len(kademliaID))
+	for i := 0; i < len(kademliaID); i++ {
+		result[i] = kademliaID[i]
+		if i < len(*target) {
+			result[i] ^= (*target)[i]
+		}
 	}
 	return &result
 }
 
 // String returns a simple string representation of a KademliaID
 func (kademliaID *KademliaID) String() string {
-	return hex.EncodeToString(kademliaID[0:IDLength])
-}
\ No newline at end of file
+	return hex.EncodeToString(*kademliaID)
+}
+
+// MarshalText encodes the KademliaID as hex, which is also used for JSON
+func (kademliaID KademliaID) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(kademliaID)), nil
+}
+
+// UnmarshalText decodes a hex encoded KademliaID of a supported width
+func (kademliaID *KademliaID) UnmarshalText(text []byte) error {
+	decoded, err := ParseKademliaID(string(text))
+	if err != nil {
+		return err
+	}
+	*kademliaID = *decoded
+	return nil
+}
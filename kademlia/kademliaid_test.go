@@ -3,8 +3,10 @@ package kademlia
 import (
 	"crypto/sha1"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"net"
+	"strings"
 	"testing"
 	"time"
 )
@@ -838,4 +840,73 @@ func TestMarkProbedContacts_HandlesNilNotProbedList(t *testing.T) {
 	if updatedShortList[0].Probed {
 		t.Errorf("Expected contact %s to not be probed", updatedShortList[0].Contact.ID.String())
 	}
-}
\ No newline at end of file
+}
+
+func TestKademliaID_VariableLength(t *testing.T) {
+	id := NewRandomKademliaIDWithLength(32)
+	if id.Len() != 32 {
+		t.Errorf("Expected 32 byte ID, got %d", id.Len())
+	}
+
+	short := NewKademliaID("0000000000000000000000000000000000000001")
+	long := NewKademliaID("000000000000000000000000000000000000000100000000")
+	if short.Equals(long) {
+		t.Error("Expected IDs of different widths to not be equal")
+	}
+	if err := ValidateIDLength(4); err == nil {
+		t.Error("Expected error for 32-bit IDs")
+	}
+	if err := ValidateIDLength(32); err != nil {
+		t.Errorf("Expected 256-bit IDs to be valid, got %v", err)
+	}
+}
+
+func TestKademliaID_JSONRoundTrip(t *testing.T) {
+	contact := NewContact(NewRandomKademliaIDWithLength(16), "127.0.0.1:8000")
+
+	encoded, err := json.Marshal(contact)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(string(encoded), contact.ID.String()) {
+		t.Errorf("Expected hex encoded ID in %s", encoded)
+	}
+
+	var decoded Contact
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !decoded.ID.Equals(contact.ID) {
+		t.Errorf("Expected ID %s, got %s", contact.ID, decoded.ID)
+	}
+}
+
+func TestParseKademliaID(t *testing.T) {
+	for _, test := range []struct {
+		data  string
+		valid bool
+	}{
+		{"a94a8fe5ccb19ba61c4c0873d391e987982fbbd3", true},
+		{"0102030405060708", true},
+		{"a94a8fe5ccb19ba61c4c0873d391e987982fbbdz", false},
+		{"a94a8fe5ccb19ba61c4c0873d391e987982fbbd", false},
+		{"01020304", false},
+		{strings.Repeat("00", MaxIDLength+1), false},
+		{"", false},
+	} {
+		id, err := ParseKademliaID(test.data)
+		if test.valid && (err != nil || id.String() != test.data) {
+			t.Errorf("Expected %q to parse, got %v", test.data, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("Expected %q to be rejected, got %s", test.data, id)
+		}
+	}
+}
+
+func TestKademliaID_UnmarshalRejectsUnsupportedWidth(t *testing.T) {
+	var contact Contact
+	if err := json.Unmarshal([]byte(`{"ID":"0102","Address":"127.0.0.1:8000"}`), &contact); err == nil {
+		t.Errorf("Expected a 16-bit ID to be rejected, got %s", contact.ID)
+	}
+}
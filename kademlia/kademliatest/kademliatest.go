@@ -0,0 +1,46 @@
+// Package kademliatest starts kademlia nodes on loopback for the tests of
+// the packages built on top of kademlia.
+package kademliatest
+
+import (
+	"d7024e/kademlia"
+	"net"
+	"testing"
+)
+
+// NewNode starts a node listening on a random loopback port. Its socket
+// is closed when the test ends.
+func NewNode(t testing.TB) *kademlia.Kademlia {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	me := kademlia.NewContact(kademlia.NewRandomKademliaID(), conn.LocalAddr().String())
+	node, err := kademlia.NewKademliaWithConfig(kademlia.NewRoutingTable(me), conn, kademlia.Config{Logger: kademlia.DiscardLogger()})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	go node.ListenActionChannel()
+	go node.Network.Listen(node)
+	return node
+}
+
+// NewNetwork starts count nodes that all know about each other
+func NewNetwork(t testing.TB, count int) []*kademlia.Kademlia {
+	t.Helper()
+	nodes := make([]*kademlia.Kademlia, count)
+	for i := range nodes {
+		nodes[i] = NewNode(t)
+	}
+	for _, node := range nodes {
+		for _, other := range nodes {
+			if node != other {
+				node.RoutingTable.AddContact(other.RoutingTable.Me)
+			}
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,121 @@
+package kademlia
+
+import (
+	"errors"
+	"sync"
+)
+
+// errStopLookup is returned by the query of a lookup path to end it early
+var errStopLookup = errors.New("lookup stopped")
+
+// iterativeLookup walks towards target the same way NodeLookup does, but
+// hands every probed contact to query and keeps going until the k closest
+// contacts have all answered. This lets callers collect values from every
+// node along the way instead of stopping at the first one. Contacts that
+// fail to answer are dropped from the result.
+func (kademlia *Kademlia) iterativeLookup(target *KademliaID, query func(contact Contact) ([]Contact, error)) []Contact {
+	return kademlia.lookupPath(target, kademlia.RoutingTable.FindClosestContacts(target, k), nil, query)
+}
+
+// lookupPath is iterativeLookup starting from initial. Contacts that claim
+// refuses are never queried, a nil claim accepts every contact. The lookup
+// ends early once query returns errStopLookup.
+func (kademlia *Kademlia) lookupPath(target *KademliaID, initial []Contact, claim func(contact Contact) bool, query func(contact Contact) ([]Contact, error)) []Contact {
+	var candidateList []ContactListItem
+	for _, contact := range initial {
+		if claim == nil || claim(contact) {
+			candidateList = UpdateContactList(candidateList, contact, target)
+		}
+	}
+
+	failed := make(map[string]bool)
+	for {
+		unprobedNodes := kademlia.GetAlpha(candidateList)
+		if len(unprobedNodes) == 0 {
+			break
+		}
+
+		type queryResult struct {
+			contact  Contact
+			contacts []Contact
+			err      error
+		}
+		results := make(chan queryResult, len(unprobedNodes))
+		var waitGroup sync.WaitGroup
+		for _, item := range unprobedNodes {
+			waitGroup.Add(1)
+			go func(contact Contact) {
+				defer waitGroup.Done()
+				contacts, err := query(contact)
+				results <- queryResult{contact, contacts, err}
+			}(item.Contact)
+		}
+		waitGroup.Wait()
+		close(results)
+
+		candidateList = markProbedContacts(candidateList, unprobedNodes)
+		stopped := false
+		for result := range results {
+			if errors.Is(result.err, errStopLookup) {
+				stopped = true
+				continue
+			}
+			if result.err != nil {
+				kademlia.log().Warn("Lookup query failed", "peer", result.contact.Address, "error", result.err)
+				failed[result.contact.ID.String()] = true
+				candidateList = removeFromContactList(candidateList, result.contact)
+				continue
+			}
+			for _, contact := range result.contacts {
+				if contact.ID == nil || contact.ID.Len() != target.Len() || failed[contact.ID.String()] {
+					continue
+				}
+				if claim == nil || claim(contact) {
+					candidateList = UpdateContactList(candidateList, contact, target)
+				}
+			}
+		}
+		if stopped {
+			break
+		}
+	}
+	return GetAllContactsFromContactList(candidateList)
+}
+
+func removeFromContactList(contactList []ContactListItem, contact Contact) []ContactListItem {
+	for i, item := range contactList {
+		if item.Contact.ID.Equals(contact.ID) {
+			return append(contactList[:i], contactList[i+1:]...)
+		}
+	}
+	return contactList
+}
+
+// RecordLookup looks up the mutable record stored under target and returns
+// the valid version with the highest sequence number found on any node,
+// together with the k closest contacts to target
+func (kademlia *Kademlia) RecordLookup(target *KademliaID) (*MutableRecord, []Contact) {
+	var newest *MutableRecord
+	var mutex sync.Mutex
+
+	contacts := kademlia.iterativeLookup(target, func(contact Contact) ([]Contact, error) {
+		record, closestContacts, err := kademlia.Network.SendFindRecordMessage(&kademlia.RoutingTable.Me, &contact, target.String())
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			if err := record.Verify(target); err != nil {
+				kademlia.log().Warn("Discarding invalid record", "peer", contact.Address, "error", err)
+			} else {
+				mutex.Lock()
+				if newest == nil || record.Seq > newest.Seq {
+					newest = record
+				}
+				mutex.Unlock()
+			}
+		}
+		return closestContacts, nil
+	})
+
+	return newest, contacts
+}
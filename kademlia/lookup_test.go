@@ -0,0 +1,74 @@
+package kademlia
+
+import (
+	"net"
+	"testing"
+)
+
+// newTestNode starts a node listening on a random loopback port
+func newTestNode(t *testing.T) *Kademlia {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	me := NewContact(NewRandomKademliaID(), conn.LocalAddr().String())
+	node := NewKademlia(NewRoutingTable(me), conn)
+	go node.ListenActionChannel()
+	go node.Network.Listen(node)
+	return node
+}
+
+// newTestNetwork starts count nodes that all know about each other
+func newTestNetwork(t *testing.T, count int) []*Kademlia {
+	nodes := make([]*Kademlia, count)
+	for i := range nodes {
+		nodes[i] = newTestNode(t)
+	}
+	for _, node := range nodes {
+		for _, other := range nodes {
+			if node != other {
+				node.RoutingTable.AddContact(other.RoutingTable.Me)
+			}
+		}
+	}
+	return nodes
+}
+
+func TestRecordLookup_ReturnsNewestVersion(t *testing.T) {
+	nodes := newTestNetwork(t, 4)
+	privateKey, first := newTestRecord(t, 1, "v1")
+	target := first.ID(IDLength)
+	newer := NewMutableRecord(privateKey, []byte("config"), 2, []byte("v2"))
+
+	publisher := nodes[0]
+	if !publisher.Network.SendStoreRecordMessage(&publisher.RoutingTable.Me, &nodes[1].RoutingTable.Me, first) {
+		t.Fatal("Expected first record to be stored")
+	}
+	if !publisher.Network.SendStoreRecordMessage(&publisher.RoutingTable.Me, &nodes[2].RoutingTable.Me, newer) {
+		t.Fatal("Expected newer record to be stored")
+	}
+	if publisher.Network.SendStoreRecordMessage(&publisher.RoutingTable.Me, &nodes[2].RoutingTable.Me, first) {
+		t.Error("Expected older record to be rejected")
+	}
+
+	record, contacts := nodes[3].RecordLookup(target)
+	if record == nil || record.Seq != 2 || string(record.Value) != "v2" {
+		t.Fatalf("Expected newest record v2, got %v", record)
+	}
+	if len(contacts) == 0 {
+		t.Error("Expected lookup to return the closest contacts")
+	}
+}
+
+func TestRecordLookup_RejectsForgedRecord(t *testing.T) {
+	nodes := newTestNetwork(t, 2)
+	_, record := newTestRecord(t, 1, "v1")
+	record.Value = []byte("forged")
+
+	sender := nodes[0]
+	if sender.Network.SendStoreRecordMessage(&sender.RoutingTable.Me, &nodes[1].RoutingTable.Me, record) {
+		t.Error("Expected forged record to be rejected")
+	}
+}
@@ -0,0 +1,176 @@
+package kademlia
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Directions of an RPC in the kademlia_rpc_requests_total metric
+const (
+	directionSent     = "sent"
+	directionReceived = "received"
+)
+
+// Metrics shared by every node in the process, they are exported through
+// the collector returned by MetricsCollector
+var (
+	rpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kademlia",
+		Name:      "rpc_requests_total",
+		Help:      "RPCs sent and received by message type.",
+	}, []string{"type", "direction"})
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kademlia",
+		Name:      "rpc_duration_seconds",
+		Help:      "Round trip time of sent RPCs that got a reply, by message type.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"type"})
+	rpcTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kademlia",
+		Name:      "rpc_timeouts_total",
+		Help:      "Sent RPCs that got no reply in time, by message type.",
+	}, []string{"type"})
+	rpcErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kademlia",
+		Name:      "rpc_errors_total",
+		Help:      "RPCs that failed for other reasons than a timeout, by message type.",
+	}, []string{"type"})
+	lookupHops = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "kademlia",
+		Name:      "lookup_hops",
+		Help:      "Rounds of FIND_NODE or FIND_DATA queries a node lookup needed.",
+		Buckets:   prometheus.LinearBuckets(1, 1, 10),
+	})
+	lookupDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "kademlia",
+		Name:      "lookup_duration_seconds",
+		Help:      "Time a node lookup took.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	})
+	routingTableRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kademlia",
+		Name:      "routing_table_rejections_total",
+		Help:      "Contacts kept out of the routing table by IP diversity limits, by reason.",
+	}, []string{"reason"})
+	admissionDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kademlia",
+		Name:      "admission_dropped_total",
+		Help:      "Messages dropped and PINGs not sent by admission control, by reason.",
+	}, []string{"reason"})
+	admissionBans = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "kademlia",
+		Name:      "admission_bans_total",
+		Help:      "Peers banned for exceeding their rate limits.",
+	})
+
+	routingTableContactsDesc = prometheus.NewDesc("kademlia_routing_table_contacts",
+		"Contacts in each non-empty bucket of the routing table.", []string{"bucket"}, nil)
+	storedKeysDesc = prometheus.NewDesc("kademlia_stored_keys",
+		"Values stored on this node.", nil, nil)
+	storedBytesDesc = prometheus.NewDesc("kademlia_stored_bytes",
+		"Bytes of values stored on this node.", nil, nil)
+	handlersInFlightDesc = prometheus.NewDesc("kademlia_handlers_in_flight",
+		"Received messages this node is handling.", nil, nil)
+)
+
+// observeRPC records the outcome of an RPC sent with SendMessage
+func observeRPC(messageType string, start time.Time, err error) {
+	rpcRequests.WithLabelValues(messageType, directionSent).Inc()
+	switch {
+	case err == nil:
+		rpcDuration.WithLabelValues(messageType).Observe(time.Since(start).Seconds())
+	case isTimeout(err):
+		rpcTimeouts.WithLabelValues(messageType).Inc()
+	default:
+		rpcErrors.WithLabelValues(messageType).Inc()
+	}
+}
+
+// isTimeout returns true if err comes from a reply that did not arrive in time
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// messageType returns the type of a message passed to SendMessage
+func messageType(msg interface{}) string {
+	if message, ok := msg.(Message); ok {
+		return message.Type
+	}
+	return "UNKNOWN"
+}
+
+// handledTypes are the types of the messages a node handles
+var handledTypes = map[string]bool{
+	"PING":          true,
+	"STORE":         true,
+	"FIND_NODE":     true,
+	"FIND_DATA":     true,
+	"FIND_RECORD":   true,
+	"ADD_PROVIDER":  true,
+	"GET_PROVIDERS": true,
+	"DELETE":        true,
+}
+
+// typeLabel returns the type of a received message to label its metrics
+// and spans with. Types the node does not handle are all "UNKNOWN", so that
+// peers cannot create a series or a span name for every string they send.
+func typeLabel(msgType string) string {
+	if handledTypes[msgType] {
+		return msgType
+	}
+	return "UNKNOWN"
+}
+
+// MetricsCollector returns a collector for the RPC and lookup metrics of
+// the process together with the routing table and storage of this node
+func (kademlia *Kademlia) MetricsCollector() prometheus.Collector {
+	return &metricsCollector{kademlia: kademlia}
+}
+
+type metricsCollector struct {
+	kademlia *Kademlia
+}
+
+func (collector *metricsCollector) Describe(descs chan<- *prometheus.Desc) {
+	rpcRequests.Describe(descs)
+	rpcDuration.Describe(descs)
+	rpcTimeouts.Describe(descs)
+	rpcErrors.Describe(descs)
+	lookupHops.Describe(descs)
+	lookupDuration.Describe(descs)
+	routingTableRejections.Describe(descs)
+	admissionDropped.Describe(descs)
+	admissionBans.Describe(descs)
+	descs <- routingTableContactsDesc
+	descs <- storedKeysDesc
+	descs <- storedBytesDesc
+	descs <- handlersInFlightDesc
+}
+
+func (collector *metricsCollector) Collect(metrics chan<- prometheus.Metric) {
+	rpcRequests.Collect(metrics)
+	rpcDuration.Collect(metrics)
+	rpcTimeouts.Collect(metrics)
+	rpcErrors.Collect(metrics)
+	lookupHops.Collect(metrics)
+	lookupDuration.Collect(metrics)
+	routingTableRejections.Collect(metrics)
+	admissionDropped.Collect(metrics)
+	admissionBans.Collect(metrics)
+
+	for index, contacts := range collector.kademlia.RoutingTable.Buckets() {
+		metrics <- prometheus.MustNewConstMetric(routingTableContactsDesc, prometheus.GaugeValue,
+			float64(len(contacts)), strconv.Itoa(index))
+	}
+	metrics <- prometheus.MustNewConstMetric(storedKeysDesc, prometheus.GaugeValue,
+		float64(collector.kademlia.storedKeys.Load()))
+	metrics <- prometheus.MustNewConstMetric(storedBytesDesc, prometheus.GaugeValue,
+		float64(collector.kademlia.storedBytes.Load()))
+	metrics <- prometheus.MustNewConstMetric(handlersInFlightDesc, prometheus.GaugeValue,
+		float64(collector.kademlia.Network.admission.inFlight.Load()))
+}
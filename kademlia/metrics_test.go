@@ -0,0 +1,103 @@
+package kademlia
+
+import (
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// sampleCount returns how many observations a histogram has
+func sampleCount(t *testing.T, histogram prometheus.Observer) uint64 {
+	var metric dto.Metric
+	if err := histogram.(prometheus.Metric).Write(&metric); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestSendMessage_CountsRPCs(t *testing.T) {
+	nodes := newTestNetwork(t, 2)
+	sent := testutil.ToFloat64(rpcRequests.WithLabelValues("PING", directionSent))
+	received := testutil.ToFloat64(rpcRequests.WithLabelValues("PING", directionReceived))
+	latencies := sampleCount(t, rpcDuration.WithLabelValues("PING"))
+
+	if !nodes[0].Network.SendPingMessage(&nodes[0].RoutingTable.Me, &nodes[1].RoutingTable.Me) {
+		t.Fatal("Expected PING to be answered")
+	}
+
+	if got := testutil.ToFloat64(rpcRequests.WithLabelValues("PING", directionSent)); got != sent+1 {
+		t.Errorf("Expected %v sent PINGs, got %v", sent+1, got)
+	}
+	if got := testutil.ToFloat64(rpcRequests.WithLabelValues("PING", directionReceived)); got != received+1 {
+		t.Errorf("Expected %v received PINGs, got %v", received+1, got)
+	}
+	if got := sampleCount(t, rpcDuration.WithLabelValues("PING")); got != latencies+1 {
+		t.Error("Expected the PING latency to be observed")
+	}
+}
+
+func TestSendMessage_CountsErrors(t *testing.T) {
+	node := newTestNode(t)
+	closed := newTestNode(t)
+	closed.Network.Close()
+	before := testutil.ToFloat64(rpcErrors.WithLabelValues("PING")) + testutil.ToFloat64(rpcTimeouts.WithLabelValues("PING"))
+
+	if node.Network.SendPingMessage(&node.RoutingTable.Me, &closed.RoutingTable.Me) {
+		t.Fatal("Expected PING to a closed node to fail")
+	}
+
+	after := testutil.ToFloat64(rpcErrors.WithLabelValues("PING")) + testutil.ToFloat64(rpcTimeouts.WithLabelValues("PING"))
+	if after != before+1 {
+		t.Errorf("Expected the failed PING to be counted, got %v before and %v after", before, after)
+	}
+}
+
+func TestStore_TracksStoredKeysAndBytes(t *testing.T) {
+	kademlia := &Kademlia{Data: &map[string][]byte{}}
+
+	kademlia.StoreWithToken("a", []byte("hello"), HashDeleteToken([]byte("token")))
+	kademlia.Store("b", []byte("hi"))
+	kademlia.Store("b", []byte("hey"))
+	if kademlia.storedKeys.Load() != 2 || kademlia.storedBytes.Load() != 8 {
+		t.Fatalf("Expected 2 keys and 8 bytes, got %d keys and %d bytes", kademlia.storedKeys.Load(), kademlia.storedBytes.Load())
+	}
+
+	if err := kademlia.Delete("a", []byte("token")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if kademlia.storedKeys.Load() != 1 || kademlia.storedBytes.Load() != 3 {
+		t.Errorf("Expected 1 key and 3 bytes, got %d keys and %d bytes", kademlia.storedKeys.Load(), kademlia.storedBytes.Load())
+	}
+}
+
+func TestNodeLookup_ObservesHops(t *testing.T) {
+	nodes := newTestNetwork(t, 3)
+	hops, durations := sampleCount(t, lookupHops), sampleCount(t, lookupDuration)
+
+	nodes[0].NodeLookup(&nodes[2].RoutingTable.Me, "")
+
+	if sampleCount(t, lookupHops) != hops+1 || sampleCount(t, lookupDuration) != durations+1 {
+		t.Error("Expected the lookup hops and duration to be observed")
+	}
+}
+
+func TestHandleMessage_LabelsUnknownTypesAsUnknown(t *testing.T) {
+	node := newTestNode(t)
+	peer := newTestNode(t)
+	addr, _ := net.ResolveUDPAddr("udp", peer.RoutingTable.Me.Address)
+	node.Network.handleMessage(node, Message{Type: "FIRST"}, addr)
+	series := testutil.CollectAndCount(rpcRequests)
+	unknown := testutil.ToFloat64(rpcRequests.WithLabelValues("UNKNOWN", directionReceived))
+
+	node.Network.handleMessage(node, Message{Type: "SECOND"}, addr)
+
+	if got := testutil.ToFloat64(rpcRequests.WithLabelValues("UNKNOWN", directionReceived)); got != unknown+1 {
+		t.Errorf("Expected the message to be counted as UNKNOWN, got %v", got-unknown)
+	}
+	if got := testutil.CollectAndCount(rpcRequests); got != series {
+		t.Errorf("Expected no new series for an unknown type, got %d instead of %d", got, series)
+	}
+}
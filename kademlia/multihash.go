@@ -0,0 +1,185 @@
+package kademlia
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"lukechampine.com/blake3"
+)
+
+// HashFunc is the multicodec code of a hash function used for content keys
+type HashFunc uint64
+
+const (
+	SHA1     HashFunc = 0x11
+	SHA2_256 HashFunc = 0x12
+	SHA2_512 HashFunc = 0x13
+	BLAKE3   HashFunc = 0x1e
+)
+
+// DefaultHashFunc is used for content keys when nothing else is selected
+const DefaultHashFunc = SHA1
+
+var hashFuncNames = map[HashFunc]string{
+	SHA1:     "sha1",
+	SHA2_256: "sha2-256",
+	SHA2_512: "sha2-512",
+	BLAKE3:   "blake3",
+}
+
+var hashFuncSizes = map[HashFunc]int{
+	SHA1:     sha1.Size,
+	SHA2_256: sha256.Size,
+	SHA2_512: sha512.Size,
+	BLAKE3:   32,
+}
+
+// ParseHashFunc returns the HashFunc with the given name, e.g. "sha2-256"
+func ParseHashFunc(name string) (HashFunc, error) {
+	for fn, fnName := range hashFuncNames {
+		if strings.EqualFold(name, fnName) {
+			return fn, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown hash function: %s", name)
+}
+
+// String returns the multicodec name of the hash function
+func (fn HashFunc) String() string {
+	if name, ok := hashFuncNames[fn]; ok {
+		return name
+	}
+	return fmt.Sprintf("hash(0x%x)", uint64(fn))
+}
+
+// Size returns the digest length in bytes, or 0 if the function is unknown
+func (fn HashFunc) Size() int {
+	return hashFuncSizes[fn]
+}
+
+// digest hashes data with the hash function
+func (fn HashFunc) digest(data []byte) ([]byte, error) {
+	switch fn {
+	case SHA1:
+		sum := sha1.Sum(data)
+		return sum[:], nil
+	case SHA2_256:
+		sum := sha256.Sum256(data)
+		return sum[:], nil
+	case SHA2_512:
+		sum := sha512.Sum512(data)
+		return sum[:], nil
+	case BLAKE3:
+		sum := blake3.Sum256(data)
+		return sum[:], nil
+	}
+	return nil, fmt.Errorf("unsupported hash function: %s", fn)
+}
+
+// Multihash is a self-describing content key: a varint hash function code,
+// a varint digest length and the digest itself
+type Multihash []byte
+
+// Sum hashes data with fn and returns the multihash-encoded key
+func Sum(data []byte, fn HashFunc) (Multihash, error) {
+	digest, err := fn.digest(data)
+	if err != nil {
+		return nil, err
+	}
+	key := binary.AppendUvarint(nil, uint64(fn))
+	key = binary.AppendUvarint(key, uint64(len(digest)))
+	return append(key, digest...), nil
+}
+
+// DecodeMultihash splits a multihash into its hash function and digest
+func DecodeMultihash(buf []byte) (HashFunc, []byte, error) {
+	code, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return 0, nil, fmt.Errorf("invalid multihash: bad function code")
+	}
+	buf = buf[n:]
+	length, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return 0, nil, fmt.Errorf("invalid multihash: bad digest length")
+	}
+	buf = buf[n:]
+
+	fn := HashFunc(code)
+	if fn.Size() == 0 {
+		return 0, nil, fmt.Errorf("invalid multihash: unsupported hash function 0x%x", code)
+	}
+	if length != uint64(fn.Size()) || len(buf) != fn.Size() {
+		return 0, nil, fmt.Errorf("invalid multihash: %s digest must be %d bytes", fn, fn.Size())
+	}
+	return fn, buf, nil
+}
+
+// ParseKey parses the hex form of a content key. A plain 40 character hex
+// string is accepted as a raw SHA-1 digest so that old keys keep working.
+func ParseKey(s string) (Multihash, error) {
+	decoded, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %v", err)
+	}
+
+	if len(decoded) == sha1.Size {
+		key := binary.AppendUvarint(nil, uint64(SHA1))
+		key = binary.AppendUvarint(key, sha1.Size)
+		return append(key, decoded...), nil
+	}
+
+	if _, _, err := DecodeMultihash(decoded); err != nil {
+		return nil, err
+	}
+	return Multihash(decoded), nil
+}
+
+// HashFunc returns the hash function the key was made with
+func (key Multihash) HashFunc() HashFunc {
+	fn, _, _ := DecodeMultihash(key)
+	return fn
+}
+
+// Digest returns the full digest carried by the key
+func (key Multihash) Digest() []byte {
+	_, digest, _ := DecodeMultihash(key)
+	return digest
+}
+
+// Verify returns true if data hashes to the digest carried by the key
+func (key Multihash) Verify(data []byte) bool {
+	fn, digest, err := DecodeMultihash(key)
+	if err != nil {
+		return false
+	}
+	sum, err := fn.digest(data)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(sum, digest)
+}
+
+// KademliaID maps the key onto a keyspace of length bytes by taking the
+// leading bytes of the digest, which leaves SHA-1 keys unchanged in a
+// 160-bit network. Digests shorter than the keyspace are stretched with SHA-512.
+func (key Multihash) KademliaID(length int) *KademliaID {
+	digest := key.Digest()
+	if len(digest) < length {
+		stretched := sha512.Sum512(key)
+		digest = stretched[:]
+	}
+	id := make(KademliaID, length)
+	copy(id, digest)
+	return &id
+}
+
+// String returns the hex representation of the key
+func (key Multihash) String() string {
+	return hex.EncodeToString(key)
+}
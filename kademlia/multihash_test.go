@@ -0,0 +1,131 @@
+package kademlia
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"testing"
+)
+
+func TestSum_EncodesFunctionAndLength(t *testing.T) {
+	key, err := Sum([]byte("hello"), SHA2_256)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if key[0] != 0x12 || key[1] != 32 || len(key) != 34 {
+		t.Errorf("Expected sha2-256 multihash prefix 1220, got %s", key.String())
+	}
+	if key.HashFunc() != SHA2_256 {
+		t.Errorf("Expected hash function sha2-256, got %s", key.HashFunc())
+	}
+}
+
+func TestSum_UnsupportedFunction(t *testing.T) {
+	if _, err := Sum([]byte("hello"), HashFunc(0x99)); err == nil {
+		t.Error("Expected error for unsupported hash function")
+	}
+}
+
+func TestMultihash_VerifyAllFunctions(t *testing.T) {
+	data := []byte("Hello, Kademlia!")
+	for _, fn := range []HashFunc{SHA1, SHA2_256, SHA2_512, BLAKE3} {
+		key, err := Sum(data, fn)
+		if err != nil {
+			t.Fatalf("Expected no error for %s, got %v", fn, err)
+		}
+		if !key.Verify(data) {
+			t.Errorf("Expected %s key to verify its own data", fn)
+		}
+		if key.Verify([]byte("tampered")) {
+			t.Errorf("Expected %s key to reject other data", fn)
+		}
+		if len(key.Digest()) != fn.Size() {
+			t.Errorf("Expected %s digest of %d bytes, got %d", fn, fn.Size(), len(key.Digest()))
+		}
+	}
+}
+
+func TestMultihash_KademliaIDUnchangedForSHA1(t *testing.T) {
+	data := []byte("hash1")
+	digest := sha1.Sum(data)
+	key, _ := Sum(data, SHA1)
+
+	if key.KademliaID(IDLength).String() != hex.EncodeToString(digest[:]) {
+		t.Errorf("Expected SHA-1 key to map to its digest, got %s", key.KademliaID(IDLength).String())
+	}
+}
+
+func TestMultihash_KademliaIDTruncatesLongDigest(t *testing.T) {
+	key, _ := Sum([]byte("hash1"), SHA2_256)
+
+	if key.KademliaID(IDLength).String() != hex.EncodeToString(key.Digest()[:IDLength]) {
+		t.Errorf("Expected leading digest bytes, got %s", key.KademliaID(IDLength).String())
+	}
+}
+
+func TestParseKey_RoundTrip(t *testing.T) {
+	key, _ := Sum([]byte("hash1"), BLAKE3)
+
+	parsed, err := ParseKey(key.String())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if parsed.String() != key.String() {
+		t.Errorf("Expected %s, got %s", key.String(), parsed.String())
+	}
+}
+
+func TestParseKey_LegacySHA1Hex(t *testing.T) {
+	legacy := "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"
+
+	key, err := ParseKey(legacy)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if key.HashFunc() != SHA1 {
+		t.Errorf("Expected sha1, got %s", key.HashFunc())
+	}
+	if key.KademliaID(IDLength).String() != legacy {
+		t.Errorf("Expected KademliaID %s, got %s", legacy, key.KademliaID(IDLength).String())
+	}
+	if !key.Verify([]byte("test")) {
+		t.Error("Expected legacy key to verify its data")
+	}
+}
+
+func TestParseKey_Invalid(t *testing.T) {
+	invalid := []string{"not hex", "1220abcd", "9920" + hex.EncodeToString(make([]byte, 32))}
+	for _, s := range invalid {
+		if _, err := ParseKey(s); err == nil {
+			t.Errorf("Expected error parsing %q", s)
+		}
+	}
+}
+
+func TestParseHashFunc(t *testing.T) {
+	fn, err := ParseHashFunc("SHA2-256")
+	if err != nil || fn != SHA2_256 {
+		t.Errorf("Expected sha2-256, got %s (%v)", fn, err)
+	}
+	if _, err := ParseHashFunc("md5"); err == nil {
+		t.Error("Expected error for unknown hash function")
+	}
+}
+
+func TestMultihash_KademliaIDFollowsIDLength(t *testing.T) {
+	key, _ := Sum([]byte("hash1"), SHA2_256)
+
+	wide := key.KademliaID(32)
+	if wide.String() != hex.EncodeToString(key.Digest()) {
+		t.Errorf("Expected full sha2-256 digest in a 256-bit keyspace, got %s", wide.String())
+	}
+
+	sha1Key, _ := Sum([]byte("hash1"), SHA1)
+	stretched := sha1Key.KademliaID(32)
+	if stretched.Len() != 32 {
+		t.Errorf("Expected 32 byte ID, got %d", stretched.Len())
+	}
+	if !stretched.Equals(sha1Key.KademliaID(32)) {
+		t.Error("Expected mapping to be deterministic")
+	}
+}
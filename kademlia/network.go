@@ -1,11 +1,22 @@
 package kademlia
 
 import (
+	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// responseTimeout is how long SendMessage waits for a reply
+const responseTimeout = 3 * time.Second
+
 type Message struct {
 	Type     string      
 	SenderID *KademliaID 
@@ -14,45 +25,149 @@ type Message struct {
 	TargetIP string      
 	DataID   *KademliaID 
 	Data     []byte
+	Record   *MutableRecord `json:",omitempty"`
+	// TokenHash is sent with a STORE and Token with the DELETE that removes it
+	TokenHash []byte `json:",omitempty"`
+	Token     []byte `json:",omitempty"`
+	// TraceContext carries the W3C trace context of the span that sent the message
+	TraceContext map[string]string `json:",omitempty"`
+	// Compression is the encoding of Data, AcceptCompression the encodings
+	// the sender can decode in Data it receives
+	Compression       Compression   `json:",omitempty"`
+	AcceptCompression []Compression `json:",omitempty"`
+	// PublicKey is the identity of the sender, SenderID is derived from it,
+	// and Signature its signature over the rest of the message
+	PublicKey ed25519.PublicKey `json:",omitempty"`
+	Signature []byte            `json:",omitempty"`
+	// PuzzleNonce solves the dynamic puzzle for SenderID
+	PuzzleNonce []byte `json:",omitempty"`
+	// Timestamp is when the message was signed in Unix milliseconds, and
+	// ReceiverID the node it was signed for
+	Timestamp  int64       `json:",omitempty"`
+	ReceiverID *KademliaID `json:",omitempty"`
 }
 
 type Network struct {
 	responseChan chan Response
 	connection         net.PacketConn
+	logger       *slog.Logger
+	tracerProvider trace.TracerProvider
+	listening      atomic.Bool
+	compressionDisabled bool
+	peerCompressions    peerCompressions
+	identity            ed25519.PrivateKey
+	requireSignatures   bool
+	transport           *transport
+	puzzle              Puzzle
+	puzzleNonce         []byte
+	admission           admission
 }
 
 type Response struct {
-	Data            []byte    `json:"data"`
-	ClosestContacts []Contact `json:"closest_contacts"`
-	Target          *Contact  `json:"target"`
+	Data            []byte         `json:"data"`
+	ClosestContacts []Contact      `json:"closest_contacts"`
+	Target          *Contact       `json:"target"`
+	Record          *MutableRecord `json:"record,omitempty"`
+	Providers       []Contact      `json:"providers,omitempty"`
+	Error           string         `json:"error,omitempty"`
+	// Compression is the encoding of Data, AcceptCompression the encodings
+	// the responding node can decode
+	Compression       Compression   `json:"compression,omitempty"`
+	AcceptCompression []Compression `json:"accept_compression,omitempty"`
+	Values          []StoredValue  `json:"-"`
+	// PublicKey, Signature, Timestamp and ReceiverID sign the response the
+	// way they sign a Message
+	PublicKey  ed25519.PublicKey `json:"public_key,omitempty"`
+	Signature  []byte            `json:"signature,omitempty"`
+	Timestamp  int64             `json:"timestamp,omitempty"`
+	ReceiverID *KademliaID       `json:"receiver_id,omitempty"`
 }
 
 func NewNetwork(connection net.PacketConn) *Network {
-	return &Network{make(chan Response), connection}
+	return &Network{responseChan: make(chan Response), connection: connection}
+}
+
+// Close closes the socket of the node, which stops Listen
+func (network *Network) Close() error {
+	return network.connection.Close()
 }
 
 func (network *Network) Listen(kademliaInstance *Kademlia) {
-	fmt.Println("Listening on port 8000")
+	network.log().Info("Listening", "address", network.connection.LocalAddr().String())
+	network.listening.Store(true)
+	defer network.listening.Store(false)
 	defer network.connection.Close()
 
 	for {
 		var buffer [8192]byte
 		byteAmount, addr, err := network.connection.ReadFrom(buffer[0:])
 		if err != nil {
-			fmt.Println(err)
+			network.log().Error("Stopped listening", "error", err)
 			return
 		}
 		var msg Message
 		err = json.Unmarshal(buffer[:byteAmount], &msg)
 		if err != nil {
-			fmt.Println("Unmarshalling error, message:", err)
+			network.log().Warn("Dropping malformed message", "peer", addr.String(), "error", err)
+			rpcErrors.WithLabelValues("INVALID").Inc()
+			continue
+		}
+		if !network.admit(addr, msg.Type) {
+			continue
+		}
+		if !network.admission.acquire() {
+			admissionDropped.WithLabelValues(DroppedInFlight).Inc()
+			network.log().Debug("Dropping message, too many in flight", "type", msg.Type, "peer", addr.String())
 			continue
 		}
-		network.handleMessage(kademliaInstance, msg, addr)
+		go func() {
+			defer network.admission.release()
+			network.handleMessage(kademliaInstance, msg, addr)
+		}()
 	}
 }
 
 func (network *Network) handleMessage(kademliaInstance *Kademlia, msg Message, addr net.Addr) {
+	msgType := typeLabel(msg.Type)
+	rpcRequests.WithLabelValues(msgType, directionReceived).Inc()
+	_, span := network.startHandlerSpan(msg, addr)
+	defer span.End()
+	if msg.SenderID == nil || msg.SenderID.Len() != kademliaInstance.RoutingTable.IDLength() {
+		rpcErrors.WithLabelValues(msgType).Inc()
+		span.SetStatus(codes.Error, "ID width mismatch")
+		network.log().Warn("Rejecting message with mismatching ID width", "type", msg.Type, "peer", addr.String())
+		network.sendError(kademliaInstance, "ID width mismatch", nil, addr)
+		return
+	}
+	if err := checkTarget(msg, kademliaInstance.RoutingTable.IDLength()); err != nil {
+		rpcErrors.WithLabelValues(msgType).Inc()
+		span.SetStatus(codes.Error, err.Error())
+		network.log().Warn("Rejecting message with invalid target", "type", msg.Type, "peer", addr.String(), "error", err)
+		network.sendError(kademliaInstance, err.Error(), msg.SenderID, addr)
+		return
+	}
+	if !claimsObservedIP(msg.SenderIP, addr) {
+		rpcErrors.WithLabelValues(msgType).Inc()
+		span.SetStatus(codes.Error, ErrSenderAddress.Error())
+		network.log().Warn("Rejecting message from another IP than its sender address", "type", msg.Type, "peer", addr.String(), "sender", msg.SenderIP)
+		network.sendError(kademliaInstance, ErrSenderAddress.Error(), msg.SenderID, addr)
+		return
+	}
+	if err := network.verify(msg, kademliaInstance.RoutingTable.Me.ID); err != nil {
+		rpcErrors.WithLabelValues(msgType).Inc()
+		span.SetStatus(codes.Error, err.Error())
+		network.log().Warn("Rejecting message with invalid signature", "type", msg.Type, "peer", addr.String(), "error", err)
+		network.sendError(kademliaInstance, err.Error(), msg.SenderID, addr)
+		return
+	}
+	if err := network.checkPuzzle(msg.SenderID, msg.proof()); err != nil {
+		rpcErrors.WithLabelValues(msgType).Inc()
+		span.SetStatus(codes.Error, err.Error())
+		network.log().Warn("Rejecting message whose ID fails the puzzle", "type", msg.Type, "peer", addr.String(), "error", err)
+		network.sendError(kademliaInstance, err.Error(), msg.SenderID, addr)
+		return
+	}
+
 	switch msg.Type {
 	case "PING":
 		network.handlePing(kademliaInstance, msg, addr)
@@ -65,182 +180,561 @@ func (network *Network) handleMessage(kademliaInstance *Kademlia, msg Message, a
 
 	case "FIND_DATA":
 		network.handleFindData(kademliaInstance, msg, addr)
+
+	case "FIND_RECORD":
+		network.handleFindRecord(kademliaInstance, msg, addr)
+
+	case "ADD_PROVIDER":
+		network.handleAddProvider(kademliaInstance, msg, addr)
+
+	case "GET_PROVIDERS":
+		network.handleGetProviders(kademliaInstance, msg, addr)
+
+	case "DELETE":
+		network.handleDelete(kademliaInstance, msg, addr)
 	}
 }
 
-func (network *Network) handlePing(kademliaInstance *Kademlia, msg Message, addr net.Addr) {
-	PONG := Message{
-		Type:     "PONG",
+// targetedTypes are the messages that look up the ID in their TargetID
+var targetedTypes = map[string]bool{
+	"FIND_NODE":     true,
+	"FIND_DATA":     true,
+	"FIND_RECORD":   true,
+	"GET_PROVIDERS": true,
+}
+
+// checkTarget returns an error if the TargetID or DataID of msg is not an
+// ID of width bytes, or TargetID is missing from a message that looks it up
+func checkTarget(msg Message, width int) error {
+	if msg.DataID != nil && msg.DataID.Len() != width {
+		return fmt.Errorf("data ID width mismatch")
+	}
+	if msg.TargetID == "" && !targetedTypes[msg.Type] {
+		return nil
+	}
+	target, err := ParseKademliaID(msg.TargetID)
+	if err != nil {
+		return err
+	}
+	if target.Len() != width {
+		return fmt.Errorf("target ID width mismatch")
+	}
+	return nil
+}
+
+func (network *Network) sendError(kademliaInstance *Kademlia, reason string, receiver *KademliaID, addr net.Addr) {
+	ERROR := Message{
+		Type:     "ERROR",
 		SenderID: kademliaInstance.RoutingTable.Me.ID,
 		SenderIP: kademliaInstance.RoutingTable.Me.Address,
+		Data:     []byte(reason),
 	}
-	data, _ := json.Marshal(PONG)
-	_, err := network.connection.WriteTo(data, addr)
+	err := network.sendReply(ERROR, receiver, addr)
 	if err != nil {
-		fmt.Println("Error sending PONG:", err)
+		network.log().Warn("Error sending ERROR", "peer", addr.String(), "error", err)
+	}
+}
+
+func (network *Network) handlePing(kademliaInstance *Kademlia, msg Message, addr net.Addr) {
+	network.rememberCompressions(observedAddress(msg.SenderIP, addr), msg.AcceptCompression)
+	PONG := Message{
+		Type:              "PONG",
+		SenderID:          kademliaInstance.RoutingTable.Me.ID,
+		SenderIP:          kademliaInstance.RoutingTable.Me.Address,
+		AcceptCompression: network.acceptedCompressions(),
+	}
+	err := network.sendReply(PONG, msg.SenderID, addr)
+	if err != nil {
+		network.log().Warn("Error sending PONG", "peer", addr.String(), "error", err)
 	} else {
-		fmt.Println("Received PING. Added contact with ID:", msg.SenderID.String(), "and IP:", msg.SenderIP)
+		network.log().Debug("Received PING", "peer", msg.SenderIP, "peer_id", msg.SenderID)
 		action := Action{
 			Action:   "UpdateRT",
 			SenderId: msg.SenderID,
 			SenderIp: msg.SenderIP,
+			Proof:    msg.proof(),
 		}
 		kademliaInstance.ActionChannel <- action
 	}
 }
 
+// sendReply signs reply, a Message or a Response, for the node receiver
+// and sends it to addr
+func (network *Network) sendReply(reply interface{}, receiver *KademliaID, addr net.Addr) error {
+	switch typed := reply.(type) {
+	case Message:
+		reply = network.sign(typed, receiver)
+	case Response:
+		reply = network.signResponse(typed, receiver)
+	}
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	_, err = network.connection.WriteTo(data, addr)
+	return err
+}
+
 func (network *Network) SendPingMessage(sender *Contact, recipient *Contact) bool {
 	PING := Message{
-		Type:     "PING",
-		SenderID: sender.ID,
-		SenderIP: sender.Address,
+		Type:              "PING",
+		SenderID:          sender.ID,
+		SenderIP:          sender.Address,
+		AcceptCompression: network.acceptedCompressions(),
 	}
 
 	response, err := network.SendMessage(sender, recipient, PING)
 	if err != nil {
-		fmt.Println("Error sending PING message:", err)
+		network.log().Debug("PING failed", "peer", recipient.Address, "error", err)
 		return false
 	}
 
 	var msg Message
 	err = json.Unmarshal(response, &msg)
 	if err != nil {
-		fmt.Println("Unmarshalling error, message:", err)
+		network.log().Warn("Malformed reply to PING", "peer", recipient.Address, "error", err)
 		return false
 	}
 
 	if msg.Type == "PONG" {
-		fmt.Println("PONG from", recipient.Address)
+		network.log().Debug("Received PONG", "peer", recipient.Address)
+		network.rememberCompressions(recipient.Address, msg.AcceptCompression)
 		return true
 	} else {
-		fmt.Println("Unexpected message:", msg)
+		network.log().Warn("Unexpected reply to PING", "peer", recipient.Address, "type", msg.Type)
 		return false
 	}
 }
 
 func (network *Network) handleStore(kademliaInstance *Kademlia, msg Message, addr net.Addr) {
+	if msg.Record != nil {
+		network.handleStoreRecord(kademliaInstance, msg, addr)
+		return
+	}
+	if msg.DataID == nil {
+		network.sendError(kademliaInstance, "missing DataID", msg.SenderID, addr)
+		return
+	}
+	network.rememberCompressions(observedAddress(msg.SenderIP, addr), msg.AcceptCompression)
+	var storeResponse Response
+	if len(msg.Data) > MaxValueSize {
+		storeResponse.Error = ErrValueTooLarge.Error()
+	} else if _, err := decompress(msg.Data, msg.Compression); err != nil {
+		storeResponse.Error = err.Error()
+	} else {
+		reply := make(chan Response, 1)
+		action := Action{
+			Action:      "Store",
+			Hash:        msg.DataID.String(),
+			Data:        msg.Data,
+			Compression: msg.Compression,
+			Token:       msg.TokenHash,
+			SenderId:    msg.SenderID,
+			SenderIp:    msg.SenderIP,
+			Reply:       reply,
+		}
+		kademliaInstance.ActionChannel <- action
+		storeResponse = <-reply
+	}
+
 	STORE_ACK := Message{
 		Type:     "STORE_ACK",
 		SenderID: kademliaInstance.RoutingTable.Me.ID,
 		SenderIP: kademliaInstance.RoutingTable.Me.Address,
 	}
-	data, _ := json.Marshal(STORE_ACK)
-	_, err := network.connection.WriteTo(data, addr)
+	if storeResponse.Error != "" {
+		network.log().Info("Rejecting STORE", "peer", msg.SenderIP, "key", msg.DataID, "reason", storeResponse.Error)
+		STORE_ACK.Type = "STORE_REJECTED"
+		STORE_ACK.Data = []byte(storeResponse.Error)
+	}
+	err := network.sendReply(STORE_ACK, msg.SenderID, addr)
+	if err != nil {
+		network.log().Warn("Error sending STORE_ACK", "peer", addr.String(), "error", err)
+	} else if storeResponse.Error == "" {
+		network.log().Debug("Received STORE", "peer", msg.SenderIP, "peer_id", msg.SenderID, "key", msg.DataID)
+	}
+}
+
+func (network *Network) SendStoreMessage(sender *Contact, receiver *Contact, dataID *KademliaID, data []byte, tokenHash []byte) bool {
+	return network.sendStoreMessage(context.Background(), sender, receiver, dataID, data, tokenHash)
+}
+
+func (network *Network) sendStoreMessage(ctx context.Context, sender *Contact, receiver *Contact, dataID *KademliaID, data []byte, tokenHash []byte) bool {
+	STORE := Message{
+		Type:              "STORE",
+		SenderID:          sender.ID,
+		SenderIP:          sender.Address,
+		DataID:            dataID,
+		TokenHash:         tokenHash,
+		AcceptCompression: network.acceptedCompressions(),
+	}
+	STORE.Data, STORE.Compression = network.compressFor(data, network.compressionsOf(receiver.Address))
+	if len(STORE.Data) > MaxValueSize {
+		// every node decodes the supported compressions, a peer that
+		// did not ask for them still gets them rather than no value
+		STORE.Data, STORE.Compression = network.compressFor(data, supportedCompressions)
+	}
+	if len(STORE.Data) > MaxValueSize {
+		network.log().Warn("STORE failed", "peer", receiver.Address, "error", ErrValueTooLarge)
+		return false
+	}
+
+	response, err := network.sendMessage(ctx, sender, receiver, STORE)
+	if err != nil {
+		network.log().Warn("STORE failed", "peer", receiver.Address, "error", err)
+		return false
+	}
+
+	var STORE_ACK Message
+	err = json.Unmarshal(response, &STORE_ACK)
 	if err != nil {
-		fmt.Println("Error sending STORE_ACK:", err)
+		network.log().Warn("Malformed reply to STORE", "peer", receiver.Address, "error", err)
+		return false
+	}
+	if STORE_ACK.Type == "STORE_ACK" {
+		network.log().Debug("Received STORE_ACK", "peer", receiver.Address)
+		return true
+	} else {
+		network.log().Info("Value not stored", "peer", receiver.Address, "type", STORE_ACK.Type, "reason", string(STORE_ACK.Data))
+		return false
+	}
+}
+
+func (network *Network) handleStoreRecord(kademliaInstance *Kademlia, msg Message, addr net.Addr) {
+	reply := Message{
+		Type:     "STORE_ACK",
+		SenderID: kademliaInstance.RoutingTable.Me.ID,
+		SenderIP: kademliaInstance.RoutingTable.Me.Address,
+	}
+
+	if err := msg.Record.Verify(msg.DataID); err != nil {
+		network.log().Warn("Rejecting invalid record", "peer", msg.SenderIP, "error", err)
+		reply.Type = "STORE_REJECTED"
+		reply.Data = []byte(err.Error())
 	} else {
-		fmt.Println("Received STORE. Added to routing table ID:", msg.SenderID.String(), "with IP:", msg.SenderIP)
 		action := Action{
-			Action:   "Store",
+			Action:   "StoreRecord",
 			Hash:     msg.DataID.String(),
-			Data:     msg.Data,
+			Record:   msg.Record,
 			SenderId: msg.SenderID,
 			SenderIp: msg.SenderIP,
+			Reply:    make(chan Response, 1),
 		}
 		kademliaInstance.ActionChannel <- action
+		storeResponse := <-action.Reply
+		if storeResponse.Error != "" {
+			network.log().Info("Rejecting record", "peer", msg.SenderIP, "reason", storeResponse.Error)
+			reply.Type = "STORE_REJECTED"
+			reply.Data = []byte(storeResponse.Error)
+		}
+	}
+
+	err := network.sendReply(reply, msg.SenderID, addr)
+	if err != nil {
+		network.log().Warn("Error sending "+reply.Type, "peer", addr.String(), "error", err)
 	}
 }
 
-func (network *Network) SendStoreMessage(sender *Contact, receiver *Contact, dataID *KademliaID, data []byte) bool {
+func (network *Network) SendStoreRecordMessage(sender *Contact, receiver *Contact, record MutableRecord) bool {
 	STORE := Message{
 		Type:     "STORE",
 		SenderID: sender.ID,
 		SenderIP: sender.Address,
-		DataID:   dataID,
-		Data:     data,
+		DataID:   record.ID(sender.ID.Len()),
+		Record:   &record,
 	}
 
 	response, err := network.SendMessage(sender, receiver, STORE)
 	if err != nil {
-		fmt.Println("failed to send STORE message:", err)
+		network.log().Warn("STORE of record failed", "peer", receiver.Address, "error", err)
 		return false
 	}
 
-	var STORE_ACK Message
-	err = json.Unmarshal(response, &STORE_ACK)
+	var reply Message
+	err = json.Unmarshal(response, &reply)
 	if err != nil {
-		fmt.Println("Unmarshalling error, message:", err)
+		network.log().Warn("Malformed reply to STORE", "peer", receiver.Address, "error", err)
 		return false
 	}
-	fmt.Println("Response message:", STORE_ACK.Type)
-	if STORE_ACK.Type == "STORE_ACK" {
-		fmt.Println("STORE_ACK from", receiver.Address)
+	if reply.Type == "STORE_ACK" {
+		network.log().Debug("Received STORE_ACK", "peer", receiver.Address)
 		return true
+	}
+	network.log().Info("Record not stored", "peer", receiver.Address, "type", reply.Type, "reason", string(reply.Data))
+	return false
+}
+
+func (network *Network) handleFindRecord(kademliaInstance *Kademlia, msg Message, addr net.Addr) {
+	kademliaInstance.ActionChannel <- Action{
+		Action:   "UpdateRT",
+		SenderId: msg.SenderID,
+		SenderIp: msg.SenderIP,
+		Proof:    msg.proof(),
+	}
+	action := Action{
+		Action:   "LookupRecord",
+		SenderId: msg.SenderID,
+		SenderIp: msg.SenderIP,
+		Hash:     msg.TargetID,
+		Reply:    make(chan Response, 1),
+	}
+	kademliaInstance.ActionChannel <- action
+	recordResponse := <-action.Reply
+
+	err := network.sendReply(recordResponse, msg.SenderID, addr)
+	if err != nil {
+		network.log().Warn("Error sending record", "peer", addr.String(), "error", err)
+	}
+}
+
+func (network *Network) SendFindRecordMessage(sender *Contact, receiver *Contact, hash string) (*MutableRecord, []Contact, error) {
+	FINDRECORD := Message{
+		Type:     "FIND_RECORD",
+		SenderID: sender.ID,
+		SenderIP: sender.Address,
+		TargetID: hash,
+	}
+
+	response, err := network.SendMessage(sender, receiver, FINDRECORD)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send FIND_RECORD message: %v", err)
+	}
+
+	var result Response
+	err = json.Unmarshal(response, &result)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unmarshalling error, record: %v", err)
+	}
+	return result.Record, network.admitContacts(receiver.Address, result.ClosestContacts), nil
+}
+
+func (network *Network) handleAddProvider(kademliaInstance *Kademlia, msg Message, addr net.Addr) {
+	if msg.DataID == nil {
+		network.sendError(kademliaInstance, "missing DataID", msg.SenderID, addr)
+		return
+	}
+	ADD_PROVIDER_ACK := Message{
+		Type:     "ADD_PROVIDER_ACK",
+		SenderID: kademliaInstance.RoutingTable.Me.ID,
+		SenderIP: kademliaInstance.RoutingTable.Me.Address,
+	}
+	err := network.sendReply(ADD_PROVIDER_ACK, msg.SenderID, addr)
+	if err != nil {
+		network.log().Warn("Error sending ADD_PROVIDER_ACK", "peer", addr.String(), "error", err)
+		return
+	}
+	network.log().Debug("Received ADD_PROVIDER", "peer", msg.SenderIP, "key", msg.DataID)
+	action := Action{
+		Action:   "AddProvider",
+		Hash:     msg.DataID.String(),
+		SenderId: msg.SenderID,
+		SenderIp: msg.SenderIP,
+		Proof:    msg.proof(),
+	}
+	kademliaInstance.ActionChannel <- action
+}
+
+func (network *Network) SendAddProviderMessage(sender *Contact, receiver *Contact, dataID *KademliaID) bool {
+	ADD_PROVIDER := Message{
+		Type:     "ADD_PROVIDER",
+		SenderID: sender.ID,
+		SenderIP: sender.Address,
+		DataID:   dataID,
+	}
+
+	response, err := network.SendMessage(sender, receiver, ADD_PROVIDER)
+	if err != nil {
+		network.log().Warn("ADD_PROVIDER failed", "peer", receiver.Address, "error", err)
+		return false
+	}
+
+	var reply Message
+	err = json.Unmarshal(response, &reply)
+	if err != nil {
+		network.log().Warn("Malformed reply to ADD_PROVIDER", "peer", receiver.Address, "error", err)
+		return false
+	}
+	return reply.Type == "ADD_PROVIDER_ACK"
+}
+
+func (network *Network) handleGetProviders(kademliaInstance *Kademlia, msg Message, addr net.Addr) {
+	action := Action{
+		Action:   "GetProviders",
+		SenderId: msg.SenderID,
+		SenderIp: msg.SenderIP,
+		Hash:     msg.TargetID,
+		Reply:    make(chan Response, 1),
+	}
+	kademliaInstance.ActionChannel <- action
+	providersResponse := <-action.Reply
+
+	err := network.sendReply(providersResponse, msg.SenderID, addr)
+	if err != nil {
+		network.log().Warn("Error sending providers", "peer", addr.String(), "error", err)
+	}
+}
+
+func (network *Network) SendGetProvidersMessage(sender *Contact, receiver *Contact, hash string) ([]Contact, []Contact, error) {
+	GET_PROVIDERS := Message{
+		Type:     "GET_PROVIDERS",
+		SenderID: sender.ID,
+		SenderIP: sender.Address,
+		TargetID: hash,
+	}
+
+	response, err := network.SendMessage(sender, receiver, GET_PROVIDERS)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to send GET_PROVIDERS message: %v", err)
+	}
+
+	var result Response
+	err = json.Unmarshal(response, &result)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Unmarshalling error, providers: %v", err)
+	}
+	return network.admitContacts(receiver.Address, result.Providers), network.admitContacts(receiver.Address, result.ClosestContacts), nil
+}
+
+func (network *Network) handleDelete(kademliaInstance *Kademlia, msg Message, addr net.Addr) {
+	reply := Message{
+		Type:     "DELETE_ACK",
+		SenderID: kademliaInstance.RoutingTable.Me.ID,
+		SenderIP: kademliaInstance.RoutingTable.Me.Address,
+	}
+
+	if msg.DataID == nil {
+		reply.Type = "DELETE_REJECTED"
+		reply.Data = []byte("missing DataID")
 	} else {
-		fmt.Println("Unexpected message:", STORE_ACK)
+		deleteReply := make(chan Response, 1)
+		action := Action{
+			Action:   "Delete",
+			Hash:     msg.DataID.String(),
+			Token:    msg.Token,
+			SenderId: msg.SenderID,
+			SenderIp: msg.SenderIP,
+			Reply:    deleteReply,
+		}
+		kademliaInstance.ActionChannel <- action
+		if deleteResponse := <-deleteReply; deleteResponse.Error != "" {
+			reply.Type = "DELETE_REJECTED"
+			reply.Data = []byte(deleteResponse.Error)
+		} else {
+			network.log().Info("Deleted value", "peer", msg.SenderIP, "key", msg.DataID)
+		}
+	}
+
+	err := network.sendReply(reply, msg.SenderID, addr)
+	if err != nil {
+		network.log().Warn("Error sending "+reply.Type, "peer", addr.String(), "error", err)
+	}
+}
+
+func (network *Network) SendDeleteMessage(sender *Contact, receiver *Contact, dataID *KademliaID, token []byte) bool {
+	DELETE := Message{
+		Type:     "DELETE",
+		SenderID: sender.ID,
+		SenderIP: sender.Address,
+		DataID:   dataID,
+		Token:    token,
+	}
+
+	response, err := network.SendMessage(sender, receiver, DELETE)
+	if err != nil {
+		network.log().Warn("DELETE failed", "peer", receiver.Address, "error", err)
 		return false
 	}
+
+	var reply Message
+	err = json.Unmarshal(response, &reply)
+	if err != nil {
+		network.log().Warn("Malformed reply to DELETE", "peer", receiver.Address, "error", err)
+		return false
+	}
+	if reply.Type != "DELETE_ACK" {
+		network.log().Info("Value not deleted", "peer", receiver.Address, "type", reply.Type, "reason", string(reply.Data))
+		return false
+	}
+	return true
 }
 
 func (network *Network) handleFindData(kademliaInstance *Kademlia, msg Message, addr net.Addr) {
-	if network.SendPingMessage(&kademliaInstance.RoutingTable.Me, &Contact{ID: msg.SenderID, Address: msg.SenderIP}) {
+	if network.pingAllowed(msg.SenderIP) && network.SendPingMessage(&kademliaInstance.RoutingTable.Me, &Contact{ID: msg.SenderID, Address: msg.SenderIP}) {
 		action := Action{
 			Action:   "UpdateRT",
 			SenderId: msg.SenderID,
 			SenderIp: msg.SenderIP,
+			Proof:    msg.proof(),
 		}
 		kademliaInstance.ActionChannel <- action
 	}
+	network.rememberCompressions(observedAddress(msg.SenderIP, addr), msg.AcceptCompression)
 	action := Action{
 		Action:   "LookupData",
 		SenderId: msg.SenderID,
 		SenderIp: msg.SenderIP,
 		Hash:     msg.TargetID,
+		Reply:    make(chan Response, 1),
 	}
 	kademliaInstance.ActionChannel <- action
-	responseChannel := <-network.responseChan
+	responseChannel := <-action.Reply
 
 	response := Response{
-		Data:            responseChannel.Data,
-		ClosestContacts: responseChannel.ClosestContacts,
+		ClosestContacts:   responseChannel.ClosestContacts,
+		AcceptCompression: network.acceptedCompressions(),
 	}
-	responseChannel.Data, _ = json.Marshal(response)
-	_, err := network.connection.WriteTo(responseChannel.Data, addr)
+	response.Data, response.Compression = network.recompressFor(responseChannel.Data, responseChannel.Compression, msg.AcceptCompression)
+	err := network.sendReply(response, msg.SenderID, addr)
 	if err != nil {
-		fmt.Println("Error handle closest contacts:", err)
+		network.log().Warn("Error sending closest contacts", "peer", addr.String(), "error", err)
 	}
 }
 
 func (network *Network) SendFindDataMessage(sender *Contact, receiver *Contact, hash string) ([]Contact, []byte, error) {
+	return network.sendFindDataMessage(context.Background(), sender, receiver, hash)
+}
+
+func (network *Network) sendFindDataMessage(ctx context.Context, sender *Contact, receiver *Contact, hash string) ([]Contact, []byte, error) {
 	FINDDATA := Message{
-		Type:     "FIND_DATA",
-		SenderID: sender.ID,
-		SenderIP: sender.Address,
-		TargetID: hash,
+		Type:              "FIND_DATA",
+		SenderID:          sender.ID,
+		SenderIP:          sender.Address,
+		TargetID:          hash,
+		AcceptCompression: network.acceptedCompressions(),
 	}
 
-	response, err := network.SendMessage(sender, receiver, FINDDATA)
+	response, err := network.sendMessage(ctx, sender, receiver, FINDDATA)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to send FIND_DATA message: %v", err)
-	}
-	type Response struct {
-		Data            []byte    `json:"data"`
-		ClosestContacts []Contact `json:"closest_contacts"`
+		return nil, nil, fmt.Errorf("failed to send FIND_DATA message: %w", err)
 	}
-
 	var result Response
 	err = json.Unmarshal(response, &result)
 	if err != nil {
 		return nil, nil, fmt.Errorf("Unmarshalling error, message: %v", err)
 	}
-	data := result.Data
-	closestContacts := result.ClosestContacts
+	network.rememberCompressions(receiver.Address, result.AcceptCompression)
+	data, err := decompress(result.Data, result.Compression)
+	if err != nil {
+		return nil, nil, err
+	}
+	closestContacts := network.admitContacts(receiver.Address, result.ClosestContacts)
 
 	return closestContacts, data, nil
 }
 
 
 func (network *Network) handleFindNode(kademliaInstance *Kademlia, msg Message, addr net.Addr) {
-	fmt.Println("Received FIND_NODE")
-	if network.SendPingMessage(&kademliaInstance.RoutingTable.Me, &Contact{ID: msg.SenderID, Address: msg.SenderIP}) {
+	network.log().Debug("Received FIND_NODE", "peer", msg.SenderIP, "peer_id", msg.SenderID)
+	if network.pingAllowed(msg.SenderIP) && network.SendPingMessage(&kademliaInstance.RoutingTable.Me, &Contact{ID: msg.SenderID, Address: msg.SenderIP}) {
 		action := Action{
 			Action:   "UpdateRT",
 			SenderId: msg.SenderID,
 			SenderIp: msg.SenderIP,
+			Proof:    msg.proof(),
 		}
 		kademliaInstance.ActionChannel <- action
 	} else {
-		fmt.Println("Error receiving PONG")
+		network.log().Debug("Sender of FIND_NODE did not answer PING", "peer", msg.SenderIP)
 	}
 	contact := Contact{ID: NewKademliaID(msg.TargetID), Address: msg.SenderIP}
 	action := Action{
@@ -248,32 +742,39 @@ func (network *Network) handleFindNode(kademliaInstance *Kademlia, msg Message,
 		SenderId: NewKademliaID(msg.SenderID.String()),
 		SenderIp: msg.SenderIP,
 		Target:   &contact,
+		Reply:    make(chan Response, 1),
 	}
+	network.rememberCompressions(observedAddress(msg.SenderIP, addr), msg.AcceptCompression)
 	kademliaInstance.ActionChannel <- action
-	responseChannel := <-network.responseChan
+	responseChannel := <-action.Reply
 	response := Response{
-		Data:            responseChannel.Data,
-		ClosestContacts: responseChannel.ClosestContacts,
+		Data:              responseChannel.Data,
+		ClosestContacts:   responseChannel.ClosestContacts,
+		AcceptCompression: network.acceptedCompressions(),
 	}
-	responseChannel.Data, _ = json.Marshal(response)
-	_, err := network.connection.WriteTo(responseChannel.Data, addr)
+	err := network.sendReply(response, msg.SenderID, addr)
 	if err != nil {
-		fmt.Println("Error handle closest contacts:", err)
+		network.log().Warn("Error sending closest contacts", "peer", addr.String(), "error", err)
 	}
 }
 
 func (network *Network) SendFindContactMessage(sender *Contact, receiver *Contact, target *Contact) ([]Contact, error) {
+	return network.sendFindContactMessage(context.Background(), sender, receiver, target)
+}
+
+func (network *Network) sendFindContactMessage(ctx context.Context, sender *Contact, receiver *Contact, target *Contact) ([]Contact, error) {
 	FINDMESSAGE := Message{
-		Type:     "FIND_NODE",
-		SenderID: sender.ID,
-		SenderIP: sender.Address,
-		TargetID: target.ID.String(),
-		TargetIP: target.Address,
+		Type:              "FIND_NODE",
+		SenderID:          sender.ID,
+		SenderIP:          sender.Address,
+		TargetID:          target.ID.String(),
+		TargetIP:          target.Address,
+		AcceptCompression: network.acceptedCompressions(),
 	}
 
-	response, err := network.SendMessage(sender, receiver, FINDMESSAGE)
+	response, err := network.sendMessage(ctx, sender, receiver, FINDMESSAGE)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send FIND_NODE message: %v", err)
+		return nil, fmt.Errorf("failed to send FIND_NODE message: %w", err)
 	}
 
 	var result Response
@@ -281,12 +782,21 @@ func (network *Network) SendFindContactMessage(sender *Contact, receiver *Contac
 	if err != nil {
 		return nil, fmt.Errorf("Unmarshalling error, contacts: %v", err)
 	}
-	closestContacts := result.ClosestContacts
-	fmt.Println("Found", len(closestContacts), "closest contacts.")
+	network.rememberCompressions(receiver.Address, result.AcceptCompression)
+	closestContacts := network.admitContacts(receiver.Address, result.ClosestContacts)
+	network.log().Debug("Received closest contacts", "peer", receiver.Address, "count", len(closestContacts))
 	return closestContacts, nil
 }
 
-func (network *Network) SendMessage(sender *Contact, receiver *Contact, msg interface{}) ([]byte, error) {
+func (network *Network) SendMessage(sender *Contact, receiver *Contact, msg interface{}) (response []byte, err error) {
+	start := time.Now()
+	defer func() { observeRPC(messageType(msg), start, err) }()
+
+	request, signed := msg.(Message)
+	if signed {
+		msg = network.sign(request, receiver.ID)
+	}
+
 	udpAddr, err := net.ResolveUDPAddr("udp", receiver.Address)
 	if err != nil {
 		return nil, fmt.Errorf("UDP address error: %v", err)
@@ -303,15 +813,34 @@ func (network *Network) SendMessage(sender *Contact, receiver *Contact, msg inte
 		return nil, fmt.Errorf("error serializing message: %v", err)
 	}
 
-	_, err = connection.Write(data)
+	if network.transport != nil {
+		response, err = network.transport.exchange(connection, receiver, data)
+	} else {
+		response, err = exchange(connection, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if signed {
+		if err := network.verifyReply(request.Type, sender, receiver, response); err != nil {
+			return nil, fmt.Errorf("reply to %s: %w", request.Type, err)
+		}
+	}
+	return response, nil
+}
+
+// exchange sends a plain request over connection and waits for the reply
+func exchange(connection *net.UDPConn, data []byte) ([]byte, error) {
+	_, err := connection.Write(data)
 	if err != nil {
 		return nil, fmt.Errorf("send message error: %v", err)
 	}
 
+	connection.SetReadDeadline(time.Now().Add(responseTimeout))
 	var buffer [8192]byte
 	byteAmount, _, err := connection.ReadFromUDP(buffer[0:])
 	if err != nil {
-		return nil, fmt.Errorf("receiving response error: %v", err)
+		return nil, fmt.Errorf("receiving response error: %w", err)
 	}
 
 	return buffer[:byteAmount], nil
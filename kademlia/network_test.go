@@ -1,7 +1,10 @@
 package kademlia
 
 import (
+	"encoding/json"
+	"net"
 	"testing"
+	"time"
 )
 
 // Test NewNetwork
@@ -10,4 +13,59 @@ func TestNewNetwork(t *testing.T) {
 	if newNetwork == nil {
 		t.Error("Expected new network to be created")
 	}
-}
\ No newline at end of file
+}
+
+func TestHandleMessage_RejectsMismatchingWidth(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer conn.Close()
+	me := NewContact(NewRandomKademliaIDWithLength(32), conn.LocalAddr().String())
+	k := NewKademlia(NewRoutingTable(me), conn)
+
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer peer.Close()
+	msg := Message{Type: "PING", SenderID: NewRandomKademliaID(), SenderIP: peer.LocalAddr().String()}
+	k.Network.handleMessage(k, msg, peer.LocalAddr())
+
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	var buffer [8192]byte
+	n, _, err := peer.ReadFrom(buffer[:])
+	if err != nil {
+		t.Fatalf("Expected a reply, got %v", err)
+	}
+	var reply Message
+	json.Unmarshal(buffer[:n], &reply)
+	if reply.Type != "ERROR" {
+		t.Errorf("Expected ERROR reply, got %s", reply.Type)
+	}
+	select {
+	case action := <-k.ActionChannel:
+		t.Errorf("Expected no action for rejected peer, got %v", action)
+	default:
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	dataID := NewRandomKademliaIDWithLength(8)
+	for _, test := range []struct {
+		name  string
+		msg   Message
+		valid bool
+	}{
+		{"target", Message{Type: "FIND_NODE", TargetID: NewRandomKademliaID().String()}, true},
+		{"no target needed", Message{Type: "PING"}, true},
+		{"missing target", Message{Type: "FIND_DATA"}, false},
+		{"invalid hex", Message{Type: "FIND_NODE", TargetID: "zz" + NewRandomKademliaID().String()[2:]}, false},
+		{"other width", Message{Type: "GET_PROVIDERS", TargetID: NewRandomKademliaIDWithLength(32).String()}, false},
+		{"other data ID width", Message{Type: "STORE", DataID: dataID}, false},
+	} {
+		if err := checkTarget(test.msg, IDLength); (err == nil) != test.valid {
+			t.Errorf("%s: expected valid %v, got %v", test.name, test.valid, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package kademlia
+
+import (
+	"sync"
+	"time"
+)
+
+// providerTTL is how long a provider announcement is kept before the
+// provider has to announce itself again
+const providerTTL = 24 * time.Hour
+
+// ProviderRecord says that Provider can serve the content stored under
+// a key until Expires
+type ProviderRecord struct {
+	Provider Contact
+	Expires  time.Time
+}
+
+// maxProviders is how many providers are kept for one key
+const maxProviders = k
+
+// AddProvider adds provider to the set of providers for hash or refreshes
+// the expiry time if it is already there. Expired records are dropped
+// first and, when the set is full, the record that expires soonest makes
+// room for the new one
+func (kademlia *Kademlia) AddProvider(hash string, provider Contact) {
+	if kademlia.providers == nil {
+		kademlia.providers = make(map[string][]ProviderRecord)
+	}
+	now := time.Now()
+	record := ProviderRecord{provider, now.Add(providerTTL)}
+	records := kademlia.liveProviders(hash, now)
+	for i, existing := range records {
+		if existing.Provider.ID.Equals(provider.ID) {
+			records[i] = record
+			kademlia.providers[hash] = records
+			return
+		}
+	}
+	if len(records) >= maxProviders {
+		oldest := 0
+		for i, existing := range records {
+			if existing.Expires.Before(records[oldest].Expires) {
+				oldest = i
+			}
+		}
+		records = append(records[:oldest], records[oldest+1:]...)
+	}
+	kademlia.providers[hash] = append(records, record)
+}
+
+// GetProviders returns the providers for hash that have not expired,
+// together with the closest contacts to hash
+func (kademlia *Kademlia) GetProviders(hash string) ([]Contact, []Contact) {
+	var providers []Contact
+	records := kademlia.liveProviders(hash, time.Now())
+	for _, record := range records {
+		providers = append(providers, record.Provider)
+	}
+	if len(records) == 0 {
+		delete(kademlia.providers, hash)
+	} else {
+		kademlia.providers[hash] = records
+	}
+
+	targetContact := NewContact(NewKademliaID(hash), "")
+	return providers, kademlia.LookupContact(&targetContact)
+}
+
+// liveProviders returns the provider records for hash that have not
+// expired at now
+func (kademlia *Kademlia) liveProviders(hash string, now time.Time) []ProviderRecord {
+	var remaining []ProviderRecord
+	for _, record := range kademlia.providers[hash] {
+		if now.Before(record.Expires) {
+			remaining = append(remaining, record)
+		}
+	}
+	return remaining
+}
+
+// Provide announces this node as a provider of target on the k closest
+// contacts and returns how many of them accepted the announcement
+func (kademlia *Kademlia) Provide(target *KademliaID) (int, int) {
+	targetContact := NewContact(target, "")
+	contacts, _, _ := kademlia.NodeLookup(&targetContact, "")
+
+	resultChan := make(chan bool, len(contacts))
+	var waitGroup sync.WaitGroup
+	for _, contact := range contacts {
+		waitGroup.Add(1)
+		go func(contact Contact) {
+			defer waitGroup.Done()
+			resultChan <- kademlia.Network.SendAddProviderMessage(&kademlia.RoutingTable.Me, &contact, target)
+		}(contact)
+	}
+	waitGroup.Wait()
+	close(resultChan)
+
+	successCount := 0
+	for success := range resultChan {
+		if success {
+			successCount++
+		}
+	}
+	return successCount, len(contacts)
+}
+
+// ProviderLookup collects the providers of target from every node along
+// the lookup instead of stopping at the first node that knows of one
+func (kademlia *Kademlia) ProviderLookup(target *KademliaID) []Contact {
+	var providers []Contact
+	seen := make(map[string]bool)
+	var mutex sync.Mutex
+
+	kademlia.iterativeLookup(target, func(contact Contact) ([]Contact, error) {
+		found, closestContacts, err := kademlia.Network.SendGetProvidersMessage(&kademlia.RoutingTable.Me, &contact, target.String())
+		if err != nil {
+			return nil, err
+		}
+		mutex.Lock()
+		for _, provider := range found {
+			if provider.ID != nil && !seen[provider.ID.String()] {
+				seen[provider.ID.String()] = true
+				providers = append(providers, provider)
+			}
+		}
+		mutex.Unlock()
+		return closestContacts, nil
+	})
+
+	return providers
+}
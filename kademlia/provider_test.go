@@ -0,0 +1,115 @@
+package kademlia
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddProvider_DeduplicatesAndRefreshes(t *testing.T) {
+	kademlia := &Kademlia{RoutingTable: NewRoutingTable(NewContact(NewRandomKademliaID(), "172.20.0.1:8000"))}
+	hash := NewRandomKademliaID().String()
+	provider := NewContact(NewRandomKademliaID(), "172.20.0.2:8000")
+
+	kademlia.AddProvider(hash, provider)
+	kademlia.providers[hash][0].Expires = time.Now().Add(time.Minute)
+	kademlia.AddProvider(hash, provider)
+
+	if len(kademlia.providers[hash]) != 1 {
+		t.Fatalf("Expected 1 provider record, got %d", len(kademlia.providers[hash]))
+	}
+	if time.Until(kademlia.providers[hash][0].Expires) < providerTTL-time.Minute {
+		t.Error("Expected announcing again to refresh the expiry time")
+	}
+}
+
+func TestAddProvider_PrunesExpired(t *testing.T) {
+	kademlia := &Kademlia{RoutingTable: NewRoutingTable(NewContact(NewRandomKademliaID(), "172.20.0.1:8000"))}
+	hash := NewRandomKademliaID().String()
+
+	kademlia.AddProvider(hash, NewContact(NewRandomKademliaID(), "172.20.0.2:8000"))
+	kademlia.providers[hash][0].Expires = time.Now().Add(-time.Second)
+	live := NewContact(NewRandomKademliaID(), "172.20.0.3:8000")
+	kademlia.AddProvider(hash, live)
+
+	records := kademlia.providers[hash]
+	if len(records) != 1 || !records[0].Provider.ID.Equals(live.ID) {
+		t.Errorf("Expected the expired record to be dropped on insert, got %v", records)
+	}
+}
+
+func TestAddProvider_CapsProviders(t *testing.T) {
+	kademlia := &Kademlia{RoutingTable: NewRoutingTable(NewContact(NewRandomKademliaID(), "172.20.0.1:8000"))}
+	hash := NewRandomKademliaID().String()
+
+	var first Contact
+	for i := 0; i < 3*maxProviders; i++ {
+		provider := NewContact(NewRandomKademliaID(), "172.20.0.2:8000")
+		if i == 0 {
+			first = provider
+		}
+		kademlia.AddProvider(hash, provider)
+		kademlia.providers[hash][len(kademlia.providers[hash])-1].Expires = time.Now().Add(time.Duration(i+1) * time.Minute)
+	}
+
+	records := kademlia.providers[hash]
+	if len(records) != maxProviders {
+		t.Fatalf("Expected %d provider records, got %d", maxProviders, len(records))
+	}
+	for _, record := range records {
+		if record.Provider.ID.Equals(first.ID) {
+			t.Error("Expected the record expiring soonest to be evicted")
+		}
+	}
+}
+
+func TestGetProviders_DropsExpired(t *testing.T) {
+	kademlia := &Kademlia{RoutingTable: NewRoutingTable(NewContact(NewRandomKademliaID(), "172.20.0.1:8000"))}
+	hash := NewRandomKademliaID().String()
+	live := NewContact(NewRandomKademliaID(), "172.20.0.2:8000")
+	expired := NewContact(NewRandomKademliaID(), "172.20.0.3:8000")
+
+	kademlia.AddProvider(hash, live)
+	kademlia.AddProvider(hash, expired)
+	kademlia.providers[hash][1].Expires = time.Now().Add(-time.Second)
+
+	providers, _ := kademlia.GetProviders(hash)
+	if len(providers) != 1 || !providers[0].ID.Equals(live.ID) {
+		t.Errorf("Expected only the live provider, got %v", providers)
+	}
+	if len(kademlia.providers[hash]) != 1 {
+		t.Errorf("Expected expired provider to be removed, got %d records", len(kademlia.providers[hash]))
+	}
+}
+
+func TestGetProviders_UnknownHash(t *testing.T) {
+	kademlia := &Kademlia{RoutingTable: NewRoutingTable(NewContact(NewRandomKademliaID(), "172.20.0.1:8000"))}
+
+	providers, _ := kademlia.GetProviders(NewRandomKademliaID().String())
+	if len(providers) != 0 {
+		t.Errorf("Expected no providers, got %v", providers)
+	}
+}
+
+func TestProviderLookup_CollectsFromManyNodes(t *testing.T) {
+	nodes := newTestNetwork(t, 4)
+	target := NewRandomKademliaID()
+
+	first, second := nodes[0], nodes[1]
+	if !first.Network.SendAddProviderMessage(&first.RoutingTable.Me, &nodes[2].RoutingTable.Me, target) {
+		t.Fatal("Expected ADD_PROVIDER to be acknowledged")
+	}
+	if !second.Network.SendAddProviderMessage(&second.RoutingTable.Me, &nodes[3].RoutingTable.Me, target) {
+		t.Fatal("Expected ADD_PROVIDER to be acknowledged")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	providers := first.ProviderLookup(target)
+	if len(providers) != 2 {
+		t.Fatalf("Expected 2 providers, got %v", providers)
+	}
+	for _, provider := range providers {
+		if !provider.ID.Equals(first.RoutingTable.Me.ID) && !provider.ID.Equals(second.RoutingTable.Me.ID) {
+			t.Errorf("Unexpected provider %s", provider.String())
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package kademlia
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/bits"
+)
+
+// ErrPuzzle is returned for node IDs that do not solve the crypto puzzles
+var ErrPuzzle = errors.New("node ID does not solve the crypto puzzle")
+
+// Puzzle holds the difficulties of the S/Kademlia crypto puzzles, in
+// leading zero bits, that make node IDs expensive to choose. The static
+// puzzle is solved by the public key an ID is derived from: the hash of
+// its hash must start with Static zero bits, so a node cannot pick its
+// key, and with it its ID, freely. The dynamic puzzle is solved by a nonce
+// for the ID: the hash of the ID XOR the nonce must start with Dynamic
+// zero bits. Zero difficulties disable a puzzle, and every node of a
+// network must use the same ones.
+type Puzzle struct {
+	Static  int
+	Dynamic int
+}
+
+// Proof is the public key a node ID is derived from and the solution of
+// the dynamic puzzle for that ID, it lets any node check the ID of a contact
+type Proof struct {
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	Nonce     []byte            `json:"nonce,omitempty"`
+}
+
+// Enabled returns true if either puzzle has a difficulty
+func (puzzle Puzzle) Enabled() bool {
+	return puzzle.Static > 0 || puzzle.Dynamic > 0
+}
+
+// NewIdentity returns a new Ed25519 private key whose public key solves
+// the static puzzle
+func (puzzle Puzzle) NewIdentity() (ed25519.PrivateKey, error) {
+	for {
+		privateKey, err := NewIdentity()
+		if err != nil {
+			return nil, err
+		}
+		if puzzle.SolvesStatic(privateKey.Public().(ed25519.PublicKey)) {
+			return privateKey, nil
+		}
+	}
+}
+
+// SolvesStatic returns true if publicKey solves the static puzzle
+func (puzzle Puzzle) SolvesStatic(publicKey ed25519.PublicKey) bool {
+	hash := sha256.Sum256(publicKey)
+	hash = sha256.Sum256(hash[:])
+	return leadingZeroBits(hash[:]) >= puzzle.Static
+}
+
+// Solve returns a nonce that solves the dynamic puzzle for id, nil if it
+// is disabled
+func (puzzle Puzzle) Solve(id *KademliaID) ([]byte, error) {
+	if puzzle.Dynamic == 0 {
+		return nil, nil
+	}
+	nonce := make([]byte, id.Len())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	for !puzzle.SolvesDynamic(id, nonce) {
+		increment(nonce)
+	}
+	return nonce, nil
+}
+
+// SolvesDynamic returns true if nonce solves the dynamic puzzle for id
+func (puzzle Puzzle) SolvesDynamic(id *KademliaID, nonce []byte) bool {
+	if puzzle.Dynamic == 0 {
+		return true
+	}
+	if len(nonce) != id.Len() {
+		return false
+	}
+	hash := sha256.Sum256(*id.CalcDistance((*KademliaID)(&nonce)))
+	return leadingZeroBits(hash[:]) >= puzzle.Dynamic
+}
+
+// Verify checks that id is derived from the public key of proof and that
+// both puzzles are solved for it
+func (puzzle Puzzle) Verify(id *KademliaID, proof *Proof) error {
+	if proof == nil || len(proof.PublicKey) != ed25519.PublicKeySize {
+		return ErrPuzzle
+	}
+	if !NodeID(proof.PublicKey, id.Len()).Equals(id) {
+		return ErrIDMismatch
+	}
+	if !puzzle.SolvesStatic(proof.PublicKey) || !puzzle.SolvesDynamic(id, proof.Nonce) {
+		return ErrPuzzle
+	}
+	return nil
+}
+
+// proof returns the proof of the ID of the sender of msg, nil if it is unsigned
+func (msg Message) proof() *Proof {
+	if msg.PublicKey == nil {
+		return nil
+	}
+	return &Proof{PublicKey: msg.PublicKey, Nonce: msg.PuzzleNonce}
+}
+
+// checkPuzzle returns an error if the puzzles of the network are enabled
+// and id fails them
+func (network *Network) checkPuzzle(id *KademliaID, proof *Proof) error {
+	if !network.puzzle.Enabled() {
+		return nil
+	}
+	return network.puzzle.Verify(id, proof)
+}
+
+// admitContacts returns the contacts a peer sent whose IDs solve the
+// puzzles, the others are dropped
+func (network *Network) admitContacts(peer string, contacts []Contact) []Contact {
+	if !network.puzzle.Enabled() {
+		return contacts
+	}
+	admitted := contacts[:0:0]
+	for _, contact := range contacts {
+		if err := network.puzzle.Verify(contact.ID, contact.Proof); err != nil {
+			network.log().Warn("Dropping contact whose ID fails the puzzle", "peer", peer, "contact", contact.Address, "contact_id", contact.ID, "error", err)
+			continue
+		}
+		admitted = append(admitted, contact)
+	}
+	return admitted
+}
+
+func leadingZeroBits(hash []byte) int {
+	zeros := 0
+	for _, b := range hash {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+// increment adds one to nonce as a big-endian number
+func increment(nonce []byte) {
+	for i := len(nonce) - 1; i >= 0; i-- {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
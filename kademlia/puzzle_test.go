@@ -0,0 +1,167 @@
+package kademlia
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLeadingZeroBits(t *testing.T) {
+	for _, test := range []struct {
+		hash     []byte
+		expected int
+	}{
+		{[]byte{0x80, 0x00}, 0},
+		{[]byte{0x01, 0xff}, 7},
+		{[]byte{0x00, 0x10}, 11},
+		{[]byte{0x00, 0x00}, 16},
+	} {
+		if zeros := leadingZeroBits(test.hash); zeros != test.expected {
+			t.Errorf("Expected %d leading zero bits in %x, got %d", test.expected, test.hash, zeros)
+		}
+	}
+}
+
+func TestPuzzle_SolveAndVerify(t *testing.T) {
+	puzzle := Puzzle{Static: 6, Dynamic: 10}
+	identity, err := puzzle.NewIdentity()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	publicKey := identity.Public().(ed25519.PublicKey)
+	if !puzzle.SolvesStatic(publicKey) {
+		t.Error("Expected the new identity to solve the static puzzle")
+	}
+	id := NodeID(publicKey, IDLength)
+	nonce, err := puzzle.Solve(id)
+	if err != nil || !puzzle.SolvesDynamic(id, nonce) {
+		t.Fatalf("Expected a nonce that solves the dynamic puzzle, got %x (%v)", nonce, err)
+	}
+
+	if err := puzzle.Verify(id, &Proof{PublicKey: publicKey, Nonce: nonce}); err != nil {
+		t.Errorf("Expected the proof to be valid, got %v", err)
+	}
+	if err := puzzle.Verify(NewRandomKademliaID(), &Proof{PublicKey: publicKey, Nonce: nonce}); !errors.Is(err, ErrIDMismatch) {
+		t.Errorf("Expected ErrIDMismatch for a chosen ID, got %v", err)
+	}
+	wrong := append([]byte{}, nonce...)
+	for puzzle.SolvesDynamic(id, wrong) {
+		increment(wrong)
+	}
+	if err := puzzle.Verify(id, &Proof{PublicKey: publicKey, Nonce: wrong}); !errors.Is(err, ErrPuzzle) {
+		t.Errorf("Expected ErrPuzzle for a nonce that does not solve it, got %v", err)
+	}
+	if err := puzzle.Verify(id, nil); !errors.Is(err, ErrPuzzle) {
+		t.Errorf("Expected ErrPuzzle without a proof, got %v", err)
+	}
+}
+
+func TestPuzzle_Disabled(t *testing.T) {
+	var puzzle Puzzle
+	if puzzle.Enabled() {
+		t.Error("Expected the zero puzzle to be disabled")
+	}
+	if nonce, err := puzzle.Solve(NewRandomKademliaID()); nonce != nil || err != nil {
+		t.Errorf("Expected no nonce, got %x (%v)", nonce, err)
+	}
+	if contacts := (&Network{}).admitContacts("peer", []Contact{NewContact(NewRandomKademliaID(), "127.0.0.1:1")}); len(contacts) != 1 {
+		t.Errorf("Expected contacts to be admitted without puzzles, got %v", contacts)
+	}
+}
+
+func TestAdmitContacts(t *testing.T) {
+	puzzle := Puzzle{Static: 2, Dynamic: 2}
+	identity, _ := puzzle.NewIdentity()
+	publicKey := identity.Public().(ed25519.PublicKey)
+	id := NodeID(publicKey, IDLength)
+	nonce, _ := puzzle.Solve(id)
+
+	valid := NewContact(id, "127.0.0.1:1")
+	valid.Proof = &Proof{PublicKey: publicKey, Nonce: nonce}
+	chosen := NewContact(NewRandomKademliaID(), "127.0.0.1:2")
+	chosen.Proof = valid.Proof
+
+	network := &Network{puzzle: puzzle}
+	contacts := network.admitContacts("peer", []Contact{valid, chosen, NewContact(NewRandomKademliaID(), "127.0.0.1:3")})
+	if len(contacts) != 1 || !contacts[0].ID.Equals(id) {
+		t.Errorf("Expected only the contact with a valid proof, got %v", contacts)
+	}
+}
+
+func TestPuzzleNodes(t *testing.T) {
+	config := Config{RequireSignatures: true, Puzzle: Puzzle{Static: 4, Dynamic: 4}}
+	nodes := []*Kademlia{newIdentityTestNode(t, config), newIdentityTestNode(t, config), newIdentityTestNode(t, config)}
+	for _, node := range nodes[1:] {
+		if _, err := node.Bootstrap(nodes[0].RoutingTable.Me.Address); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	contacts, err := nodes[1].Network.SendFindContactMessage(&nodes[1].RoutingTable.Me, &nodes[0].RoutingTable.Me, &nodes[2].RoutingTable.Me)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	found := false
+	for _, contact := range contacts {
+		found = found || contact.ID.Equals(nodes[2].RoutingTable.Me.ID)
+	}
+	if !found {
+		t.Errorf("Expected the contact of the third node with its proof, got %v", contacts)
+	}
+
+	// an identity that was not made for the puzzle is rejected
+	var outsider *Kademlia
+	for outsider == nil || config.Puzzle.SolvesStatic(outsider.RoutingTable.Me.Proof.PublicKey) {
+		outsider = newIdentityTestNode(t, Config{Puzzle: Puzzle{Dynamic: 4}})
+	}
+	if outsider.Network.SendPingMessage(&outsider.RoutingTable.Me, &nodes[0].RoutingTable.Me) {
+		t.Error("Expected the PING of a node that fails the static puzzle to be rejected")
+	}
+	if nodes[0].RoutingTable.Contains(outsider.RoutingTable.Me.ID) {
+		t.Error("Expected the node that fails the puzzle not to be added to the routing table")
+	}
+}
+
+func TestNewKademliaWithConfig_RejectsIdentityFailingPuzzle(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer conn.Close()
+	puzzle := Puzzle{Static: 8}
+	identity, _ := NewIdentity()
+	for puzzle.SolvesStatic(identity.Public().(ed25519.PublicKey)) {
+		identity, _ = NewIdentity()
+	}
+	me := NewContact(NodeID(identity.Public().(ed25519.PublicKey), IDLength), conn.LocalAddr().String())
+
+	_, err = NewKademliaWithConfig(NewRoutingTable(me), conn, Config{Logger: DiscardLogger(), Identity: identity, Puzzle: puzzle})
+	if !errors.Is(err, ErrPuzzle) {
+		t.Errorf("Expected ErrPuzzle, got %v", err)
+	}
+}
+
+func TestGetProviders_DropsProvidersFailingPuzzle(t *testing.T) {
+	config := Config{RequireSignatures: true, Puzzle: Puzzle{Static: 4, Dynamic: 4}}
+	nodes := []*Kademlia{newIdentityTestNode(t, config), newIdentityTestNode(t, config), newIdentityTestNode(t, config)}
+	dataID := NewRandomKademliaID()
+	if !nodes[1].Network.SendAddProviderMessage(&nodes[1].RoutingTable.Me, &nodes[0].RoutingTable.Me, dataID) {
+		t.Fatal("Expected ADD_PROVIDER to be acknowledged")
+	}
+	nodes[0].ActionChannel <- Action{Action: "AddProvider", Hash: dataID.String(), SenderId: NewRandomKademliaID(), SenderIp: "127.0.0.1:1"}
+
+	// the provider is added after ADD_PROVIDER has been acknowledged
+	var providers []Contact
+	for deadline := time.Now().Add(time.Second); len(providers) == 0 && time.Now().Before(deadline); {
+		var err error
+		providers, _, err = nodes[2].Network.SendGetProvidersMessage(&nodes[2].RoutingTable.Me, &nodes[0].RoutingTable.Me, dataID.String())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	if len(providers) != 1 || !providers[0].ID.Equals(nodes[1].RoutingTable.Me.ID) {
+		t.Errorf("Expected only the provider with a proof, got %v", providers)
+	}
+}
@@ -0,0 +1,69 @@
+package kademlia
+
+import (
+	"crypto/ed25519"
+	"fmt"
+)
+
+// MutableRecord is a signed value addressed by a public key and a salt,
+// similar to BitTorrent BEP44. A newer version of the record replaces an
+// older one by carrying a higher sequence number.
+type MutableRecord struct {
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	Salt      []byte            `json:"salt,omitempty"`
+	Seq       int64             `json:"seq"`
+	Value     []byte            `json:"value"`
+	Signature []byte            `json:"signature"`
+}
+
+// MutableRecordID returns the KademliaID a record published with
+// publicKey and salt is stored under, in a keyspace of length bytes
+func MutableRecordID(publicKey ed25519.PublicKey, salt []byte, length int) *KademliaID {
+	target := append(append([]byte{}, publicKey...), salt...)
+	key, _ := Sum(target, SHA2_256)
+	return key.KademliaID(length)
+}
+
+// NewMutableRecord returns a new record signed with privateKey
+func NewMutableRecord(privateKey ed25519.PrivateKey, salt []byte, seq int64, value []byte) MutableRecord {
+	record := MutableRecord{
+		PublicKey: privateKey.Public().(ed25519.PublicKey),
+		Salt:      salt,
+		Seq:       seq,
+		Value:     value,
+	}
+	record.Signature = ed25519.Sign(privateKey, record.signedBytes())
+	return record
+}
+
+// signedBytes returns the bencoded salt, seq and value that the signature
+// covers, laid out the same way as in BEP44
+func (record *MutableRecord) signedBytes() []byte {
+	var buf []byte
+	if len(record.Salt) > 0 {
+		buf = fmt.Appendf(buf, "4:salt%d:", len(record.Salt))
+		buf = append(buf, record.Salt...)
+	}
+	buf = fmt.Appendf(buf, "3:seqi%de1:v%d:", record.Seq, len(record.Value))
+	return append(buf, record.Value...)
+}
+
+// ID returns the KademliaID the record is stored under in a keyspace of length bytes
+func (record *MutableRecord) ID(length int) *KademliaID {
+	return MutableRecordID(record.PublicKey, record.Salt, length)
+}
+
+// Verify returns an error unless the record is correctly signed and
+// belongs under target
+func (record *MutableRecord) Verify(target *KademliaID) error {
+	if len(record.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key length %d", len(record.PublicKey))
+	}
+	if !ed25519.Verify(record.PublicKey, record.signedBytes(), record.Signature) {
+		return fmt.Errorf("invalid record signature")
+	}
+	if target == nil || !record.ID(target.Len()).Equals(target) {
+		return fmt.Errorf("record does not belong under target")
+	}
+	return nil
+}
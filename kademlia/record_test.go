@@ -0,0 +1,93 @@
+package kademlia
+
+import (
+	"crypto/ed25519"
+	"testing"
+)
+
+func newTestRecord(t *testing.T, seq int64, value string) (ed25519.PrivateKey, MutableRecord) {
+	_, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return privateKey, NewMutableRecord(privateKey, []byte("config"), seq, []byte(value))
+}
+
+func TestMutableRecord_VerifiesSignature(t *testing.T) {
+	_, record := newTestRecord(t, 1, "v1")
+	target := record.ID(IDLength)
+
+	if err := record.Verify(target); err != nil {
+		t.Errorf("Expected record to verify, got %v", err)
+	}
+
+	record.Value = []byte("tampered")
+	if err := record.Verify(target); err == nil {
+		t.Error("Expected tampered record to fail verification")
+	}
+}
+
+func TestMutableRecord_RejectsWrongTarget(t *testing.T) {
+	_, record := newTestRecord(t, 1, "v1")
+
+	if err := record.Verify(NewRandomKademliaID()); err == nil {
+		t.Error("Expected record to be rejected under another target")
+	}
+	if err := record.Verify(nil); err == nil {
+		t.Error("Expected record to be rejected without a target")
+	}
+}
+
+func TestMutableRecord_SignedBytesMatchBEP44(t *testing.T) {
+	record := MutableRecord{Salt: []byte("foobar"), Seq: 1, Value: []byte("Hello World!")}
+
+	expected := "4:salt6:foobar3:seqi1e1:v12:Hello World!"
+	if string(record.signedBytes()) != expected {
+		t.Errorf("Expected %q, got %q", expected, record.signedBytes())
+	}
+}
+
+func TestMutableRecordID_DependsOnSalt(t *testing.T) {
+	privateKey, _ := newTestRecord(t, 1, "v1")
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+
+	first := MutableRecordID(publicKey, []byte("a"), IDLength)
+	second := MutableRecordID(publicKey, []byte("b"), IDLength)
+	if first.Equals(second) {
+		t.Error("Expected different salts to give different IDs")
+	}
+	if MutableRecordID(publicKey, []byte("a"), 32).Len() != 32 {
+		t.Error("Expected ID to follow the keyspace width")
+	}
+}
+
+func TestStoreRecord_OnlyAcceptsHigherSeq(t *testing.T) {
+	privateKey, first := newTestRecord(t, 2, "v2")
+	hash := first.ID(IDLength).String()
+	kademlia := &Kademlia{RoutingTable: NewRoutingTable(NewContact(NewRandomKademliaID(), "172.20.0.1:8000"))}
+
+	if err := kademlia.StoreRecord(hash, first); err != nil {
+		t.Fatalf("Expected first record to be stored, got %v", err)
+	}
+	if err := kademlia.StoreRecord(hash, first); err != nil {
+		t.Errorf("Expected storing the same record again to succeed, got %v", err)
+	}
+
+	older := NewMutableRecord(privateKey, []byte("config"), 1, []byte("v1"))
+	if err := kademlia.StoreRecord(hash, older); err == nil {
+		t.Error("Expected older record to be rejected")
+	}
+	conflicting := NewMutableRecord(privateKey, []byte("config"), 2, []byte("other"))
+	if err := kademlia.StoreRecord(hash, conflicting); err == nil {
+		t.Error("Expected record with the same seq and another value to be rejected")
+	}
+
+	newer := NewMutableRecord(privateKey, []byte("config"), 3, []byte("v3"))
+	if err := kademlia.StoreRecord(hash, newer); err != nil {
+		t.Errorf("Expected newer record to be stored, got %v", err)
+	}
+	stored, _ := kademlia.LookupRecord(hash)
+	if stored == nil || string(stored.Value) != "v3" {
+		t.Errorf("Expected stored value v3, got %v", stored)
+	}
+}
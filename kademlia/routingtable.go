@@ -1,28 +1,51 @@
 package kademlia
 
-import "fmt"
+import (
+	"fmt"
+	"sync"
+)
 
 const bucketSize = k
 
 // RoutingTable definition
-// keeps a refrence contact of me and an array of buckets
+// keeps a refrence contact of me and one bucket per bit of the keyspace,
+// the mutex lets the table be read from outside the action loop
 type RoutingTable struct {
-	Me      Contact
-	buckets [IDLength * 8]*bucket
+	Me         Contact
+	buckets    []*bucket
+	mutex      sync.RWMutex
+	limits     IPLimits
+	rejections []Rejection
 }
 
-// NewRoutingTable returns a new instance of a RoutingTable
+// NewRoutingTable returns a new instance of a RoutingTable, the width
+// of the keyspace is taken from the length of my KademliaID
 func NewRoutingTable(me Contact) *RoutingTable {
 	routingTable := &RoutingTable{}
-	for i := 0; i < IDLength*8; i++ {
+	routingTable.buckets = make([]*bucket, me.ID.Len()*8)
+	for i := 0; i < len(routingTable.buckets); i++ {
 		routingTable.buckets[i] = newBucket()
 	}
 	routingTable.Me = me
 	return routingTable
 }
 
-// AddContact add a new contact to the correct Bucket
+// IDLength returns the number of bytes in the KademliaIDs of this network
+func (routingTable *RoutingTable) IDLength() int {
+	if routingTable == nil || routingTable.Me.ID == nil {
+		return IDLength
+	}
+	return routingTable.Me.ID.Len()
+}
+
+// AddContact add a new contact to the correct Bucket,
+// contacts with an ID of another width are ignored
 func (routingTable *RoutingTable) AddContact(contact Contact) (bool, *Contact) {
+	if contact.ID.Len() != routingTable.IDLength() {
+		return false, nil
+	}
+	routingTable.mutex.Lock()
+	defer routingTable.mutex.Unlock()
 	bucketIndex := routingTable.getBucketIndex(contact.ID)
 	bucket := routingTable.buckets[bucketIndex]
 	isFull, lastContact := bucket.AddContact(contact)
@@ -30,6 +53,8 @@ func (routingTable *RoutingTable) AddContact(contact Contact) (bool, *Contact) {
 }
 // RemoveContact remove contact from bucket
 func (routingTable *RoutingTable) RemoveContact(contact *Contact) {
+	routingTable.mutex.Lock()
+	defer routingTable.mutex.Unlock()
 	bucketIndex := routingTable.getBucketIndex(contact.ID)
 	bucket := routingTable.buckets[bucketIndex]
 	bucket.RemoveContact(contact)
@@ -37,18 +62,20 @@ func (routingTable *RoutingTable) RemoveContact(contact *Contact) {
 
 // FindClosestContacts finds the count closest Contacts to the target in the RoutingTable
 func (routingTable *RoutingTable) FindClosestContacts(target *KademliaID, count int) []Contact {
+	routingTable.mutex.RLock()
+	defer routingTable.mutex.RUnlock()
 	var candidates ContactCandidates
 	bucketIndex := routingTable.getBucketIndex(target)
 	bucket := routingTable.buckets[bucketIndex]
 
 	candidates.Append(bucket.GetContactAndCalcDistance(target))
 
-	for i := 1; (bucketIndex-i >= 0 || bucketIndex+i < IDLength*8) && candidates.Len() < count; i++ {
+	for i := 1; (bucketIndex-i >= 0 || bucketIndex+i < len(routingTable.buckets)) && candidates.Len() < count; i++ {
 		if bucketIndex-i >= 0 {
 			bucket = routingTable.buckets[bucketIndex-i]
 			candidates.Append(bucket.GetContactAndCalcDistance(target))
 		}
-		if bucketIndex+i < IDLength*8 {
+		if bucketIndex+i < len(routingTable.buckets) {
 			bucket = routingTable.buckets[bucketIndex+i]
 			candidates.Append(bucket.GetContactAndCalcDistance(target))
 		}
@@ -63,22 +90,48 @@ func (routingTable *RoutingTable) FindClosestContacts(target *KademliaID, count
 	return candidates.GetContacts(count)
 }
 
+// Contains returns true if a contact with id is in the routing table
+func (routingTable *RoutingTable) Contains(id *KademliaID) bool {
+	routingTable.mutex.RLock()
+	defer routingTable.mutex.RUnlock()
+	bucket := routingTable.buckets[routingTable.getBucketIndex(id)]
+	for _, contact := range bucket.GetContactAndCalcDistance(id) {
+		if contact.ID.Equals(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Buckets returns the contacts of every non-empty bucket by bucket index
+func (routingTable *RoutingTable) Buckets() map[int][]Contact {
+	routingTable.mutex.RLock()
+	defer routingTable.mutex.RUnlock()
+	buckets := make(map[int][]Contact)
+	for i, bucket := range routingTable.buckets {
+		if bucket.Len() > 0 {
+			buckets[i] = bucket.GetContactAndCalcDistance(routingTable.Me.ID)
+		}
+	}
+	return buckets
+}
+
 // getBucketIndex get the correct Bucket index for the KademliaID
 func (routingTable *RoutingTable) getBucketIndex(id *KademliaID) int {
-	distance := id.CalcDistance(routingTable.Me.ID)
-	for i := 0; i < IDLength; i++ {
+	distance := routingTable.Me.ID.CalcDistance(id)
+	for i := 0; i < distance.Len(); i++ {
 		for j := 0; j < 8; j++ {
-			if (distance[i]>>uint8(7-j))&0x1 != 0 {
+			if ((*distance)[i]>>uint8(7-j))&0x1 != 0 {
 				return i*8 + j
 			}
 		}
 	}
 
-	return IDLength*8 - 1
+	return len(routingTable.buckets) - 1
 }
 
 func (routingTable *RoutingTable) PrintIPs() {
-	for i := 0; i < IDLength*8; i++ {
+	for i := 0; i < len(routingTable.buckets); i++ {
 		bucket := routingTable.buckets[i]
 		if bucket.Len() > 0 {
 			//PRINT IP and ID
@@ -91,7 +144,7 @@ func (routingTable *RoutingTable) PrintIPs() {
 func (routingTable *RoutingTable) PrintTables() {
 	fmt.Println("Routing Table:")
 	fmt.Println("Me: ", routingTable.Me)
-	for i := 0; i < IDLength*8; i++ {
+	for i := 0; i < len(routingTable.buckets); i++ {
 		bucket := routingTable.buckets[i]
 		if bucket.Len() > 0 {
 			fmt.Printf("Bucket %d: %v\n", i, bucket)
@@ -226,4 +226,48 @@ func captureOutput(f func()) string {
 	os.Stdout = stdout
 	buf.ReadFrom(r)
 	return buf.String()
-}
\ No newline at end of file
+}
+
+func TestRoutingTable_WidthFollowsMe(t *testing.T) {
+	for _, length := range []int{16, 20, 32} {
+		rt := NewRoutingTable(NewContact(NewRandomKademliaIDWithLength(length), "localhost:8000"))
+		if rt.IDLength() != length {
+			t.Errorf("Expected ID length %d, got %d", length, rt.IDLength())
+		}
+		if len(rt.buckets) != length*8 {
+			t.Errorf("Expected %d buckets, got %d", length*8, len(rt.buckets))
+		}
+
+		contact := NewContact(NewRandomKademliaIDWithLength(length), "localhost:8001")
+		rt.AddContact(contact)
+		closest := rt.FindClosestContacts(contact.ID, 1)
+		if len(closest) != 1 || !closest[0].ID.Equals(contact.ID) {
+			t.Errorf("Expected to find contact in %d-bit table, got %v", length*8, closest)
+		}
+	}
+}
+
+func TestRoutingTable_IgnoresMismatchingWidth(t *testing.T) {
+	rt := NewRoutingTable(NewContact(NewRandomKademliaIDWithLength(32), "localhost:8000"))
+
+	rt.AddContact(NewContact(NewRandomKademliaIDWithLength(20), "localhost:8001"))
+
+	closest := rt.FindClosestContacts(NewRandomKademliaIDWithLength(32), 5)
+	if len(closest) != 0 {
+		t.Errorf("Expected contact with mismatching width to be ignored, got %v", closest)
+	}
+}
+
+func TestRoutingTable_GetBucketIndex256(t *testing.T) {
+	me := NewKademliaID(strings.Repeat("00", 32))
+	rt := NewRoutingTable(NewContact(me, "localhost:8000"))
+
+	last := NewKademliaID(strings.Repeat("00", 31) + "01")
+	if index := rt.getBucketIndex(last); index != 255 {
+		t.Errorf("Expected bucket index 255, got %d", index)
+	}
+	first := NewKademliaID("80" + strings.Repeat("00", 31))
+	if index := rt.getBucketIndex(first); index != 0 {
+		t.Errorf("Expected bucket index 0, got %d", index)
+	}
+}
@@ -0,0 +1,96 @@
+package kademlia
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Reasons a node lookup terminated, as recorded in LookupTrace.Termination
+const (
+	TerminationNoContacts = "no contacts in routing table"
+	TerminationDataFound  = "data found"
+	TerminationAllProbed  = "all known contacts probed"
+	TerminationConverged  = "no closer contacts found"
+)
+
+// LookupTrace records every round of queries made by a node lookup
+type LookupTrace struct {
+	Target      *KademliaID   `json:"target"`
+	Hash        string        `json:"hash,omitempty"`
+	Rounds      []*TraceRound `json:"rounds"`
+	Termination string        `json:"termination"`
+	FoundOn     *Contact      `json:"found_on,omitempty"`
+	Duration    time.Duration `json:"duration"`
+}
+
+// TraceRound is one round of parallel FIND_NODE or FIND_DATA queries
+type TraceRound struct {
+	Queried []Contact    `json:"queried"`
+	Queries []TraceQuery `json:"queries"`
+	mutex   sync.Mutex
+}
+
+// TraceQuery is the outcome of querying a single contact
+type TraceQuery struct {
+	Contact   Contact       `json:"contact"`
+	Latency   time.Duration `json:"latency"`
+	Returned  []Contact     `json:"returned,omitempty"`
+	FoundData bool          `json:"found_data,omitempty"`
+	TimedOut  bool          `json:"timed_out,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// TraceLookup runs NodeLookup for target, or for the value under hash if
+// it is set, and returns a trace of every round together with its results
+func (kademlia *Kademlia) TraceLookup(target *Contact, hash string) ([]Contact, Contact, []byte, *LookupTrace) {
+	trace := &LookupTrace{Target: target.ID, Hash: hash}
+	start := time.Now()
+	contacts, dataProvider, data, hops := kademlia.nodeLookup(context.Background(), target, hash, trace)
+	trace.Duration = time.Since(start)
+	lookupHops.Observe(float64(hops))
+	lookupDuration.Observe(trace.Duration.Seconds())
+	if data != nil {
+		trace.FoundOn = &dataProvider
+	}
+	return contacts, dataProvider, data, trace
+}
+
+// newRound starts recording a round that queries contacts, it returns nil
+// when the lookup is not traced
+func (trace *LookupTrace) newRound(contacts []ContactListItem) *TraceRound {
+	if trace == nil {
+		return nil
+	}
+	round := &TraceRound{Queried: GetAllContactsFromContactList(contacts)}
+	trace.Rounds = append(trace.Rounds, round)
+	return round
+}
+
+// terminate records why the lookup stopped
+func (trace *LookupTrace) terminate(reason string) {
+	if trace != nil {
+		trace.Termination = reason
+	}
+}
+
+// record adds the outcome of one query, it is safe to call from the
+// goroutines of a round and does nothing when the lookup is not traced
+func (round *TraceRound) record(contact Contact, start time.Time, returned []Contact, foundData bool, err error) {
+	if round == nil {
+		return
+	}
+	query := TraceQuery{
+		Contact:   contact,
+		Latency:   time.Since(start),
+		Returned:  returned,
+		FoundData: foundData,
+	}
+	if err != nil {
+		query.Error = err.Error()
+		query.TimedOut = isTimeout(err)
+	}
+	round.mutex.Lock()
+	defer round.mutex.Unlock()
+	round.Queries = append(round.Queries, query)
+}
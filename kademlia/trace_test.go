@@ -0,0 +1,83 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTraceLookup_RecordsRoundsUntilDataFound(t *testing.T) {
+	nodes := newTestNetwork(t, 3)
+	data := []byte("traced value")
+	key, _ := Sum(data, SHA2_256)
+	dataID := key.KademliaID(IDLength)
+	if !nodes[0].Network.SendStoreMessage(&nodes[0].RoutingTable.Me, &nodes[2].RoutingTable.Me, dataID, data, nil) {
+		t.Fatal("Expected value to be stored")
+	}
+
+	target := NewContact(dataID, "")
+	_, foundOn, foundData, trace := nodes[1].TraceLookup(&target, key.String())
+
+	if string(foundData) != string(data) {
+		t.Fatalf("Expected to find the value, got '%s'", foundData)
+	}
+	if trace.Termination != TerminationDataFound || trace.FoundOn == nil || !trace.FoundOn.ID.Equals(foundOn.ID) {
+		t.Errorf("Expected the trace to end with the data found on %s, got %s", foundOn.Address, trace.Termination)
+	}
+	if len(trace.Rounds) == 0 || len(trace.Rounds[0].Queries) != len(trace.Rounds[0].Queried) {
+		t.Fatalf("Expected every queried contact to be recorded, got %+v", trace.Rounds)
+	}
+	if _, err := json.Marshal(trace); err != nil {
+		t.Errorf("Expected trace to marshal to JSON, got %v", err)
+	}
+}
+
+func TestTraceLookup_DataNotMatchingKeyIsNotFound(t *testing.T) {
+	nodes := newTestNetwork(t, 3)
+	key, _ := Sum([]byte("traced value"), SHA2_256)
+	dataID := key.KademliaID(IDLength)
+	if !nodes[0].Network.SendStoreMessage(&nodes[0].RoutingTable.Me, &nodes[2].RoutingTable.Me, dataID, []byte("forged value"), nil) {
+		t.Fatal("Expected value to be stored")
+	}
+
+	target := NewContact(dataID, "")
+	_, _, foundData, trace := nodes[1].TraceLookup(&target, key.String())
+
+	if foundData != nil || trace.Termination == TerminationDataFound {
+		t.Fatalf("Expected the forged value not to be found, got '%s' and %s", foundData, trace.Termination)
+	}
+	for _, round := range trace.Rounds {
+		for _, query := range round.Queries {
+			if query.FoundData {
+				t.Errorf("Expected no query to record found data, got %+v", query)
+			}
+		}
+	}
+}
+
+func TestTraceLookup_RecordsFailedQueries(t *testing.T) {
+	nodes := newTestNetwork(t, 2)
+	nodes[1].Network.Close()
+
+	_, _, _, trace := nodes[0].TraceLookup(&nodes[1].RoutingTable.Me, "")
+
+	if len(trace.Rounds) != 1 || len(trace.Rounds[0].Queries) != 1 {
+		t.Fatalf("Expected one round with one query, got %+v", trace.Rounds)
+	}
+	query := trace.Rounds[0].Queries[0]
+	if query.Error == "" || !query.Contact.ID.Equals(nodes[1].RoutingTable.Me.ID) {
+		t.Errorf("Expected the query to the closed node to fail, got %+v", query)
+	}
+	if trace.Termination != TerminationConverged {
+		t.Errorf("Expected termination '%s', got '%s'", TerminationConverged, trace.Termination)
+	}
+}
+
+func TestTraceLookup_NoContacts(t *testing.T) {
+	node := newTestNode(t)
+
+	_, _, _, trace := node.TraceLookup(&node.RoutingTable.Me, "")
+
+	if trace.Termination != TerminationNoContacts || len(trace.Rounds) != 0 {
+		t.Errorf("Expected no rounds and termination '%s', got %d rounds and '%s'", TerminationNoContacts, len(trace.Rounds), trace.Termination)
+	}
+}
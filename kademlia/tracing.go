@@ -0,0 +1,77 @@
+package kademlia
+
+import (
+	"context"
+	"net"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans of this package
+const tracerName = "d7024e/kademlia"
+
+// propagator carries trace context between nodes in Message.TraceContext
+var propagator = propagation.TraceContext{}
+
+// tracer returns the tracer of the node
+func (kademlia *Kademlia) tracer() trace.Tracer {
+	if kademlia.Network == nil {
+		return otel.GetTracerProvider().Tracer(tracerName)
+	}
+	return kademlia.Network.tracer()
+}
+
+// tracer returns the tracer of the node the network belongs to
+func (network *Network) tracer() trace.Tracer {
+	if network.tracerProvider == nil {
+		return otel.GetTracerProvider().Tracer(tracerName)
+	}
+	return network.tracerProvider.Tracer(tracerName)
+}
+
+// startHandlerSpan starts the span of an inbound message as a child of the
+// trace context the sender put in the message
+func (network *Network) startHandlerSpan(msg Message, addr net.Addr) (context.Context, trace.Span) {
+	ctx := propagator.Extract(context.Background(), propagation.MapCarrier(msg.TraceContext))
+	msgType := typeLabel(msg.Type)
+	peerID := ""
+	if msg.SenderID != nil {
+		peerID = msg.SenderID.String()
+	}
+	return network.tracer().Start(ctx, "kademlia.handle "+msgType,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("kademlia.message.type", msgType),
+			attribute.String("kademlia.peer.address", addr.String()),
+			attribute.String("kademlia.peer.id", peerID),
+		))
+}
+
+// sendMessage sends msg in a client span and passes the span on to the
+// receiver in msg.TraceContext
+func (network *Network) sendMessage(ctx context.Context, sender *Contact, receiver *Contact, msg Message) ([]byte, error) {
+	ctx, span := network.tracer().Start(ctx, "kademlia.send "+msg.Type,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("kademlia.message.type", msg.Type),
+			attribute.String("kademlia.peer.address", receiver.Address),
+		))
+	defer span.End()
+
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	if len(carrier) > 0 {
+		msg.TraceContext = carrier
+	}
+
+	response, err := network.SendMessage(sender, receiver, msg)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return response, err
+}
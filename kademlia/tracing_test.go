@@ -0,0 +1,130 @@
+package kademlia
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newInMemoryTracerProvider returns a tracer provider that exports every
+// span to the returned in-memory exporter as soon as it ends
+func newInMemoryTracerProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)), exporter
+}
+
+// newTracedTestNetwork starts count connected nodes that export their
+// spans to one in-memory exporter
+func newTracedTestNetwork(t *testing.T, count int) ([]*Kademlia, *tracetest.InMemoryExporter) {
+	provider, exporter := newInMemoryTracerProvider()
+	config := Config{Logger: DiscardLogger(), TracerProvider: provider}
+	nodes := make([]*Kademlia, count)
+	for i := range nodes {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		me := NewContact(NewRandomKademliaID(), conn.LocalAddr().String())
+		nodes[i], err = NewKademliaWithConfig(NewRoutingTable(me), conn, config)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		go nodes[i].ListenActionChannel()
+		go nodes[i].Network.Listen(nodes[i])
+	}
+	for _, node := range nodes {
+		for _, other := range nodes {
+			if node != other {
+				node.RoutingTable.AddContact(other.RoutingTable.Me)
+			}
+		}
+	}
+	return nodes, exporter
+}
+
+// waitForSpans waits until count spans named name have ended, handler
+// spans end after the reply has been sent
+func waitForSpans(t *testing.T, exporter *tracetest.InMemoryExporter, name string, count int) tracetest.SpanStubs {
+	deadline := time.Now().Add(time.Second)
+	for {
+		spans := exporter.GetSpans()
+		if len(findSpans(spans, name)) >= count || time.Now().After(deadline) {
+			return spans
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// findSpans returns the spans with the given name
+func findSpans(spans tracetest.SpanStubs, name string) tracetest.SpanStubs {
+	var found tracetest.SpanStubs
+	for _, span := range spans {
+		if span.Name == name {
+			found = append(found, span)
+		}
+	}
+	return found
+}
+
+func TestNodeLookup_PropagatesTraceAcrossNodes(t *testing.T) {
+	nodes, exporter := newTracedTestNetwork(t, 3)
+
+	nodes[0].NodeLookup(&nodes[2].RoutingTable.Me, "")
+
+	spans := exporter.GetSpans()
+	spans = waitForSpans(t, exporter, "kademlia.handle FIND_NODE", len(findSpans(spans, "kademlia.send FIND_NODE")))
+	lookups := findSpans(spans, "kademlia.NodeLookup")
+	if len(lookups) != 1 {
+		t.Fatalf("Expected one lookup span, got %d", len(lookups))
+	}
+	traceID := lookups[0].SpanContext.TraceID()
+
+	sends := findSpans(spans, "kademlia.send FIND_NODE")
+	handles := findSpans(spans, "kademlia.handle FIND_NODE")
+	if len(sends) == 0 || len(handles) != len(sends) {
+		t.Fatalf("Expected a handler span for each of the %d FIND_NODE spans, got %d", len(sends), len(handles))
+	}
+	for _, send := range sends {
+		if send.Parent.SpanID() != lookups[0].SpanContext.SpanID() {
+			t.Errorf("Expected FIND_NODE span to be a child of the lookup span")
+		}
+	}
+	for _, handle := range handles {
+		if handle.SpanContext.TraceID() != traceID {
+			t.Errorf("Expected handler span to be in trace %s, got %s", traceID, handle.SpanContext.TraceID())
+		}
+		if !handle.Parent.IsRemote() {
+			t.Errorf("Expected handler span to have a remote parent")
+		}
+	}
+}
+
+func TestSendStoreMessage_EmitsSpans(t *testing.T) {
+	nodes, exporter := newTracedTestNetwork(t, 2)
+
+	nodes[0].Network.SendStoreMessage(&nodes[0].RoutingTable.Me, &nodes[1].RoutingTable.Me, NewRandomKademliaID(), []byte("value"), nil)
+
+	spans := waitForSpans(t, exporter, "kademlia.handle STORE", 1)
+	sends, handles := findSpans(spans, "kademlia.send STORE"), findSpans(spans, "kademlia.handle STORE")
+	if len(sends) != 1 || len(handles) != 1 {
+		t.Fatalf("Expected one send and one handler span for STORE, got %d and %d", len(sends), len(handles))
+	}
+	if handles[0].Parent.SpanID() != sends[0].SpanContext.SpanID() {
+		t.Error("Expected the handler span to be a child of the send span")
+	}
+}
+
+func TestMessage_WithoutTraceContext(t *testing.T) {
+	nodes, exporter := newTracedTestNetwork(t, 2)
+
+	nodes[0].Network.SendPingMessage(&nodes[0].RoutingTable.Me, &nodes[1].RoutingTable.Me)
+
+	handles := findSpans(waitForSpans(t, exporter, "kademlia.handle PING", 1), "kademlia.handle PING")
+	if len(handles) != 1 || handles[0].Parent.IsValid() {
+		t.Errorf("Expected one root span for a PING without trace context, got %v", handles)
+	}
+}
@@ -0,0 +1,564 @@
+package kademlia
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/flynn/noise"
+)
+
+// Packet types of the encrypted transport. Plain messages are JSON objects
+// and start with '{', so both can arrive on the same socket.
+const (
+	// packetHello starts a Noise XX handshake: -> e
+	packetHello byte = 1
+	// packetWelcome answers it: <- e, ee, s, es
+	packetWelcome byte = 2
+	// packetRequest completes it and carries the first request: -> s, se
+	packetRequest byte = 3
+	// packetData is a message of an established session
+	packetData byte = 4
+	// packetReset tells the initiator that the session is unknown
+	packetReset byte = 5
+)
+
+const (
+	// headerSize is the packet type followed by the session ID
+	headerSize = 1 + 8
+	// dataHeaderSize is the header of a packetData followed by its nonce
+	dataHeaderSize = headerSize + 8
+	// proofSize is an Ed25519 public key followed by its signature over
+	// the static Noise key of the node
+	proofSize = ed25519.PublicKeySize + ed25519.SignatureSize
+	// packetBufferSize is the largest packet that is read
+	packetBufferSize = 8192
+	// sessionTTL is how long an idle session is kept
+	sessionTTL = 10 * time.Minute
+	// maxPendingHandshakes bounds the handshakes waiting for their last message
+	maxPendingHandshakes = 1024
+)
+
+// proofPrefix is signed along with the static Noise key, so that the
+// signature cannot be mistaken for the signature of a message
+const proofPrefix = "kademlia noise static key:"
+
+var cipherSuite = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashBLAKE2s)
+
+var (
+	// ErrNotEncrypted is returned for plain messages when encryption is required
+	ErrNotEncrypted = errors.New("message is not encrypted")
+	// ErrRelayed is returned for messages that are not signed by the
+	// identity that opened the session they came through
+	ErrRelayed = errors.New("message is not signed by the session peer")
+	// errSessionReset is returned when the peer no longer knows a session
+	errSessionReset = errors.New("session reset by peer")
+)
+
+// transport encrypts the messages of a node with an identity. The first
+// RPC to a peer runs a Noise XX handshake in which both sides prove their
+// Ed25519 identity, and later RPCs reuse the session it established, so
+// that they cost one packet each way like plain messages.
+type transport struct {
+	network *Network
+	static  noise.DHKey
+	// proof binds static to the identity of the node
+	proof   []byte
+	require bool
+
+	mutex     sync.Mutex
+	outbound  map[string]*session // by address of the peer
+	inbound   map[uint64]*session // by ID
+	pending   map[uint64]*pendingHandshake
+	replies   map[string]*pendingReply // by address a request came from
+	lastPrune time.Time
+}
+
+// session holds the keys agreed with a peer
+type session struct {
+	id      uint64
+	peer    ed25519.PublicKey
+	send    noise.Cipher
+	receive noise.Cipher
+	// nonce is the last nonce used to send
+	nonce    atomic.Uint64
+	mutex    sync.Mutex
+	window   replayWindow
+	lastUsed time.Time
+}
+
+type pendingHandshake struct {
+	state   *noise.HandshakeState
+	address string
+	started time.Time
+}
+
+type pendingReply struct {
+	session  *session
+	received time.Time
+}
+
+// newTransport returns the transport of a node signing with identity
+func newTransport(network *Network, identity ed25519.PrivateKey, require bool) (*transport, error) {
+	static, err := cipherSuite.GenerateKeypair(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	publicKey := identity.Public().(ed25519.PublicKey)
+	proof := append([]byte{}, publicKey...)
+	proof = append(proof, ed25519.Sign(identity, append([]byte(proofPrefix), static.Public...))...)
+	return &transport{
+		network:  network,
+		static:   static,
+		proof:    proof,
+		require:  require,
+		outbound: make(map[string]*session),
+		inbound:  make(map[uint64]*session),
+		pending:  make(map[uint64]*pendingHandshake),
+		replies:  make(map[string]*pendingReply),
+	}, nil
+}
+
+// verifyProof returns the identity a peer proved for its static Noise key
+func verifyProof(static []byte, payload []byte) (ed25519.PublicKey, error) {
+	if len(payload) < proofSize {
+		return nil, ErrBadSignature
+	}
+	publicKey := ed25519.PublicKey(payload[:ed25519.PublicKeySize])
+	if !ed25519.Verify(publicKey, append([]byte(proofPrefix), static...), payload[ed25519.PublicKeySize:proofSize]) {
+		return nil, ErrBadSignature
+	}
+	return publicKey, nil
+}
+
+// exchange sends request to receiver over connection and returns the reply,
+// through the cached session with receiver or a new one
+func (t *transport) exchange(connection *net.UDPConn, receiver *Contact, request []byte) ([]byte, error) {
+	session := t.outboundSession(receiver.Address)
+	if session == nil {
+		return t.handshake(connection, receiver, request)
+	}
+	response, err := t.roundTrip(connection, session, request)
+	if errors.Is(err, errSessionReset) {
+		t.network.log().Debug("Session reset, handshaking again", "peer", receiver.Address)
+		t.forgetOutbound(receiver.Address, session)
+		return t.handshake(connection, receiver, request)
+	}
+	return response, err
+}
+
+// handshake establishes a session with receiver and sends request with its
+// last message. The identity of receiver must match its ID if it is known.
+func (t *transport) handshake(connection *net.UDPConn, receiver *Contact, request []byte) ([]byte, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	state, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite:   cipherSuite,
+		Pattern:       noise.HandshakeXX,
+		Initiator:     true,
+		StaticKeypair: t.static,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hello, _, _, err := state.WriteMessage(header(packetHello, id), nil)
+	if err != nil {
+		return nil, err
+	}
+	welcome, err := writeAndRead(connection, hello)
+	if err != nil {
+		return nil, err
+	}
+	if len(welcome) < headerSize || welcome[0] != packetWelcome || sessionID(welcome) != id {
+		return nil, fmt.Errorf("unexpected reply to handshake")
+	}
+	payload, _, _, err := state.ReadMessage(nil, welcome[headerSize:])
+	if err != nil {
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+	peer, err := verifyProof(state.PeerStatic(), payload)
+	if err != nil {
+		return nil, err
+	}
+	if receiver.ID != nil && !NodeID(peer, receiver.ID.Len()).Equals(receiver.ID) {
+		return nil, ErrIDMismatch
+	}
+
+	packet, send, receive, err := state.WriteMessage(header(packetRequest, id), append(append([]byte{}, t.proof...), request...))
+	if err != nil {
+		return nil, err
+	}
+	session := newSession(id, peer, send.Cipher(), receive.Cipher())
+	t.mutex.Lock()
+	t.outbound[receiver.Address] = session
+	t.mutex.Unlock()
+
+	response, err := writeAndRead(connection, packet)
+	if err != nil {
+		return nil, err
+	}
+	return session.open(response)
+}
+
+// roundTrip sends request through session and opens the reply
+func (t *transport) roundTrip(connection *net.UDPConn, session *session, request []byte) ([]byte, error) {
+	response, err := writeAndRead(connection, session.seal(request))
+	if err != nil {
+		return nil, err
+	}
+	if len(response) == headerSize && response[0] == packetReset && sessionID(response) == session.id {
+		return nil, errSessionReset
+	}
+	return session.open(response)
+}
+
+// writeAndRead writes packet to connection and waits for the reply
+func writeAndRead(connection *net.UDPConn, packet []byte) ([]byte, error) {
+	if _, err := connection.Write(packet); err != nil {
+		return nil, fmt.Errorf("send message error: %v", err)
+	}
+	connection.SetReadDeadline(time.Now().Add(responseTimeout))
+	buffer := make([]byte, packetBufferSize)
+	byteAmount, _, err := connection.ReadFromUDP(buffer)
+	if err != nil {
+		return nil, fmt.Errorf("receiving response error: %w", err)
+	}
+	return buffer[:byteAmount], nil
+}
+
+// receive handles a packet that arrived at the socket of the node. It
+// returns the message it carries, or nil if it was part of a handshake
+// or was dropped.
+func (t *transport) receive(conn net.PacketConn, packet []byte, addr net.Addr) []byte {
+	if len(packet) == 0 {
+		return nil
+	}
+	switch packet[0] {
+	case '{':
+		if t.require {
+			t.drop("Dropping plain message", addr, ErrNotEncrypted)
+			return nil
+		}
+		t.mutex.Lock()
+		delete(t.replies, addr.String())
+		t.mutex.Unlock()
+		return packet
+	case packetHello:
+		if !t.network.admit(addr, "HANDSHAKE") {
+			return nil
+		}
+		if err := t.welcome(conn, packet, addr); err != nil {
+			t.drop("Dropping handshake", addr, err)
+		}
+		return nil
+	case packetRequest:
+		message, err := t.accept(packet, addr)
+		if err != nil {
+			t.drop("Dropping handshake", addr, err)
+			return nil
+		}
+		return message
+	case packetData:
+		if len(packet) < dataHeaderSize {
+			t.drop("Dropping encrypted message", addr, errors.New("packet too short"))
+			return nil
+		}
+		id := sessionID(packet)
+		t.mutex.Lock()
+		session := t.inbound[id]
+		t.mutex.Unlock()
+		if session == nil {
+			conn.WriteTo(header(packetReset, id), addr)
+			return nil
+		}
+		message, err := session.open(packet)
+		if err == nil && !session.sentBy(message) {
+			err = ErrRelayed
+		}
+		if err != nil {
+			t.drop("Dropping encrypted message", addr, err)
+			return nil
+		}
+		t.replyThrough(session, addr)
+		return message
+	default:
+		t.drop("Dropping packet of unknown type", addr, fmt.Errorf("type %d", packet[0]))
+		return nil
+	}
+}
+
+// welcome answers the first message of a handshake
+func (t *transport) welcome(conn net.PacketConn, packet []byte, addr net.Addr) error {
+	if len(packet) < headerSize {
+		return errors.New("packet too short")
+	}
+	id := sessionID(packet)
+	state, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite:   cipherSuite,
+		Pattern:       noise.HandshakeXX,
+		StaticKeypair: t.static,
+	})
+	if err != nil {
+		return err
+	}
+	if _, _, _, err := state.ReadMessage(nil, packet[headerSize:]); err != nil {
+		return err
+	}
+	welcome, _, _, err := state.WriteMessage(header(packetWelcome, id), t.proof)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	t.prune()
+	if len(t.pending) >= maxPendingHandshakes {
+		t.mutex.Unlock()
+		return errors.New("too many pending handshakes")
+	}
+	t.pending[id] = &pendingHandshake{state: state, address: addr.String(), started: time.Now()}
+	t.mutex.Unlock()
+
+	_, err = conn.WriteTo(welcome, addr)
+	return err
+}
+
+// accept completes a handshake and returns the request sent with its last
+// message
+func (t *transport) accept(packet []byte, addr net.Addr) ([]byte, error) {
+	if len(packet) < headerSize {
+		return nil, errors.New("packet too short")
+	}
+	id := sessionID(packet)
+	t.mutex.Lock()
+	pending := t.pending[id]
+	delete(t.pending, id)
+	t.mutex.Unlock()
+	if pending == nil || pending.address != addr.String() {
+		return nil, errors.New("no handshake in progress")
+	}
+
+	payload, receive, send, err := pending.state.ReadMessage(nil, packet[headerSize:])
+	if err != nil {
+		return nil, err
+	}
+	peer, err := verifyProof(pending.state.PeerStatic(), payload)
+	if err != nil {
+		return nil, err
+	}
+
+	session := newSession(id, peer, send.Cipher(), receive.Cipher())
+	message := payload[proofSize:]
+	if !session.sentBy(message) {
+		return nil, ErrRelayed
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.inbound[id] != nil {
+		return nil, errors.New("session already exists")
+	}
+	t.inbound[id] = session
+	t.replies[addr.String()] = &pendingReply{session: session, received: time.Now()}
+	return message, nil
+}
+
+// replyThrough makes the reply to the request that came from addr go
+// through session
+func (t *transport) replyThrough(session *session, addr net.Addr) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.prune()
+	session.lastUsed = time.Now()
+	t.replies[addr.String()] = &pendingReply{session: session, received: time.Now()}
+}
+
+// replySession returns the session the reply to addr goes through, nil to
+// send it plain
+func (t *transport) replySession(addr net.Addr) *session {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	reply := t.replies[addr.String()]
+	delete(t.replies, addr.String())
+	if reply == nil {
+		return nil
+	}
+	return reply.session
+}
+
+// outboundSession returns the cached session with the peer at address
+func (t *transport) outboundSession(address string) *session {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	session := t.outbound[address]
+	if session == nil || time.Since(session.lastUsed) > sessionTTL {
+		delete(t.outbound, address)
+		return nil
+	}
+	session.lastUsed = time.Now()
+	return session
+}
+
+// forgetOutbound drops session with the peer at address unless another
+// one has replaced it
+func (t *transport) forgetOutbound(address string, session *session) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.outbound[address] == session {
+		delete(t.outbound, address)
+	}
+}
+
+// prune drops idle sessions and handshakes and replies that were never
+// completed, at most once every responseTimeout. The mutex must be held.
+func (t *transport) prune() {
+	now := time.Now()
+	if now.Sub(t.lastPrune) < responseTimeout {
+		return
+	}
+	t.lastPrune = now
+	for id, session := range t.inbound {
+		if now.Sub(session.lastUsed) > sessionTTL {
+			delete(t.inbound, id)
+		}
+	}
+	for id, pending := range t.pending {
+		if now.Sub(pending.started) > responseTimeout {
+			delete(t.pending, id)
+		}
+	}
+	for address, reply := range t.replies {
+		if now.Sub(reply.received) > responseTimeout {
+			delete(t.replies, address)
+		}
+	}
+}
+
+func (t *transport) drop(reason string, addr net.Addr, err error) {
+	rpcErrors.WithLabelValues("INVALID").Inc()
+	t.network.log().Warn(reason, "peer", addr.String(), "error", err)
+}
+
+func newSession(id uint64, peer ed25519.PublicKey, send noise.Cipher, receive noise.Cipher) *session {
+	return &session{id: id, peer: peer, send: send, receive: receive, lastUsed: time.Now()}
+}
+
+// sentBy returns true if message is signed with the identity of the peer
+// of the session, so that a peer cannot relay the signed messages of other
+// nodes through its own session
+func (session *session) sentBy(message []byte) bool {
+	var sender struct{ PublicKey ed25519.PublicKey }
+	return json.Unmarshal(message, &sender) == nil && session.peer.Equal(sender.PublicKey)
+}
+
+// seal encrypts message into a packetData. The nonce is sent along with
+// it, so that packets can arrive out of order.
+func (session *session) seal(message []byte) []byte {
+	packet := header(packetData, session.id)
+	packet = binary.BigEndian.AppendUint64(packet, session.nonce.Add(1))
+	return session.send.Encrypt(packet, binary.BigEndian.Uint64(packet[headerSize:]), packet, message)
+}
+
+// open decrypts a packetData of the session, each nonce is accepted once
+func (session *session) open(packet []byte) ([]byte, error) {
+	if len(packet) < dataHeaderSize || packet[0] != packetData || sessionID(packet) != session.id {
+		return nil, errors.New("unexpected packet for session")
+	}
+	nonce := binary.BigEndian.Uint64(packet[headerSize:])
+	message, err := session.receive.Decrypt(nil, nonce, packet[:dataHeaderSize], packet[dataHeaderSize:])
+	if err != nil {
+		return nil, fmt.Errorf("decrypting message: %w", err)
+	}
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if !session.window.accept(nonce) {
+		return nil, errors.New("replayed message")
+	}
+	return message, nil
+}
+
+// replayWindow remembers the nonces received within 64 of the highest one
+type replayWindow struct {
+	highest uint64
+	seen    uint64
+}
+
+// accept returns true the first time nonce is seen. Nonces start at 1 and
+// nonces too far behind the highest one are refused.
+func (window *replayWindow) accept(nonce uint64) bool {
+	if nonce == 0 {
+		return false
+	}
+	if nonce > window.highest {
+		shift := nonce - window.highest
+		if shift >= 64 {
+			window.seen = 0
+		} else {
+			window.seen <<= shift
+		}
+		window.seen |= 1
+		window.highest = nonce
+		return true
+	}
+	behind := window.highest - nonce
+	if behind >= 64 || window.seen&(1<<behind) != 0 {
+		return false
+	}
+	window.seen |= 1 << behind
+	return true
+}
+
+func header(packetType byte, id uint64) []byte {
+	return binary.BigEndian.AppendUint64([]byte{packetType}, id)
+}
+
+func sessionID(packet []byte) uint64 {
+	return binary.BigEndian.Uint64(packet[1:headerSize])
+}
+
+func newSessionID() (uint64, error) {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(id[:]), nil
+}
+
+// secureConn is the socket of a node with a transport. It decrypts the
+// requests it reads and encrypts the replies to them.
+type secureConn struct {
+	net.PacketConn
+	transport *transport
+}
+
+func (conn *secureConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	buffer := make([]byte, packetBufferSize)
+	for {
+		byteAmount, addr, err := conn.PacketConn.ReadFrom(buffer)
+		if err != nil {
+			return 0, addr, err
+		}
+		if message := conn.transport.receive(conn.PacketConn, buffer[:byteAmount], addr); message != nil {
+			return copy(p, message), addr, nil
+		}
+	}
+}
+
+func (conn *secureConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	session := conn.transport.replySession(addr)
+	if session == nil {
+		return conn.PacketConn.WriteTo(p, addr)
+	}
+	if _, err := conn.PacketConn.WriteTo(session.seal(p), addr); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
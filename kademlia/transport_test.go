@@ -0,0 +1,157 @@
+package kademlia
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReplayWindow(t *testing.T) {
+	var window replayWindow
+	for _, test := range []struct {
+		nonce    uint64
+		expected bool
+	}{
+		{0, false},
+		{1, true},
+		{1, false},
+		{3, true},
+		{2, true},
+		{2, false},
+		{100, true},
+		{36, false},
+		{37, true},
+		{37, false},
+		{99, true},
+	} {
+		if window.accept(test.nonce) != test.expected {
+			t.Errorf("Expected accept(%d) to be %v", test.nonce, test.expected)
+		}
+	}
+}
+
+func TestTransport_EncryptsMessages(t *testing.T) {
+	node := newIdentityTestNode(t, Config{})
+	peer, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer peer.Close()
+
+	go node.Network.SendPingMessage(&node.RoutingTable.Me, &Contact{Address: peer.LocalAddr().String()})
+	buffer := make([]byte, packetBufferSize)
+	peer.SetReadDeadline(time.Now().Add(responseTimeout))
+	byteAmount, _, err := peer.ReadFrom(buffer)
+	if err != nil {
+		t.Fatalf("Expected a packet, got %v", err)
+	}
+	if buffer[0] != packetHello || bytes.Contains(buffer[:byteAmount], []byte("PING")) {
+		t.Errorf("Expected a handshake instead of a plain PING, got %q", buffer[:byteAmount])
+	}
+}
+
+func TestTransport_CachesSessions(t *testing.T) {
+	node := newIdentityTestNode(t, Config{RequireEncryption: true})
+	peer := newIdentityTestNode(t, Config{RequireEncryption: true})
+
+	for i := 0; i < 3; i++ {
+		if !node.Network.SendPingMessage(&node.RoutingTable.Me, &peer.RoutingTable.Me) {
+			t.Fatalf("Expected PING %d to be answered", i)
+		}
+	}
+	session := node.Network.transport.outboundSession(peer.RoutingTable.Me.Address)
+	if session == nil || session.nonce.Load() != 2 {
+		t.Errorf("Expected one session to carry the later PINGs, got %v", session)
+	}
+	peer.Network.transport.mutex.Lock()
+	inbound := len(peer.Network.transport.inbound)
+	peer.Network.transport.mutex.Unlock()
+	if inbound != 1 {
+		t.Errorf("Expected the peer to know 1 session, got %d", inbound)
+	}
+}
+
+func TestTransport_HandshakesAgainAfterReset(t *testing.T) {
+	node := newIdentityTestNode(t, Config{RequireEncryption: true})
+	peer := newIdentityTestNode(t, Config{RequireEncryption: true})
+	node.Network.SendPingMessage(&node.RoutingTable.Me, &peer.RoutingTable.Me)
+
+	// the peer restarted and lost its sessions
+	peer.Network.transport.mutex.Lock()
+	clear(peer.Network.transport.inbound)
+	peer.Network.transport.mutex.Unlock()
+
+	if !node.Network.SendPingMessage(&node.RoutingTable.Me, &peer.RoutingTable.Me) {
+		t.Error("Expected the PING to be answered through a new session")
+	}
+}
+
+func TestTransport_RejectsWrongIdentity(t *testing.T) {
+	node := newIdentityTestNode(t, Config{RequireEncryption: true})
+	peer := newIdentityTestNode(t, Config{RequireEncryption: true})
+
+	impostor := NewContact(NewRandomKademliaID(), peer.RoutingTable.Me.Address)
+	if node.Network.SendPingMessage(&node.RoutingTable.Me, &impostor) {
+		t.Error("Expected the PING to fail for a peer whose key does not match its ID")
+	}
+}
+
+func TestTransport_RejectsRelayedMessages(t *testing.T) {
+	node := newIdentityTestNode(t, Config{RequireEncryption: true})
+	peer := newIdentityTestNode(t, Config{RequireEncryption: true})
+	victim := newIdentityTestNode(t, Config{RequireEncryption: true})
+	relayed, _ := json.Marshal(victim.Network.sign(Message{Type: "PING", SenderID: victim.RoutingTable.Me.ID, SenderIP: victim.RoutingTable.Me.Address}, peer.RoutingTable.Me.ID))
+
+	if _, err := node.Network.SendMessage(&node.RoutingTable.Me, &peer.RoutingTable.Me, json.RawMessage(relayed)); err == nil {
+		t.Error("Expected a relayed message that opens a session to be dropped")
+	}
+	if !node.Network.SendPingMessage(&node.RoutingTable.Me, &peer.RoutingTable.Me) {
+		t.Fatal("Expected the PING of the node itself to be answered")
+	}
+	if _, err := node.Network.SendMessage(&node.RoutingTable.Me, &peer.RoutingTable.Me, json.RawMessage(relayed)); err == nil {
+		t.Error("Expected a relayed message in an open session to be dropped")
+	}
+}
+
+func TestTransport_RequireEncryption(t *testing.T) {
+	node := newIdentityTestNode(t, Config{RequireEncryption: true})
+	plain := newTestNode(t)
+
+	if plain.Network.SendPingMessage(&plain.RoutingTable.Me, &node.RoutingTable.Me) {
+		t.Error("Expected the plain PING to be dropped")
+	}
+}
+
+func TestNewKademliaWithConfig_RequireEncryptionNeedsIdentity(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer conn.Close()
+	me := NewContact(NewRandomKademliaID(), conn.LocalAddr().String())
+
+	if _, err := NewKademliaWithConfig(NewRoutingTable(me), conn, Config{Logger: DiscardLogger(), RequireEncryption: true}); !errors.Is(err, ErrNoIdentity) {
+		t.Errorf("Expected ErrNoIdentity, got %v", err)
+	}
+}
+
+func TestTransport_LargeValues(t *testing.T) {
+	node := newIdentityTestNode(t, Config{RequireEncryption: true, DisableCompression: true})
+	peer := newIdentityTestNode(t, Config{RequireEncryption: true, DisableCompression: true})
+
+	data := make([]byte, MaxValueSize)
+	rand.Read(data)
+	key, _ := Sum(data, SHA2_256)
+	dataID := key.KademliaID(IDLength)
+	if !node.Network.SendStoreMessage(&node.RoutingTable.Me, &peer.RoutingTable.Me, dataID, data, nil) {
+		t.Fatal("Expected the STORE to be acknowledged")
+	}
+	_, found, err := node.Network.SendFindDataMessage(&node.RoutingTable.Me, &peer.RoutingTable.Me, dataID.String())
+	if err != nil || !bytes.Equal(found, data) {
+		t.Errorf("Expected the value back, got %d bytes (%v)", len(found), err)
+	}
+}
@@ -0,0 +1,262 @@
+package kademlia
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MaxValueSize is the largest value, once compressed, that fits in a
+// single STORE datagram after it has been JSON encoded
+const MaxValueSize = 4096
+
+var (
+	// ErrNotFound is returned by GetValue when no node had the value
+	ErrNotFound = errors.New("value not found")
+	// ErrStoreQuorum is returned by PutValue when the value was not stored
+	// on a majority of the closest contacts
+	ErrStoreQuorum = errors.New("value was not stored on a majority of the closest nodes")
+	// ErrValueTooLarge is returned by PutValue for values above MaxValueSize
+	// once compressed
+	ErrValueTooLarge = errors.New("value is larger than the maximum value size")
+)
+
+// PutResult describes where a value ended up after PutValue
+type PutResult struct {
+	Key         Multihash
+	DeleteToken []byte
+	Stored      int
+	Contacts    int
+}
+
+// PutValue hashes data with hashFunc and stores it on the k closest
+// contacts to the key. It fails with ErrStoreQuorum unless a majority of
+// them acknowledged the STORE.
+func (kademlia *Kademlia) PutValue(data []byte, hashFunc HashFunc) (PutResult, error) {
+	token, _ := NewDeleteToken()
+	return kademlia.PutValueWithToken(data, hashFunc, token)
+}
+
+// PutValueWithToken is PutValue with a delete token picked by the caller,
+// so that several values can be deleted with the same token
+func (kademlia *Kademlia) PutValueWithToken(data []byte, hashFunc HashFunc, token []byte) (PutResult, error) {
+	return kademlia.PutValueOnReplicas(data, hashFunc, token, k)
+}
+
+// PutValueOnReplicas is PutValueWithToken that stores data on only the
+// replicas closest contacts to the key, for values whose redundancy comes
+// from elsewhere such as erasure-coded shards
+func (kademlia *Kademlia) PutValueOnReplicas(data []byte, hashFunc HashFunc, token []byte, replicas int) (PutResult, error) {
+	key, err := kademlia.valueKey(data, hashFunc)
+	if err != nil {
+		return PutResult{}, err
+	}
+
+	dataID := key.KademliaID(kademlia.RoutingTable.IDLength())
+	contacts, _, _ := kademlia.lookup(dataID, nil)
+	contacts = contacts[:min(replicas, len(contacts))]
+
+	tokenHash := HashDeleteToken(token)
+	result := PutResult{
+		Key:         key,
+		DeleteToken: token,
+		Stored:      kademlia.StoreOnContacts(dataID, data, tokenHash, contacts),
+		Contacts:    len(contacts),
+	}
+	if result.Stored <= result.Contacts/2 {
+		return result, ErrStoreQuorum
+	}
+	return result, nil
+}
+
+// PutValueNear stores data under its key on replicas of the closest
+// contacts to target rather than to the key, so that related values can be
+// spread over different nodes. Contacts in exclude are only used once the
+// others refused the value. It returns the key and the contacts that
+// stored the value, which GetValueNear finds again.
+func (kademlia *Kademlia) PutValueNear(data []byte, hashFunc HashFunc, token []byte, target *KademliaID, replicas int, exclude map[string]bool) (Multihash, []Contact, error) {
+	key, err := kademlia.valueKey(data, hashFunc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contacts, _, _ := kademlia.lookup(target, nil)
+	var candidates, excluded []Contact
+	for _, contact := range contacts {
+		if exclude[contact.ID.String()] {
+			excluded = append(excluded, contact)
+		} else {
+			candidates = append(candidates, contact)
+		}
+	}
+	dataID := key.KademliaID(kademlia.RoutingTable.IDLength())
+	tokenHash := HashDeleteToken(token)
+	var stored []Contact
+	for _, contact := range append(candidates, excluded...) {
+		if len(stored) == replicas {
+			break
+		}
+		if kademlia.Network.SendStoreMessage(&kademlia.RoutingTable.Me, &contact, dataID, data, tokenHash) {
+			stored = append(stored, contact)
+		}
+	}
+	if len(stored) == 0 {
+		return key, nil, ErrStoreQuorum
+	}
+	return key, stored, nil
+}
+
+// valueKey returns the key of data hashed with hashFunc, or
+// ErrValueTooLarge if data does not fit in a value
+func (kademlia *Kademlia) valueKey(data []byte, hashFunc HashFunc) (Multihash, error) {
+	if len(data) > maxDecompressedSize {
+		return nil, ErrValueTooLarge
+	}
+	if compressed, _ := kademlia.Network.compressFor(data, supportedCompressions); len(compressed) > MaxValueSize {
+		return nil, ErrValueTooLarge
+	}
+	return Sum(data, hashFunc)
+}
+
+// StoreOnContacts sends a STORE to every contact in parallel and returns
+// how many of them acknowledged it
+func (kademlia *Kademlia) StoreOnContacts(dataID *KademliaID, data []byte, tokenHash []byte, contacts []Contact) int {
+	resultChan := make(chan bool, len(contacts))
+	var waitGroup sync.WaitGroup
+
+	for _, contact := range contacts {
+		waitGroup.Add(1)
+		go func(contact Contact) {
+			defer waitGroup.Done()
+			resultChan <- kademlia.Network.SendStoreMessage(&kademlia.RoutingTable.Me, &contact, dataID, data, tokenHash)
+		}(contact)
+	}
+	waitGroup.Wait()
+	close(resultChan)
+
+	successCount := 0
+	for success := range resultChan {
+		if success {
+			successCount++
+		}
+	}
+	return successCount
+}
+
+// GetValue looks up the value stored under key and returns it together
+// with the contact it was found on, which is the node itself if it holds
+// the value. Values that do not match the digest in key are ignored.
+func (kademlia *Kademlia) GetValue(key Multihash) ([]byte, Contact, error) {
+	dataID := key.KademliaID(kademlia.RoutingTable.IDLength())
+	// lookups never query the node itself, which may be the only one that
+	// holds a value stored on few replicas
+	reply := make(chan Response, 1)
+	kademlia.ActionChannel <- Action{Action: "LookupData", Hash: dataID.String(), Reply: reply}
+	if local := <-reply; local.Data != nil {
+		if value, err := decompress(local.Data, local.Compression); err == nil && key.Verify(value) {
+			return value, kademlia.RoutingTable.Me, nil
+		}
+	}
+	_, foundOnContact, foundData := kademlia.lookup(dataID, key)
+	if foundData == nil {
+		return nil, Contact{}, ErrNotFound
+	}
+	return foundData, foundOnContact, nil
+}
+
+// GetValueNear is GetValue for a value stored with PutValueNear. It asks
+// the closest contacts to target for the value, and looks it up under its
+// key if none of them has it, as republishing moves values there.
+func (kademlia *Kademlia) GetValueNear(key Multihash, target *KademliaID) ([]byte, Contact, error) {
+	dataID := key.KademliaID(kademlia.RoutingTable.IDLength())
+	contacts, _, _ := kademlia.lookup(target, nil)
+	for _, contact := range contacts {
+		_, data, err := kademlia.Network.SendFindDataMessage(&kademlia.RoutingTable.Me, &contact, dataID.String())
+		if err == nil && data != nil && key.Verify(data) {
+			return data, contact, nil
+		}
+	}
+	return kademlia.GetValue(key)
+}
+
+// StoredValue is a copy of a value held by this node, decompressed
+type StoredValue struct {
+	Hash string
+	Data []byte
+	// TokenHashes are the hashes of the tokens that delete the value
+	TokenHashes [][]byte
+}
+
+// StoredValues returns a copy of every value held by this node. It goes
+// through the action loop so it is safe to call from any goroutine.
+func (kademlia *Kademlia) StoredValues() []StoredValue {
+	reply := make(chan Response, 1)
+	kademlia.ActionChannel <- Action{Action: "StoredValues", Reply: reply}
+	return (<-reply).Values
+}
+
+func (kademlia *Kademlia) storedValues() []StoredValue {
+	values := make([]StoredValue, 0, len(*kademlia.Data))
+	for hash, data := range *kademlia.Data {
+		value, err := decompress(data, kademlia.compressions[hash])
+		if err != nil {
+			continue
+		}
+		values = append(values, StoredValue{Hash: hash, Data: value, TokenHashes: slices.Clone(kademlia.deleteTokens[hash])})
+	}
+	return values
+}
+
+// Republish stores every value held by this node on the k closest
+// contacts to its key again, once for every token that deletes it. It
+// returns how many values were republished and how many of them reached a
+// majority of their closest contacts.
+func (kademlia *Kademlia) Republish() (int, int) {
+	values := kademlia.StoredValues()
+	successCount := 0
+	for _, value := range values {
+		dataID := NewKademliaID(value.Hash)
+		contacts, _, _ := kademlia.lookup(dataID, nil)
+		var tokenHash []byte
+		if len(value.TokenHashes) > 0 {
+			tokenHash = value.TokenHashes[0]
+		}
+		if stored := kademlia.StoreOnContacts(dataID, value.Data, tokenHash, contacts); stored > len(contacts)/2 {
+			successCount++
+		}
+		for _, tokenHash := range value.TokenHashes[min(1, len(value.TokenHashes)):] {
+			kademlia.StoreOnContacts(dataID, value.Data, tokenHash, contacts)
+		}
+	}
+	return len(values), successCount
+}
+
+// expiryInterval is how often values are checked against their TTL
+const expiryInterval = time.Minute
+
+// expireValuesPeriodically asks the action loop to drop expired values
+func (kademlia *Kademlia) expireValuesPeriodically() {
+	ticker := time.NewTicker(min(expiryInterval, kademlia.valueTTL))
+	defer ticker.Stop()
+	for range ticker.C {
+		kademlia.ActionChannel <- Action{Action: "ExpireValues"}
+	}
+}
+
+// expireValues drops every value whose TTL has passed at now
+func (kademlia *Kademlia) expireValues(now time.Time) {
+	for hash, expires := range kademlia.expires {
+		if now.Before(expires) {
+			continue
+		}
+		size := len((*kademlia.Data)[hash])
+		kademlia.storedKeys.Add(-1)
+		kademlia.storedBytes.Add(-int64(size))
+		delete(*kademlia.Data, hash)
+		delete(kademlia.deleteTokens, hash)
+		delete(kademlia.expires, hash)
+		delete(kademlia.compressions, hash)
+		kademlia.events.publish(Event{Type: ValueExpired, Hash: hash, Size: size})
+	}
+}
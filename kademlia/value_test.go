@@ -0,0 +1,90 @@
+package kademlia
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestPutValue_ThenGetValue(t *testing.T) {
+	nodes := newTestNetwork(t, 3)
+
+	result, err := nodes[0].PutValue([]byte("data1"), BLAKE3)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Stored != result.Contacts || result.Key.HashFunc() != BLAKE3 {
+		t.Errorf("Expected blake3 key stored on every contact, got %+v", result)
+	}
+
+	data, _, err := nodes[2].GetValue(result.Key)
+	if err != nil || string(data) != "data1" {
+		t.Errorf("Expected data1, got %s (%v)", data, err)
+	}
+}
+
+func TestPutValueOnReplicas_StoresOnOneNode(t *testing.T) {
+	nodes := newTestNetwork(t, 4)
+
+	result, err := nodes[0].PutValueOnReplicas([]byte("data1"), SHA1, nil, 1)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Stored != 1 || result.Contacts != 1 {
+		t.Errorf("Expected the value on 1 contact, got %+v", result)
+	}
+	for _, node := range nodes {
+		data, _, err := node.GetValue(result.Key)
+		if err != nil || string(data) != "data1" {
+			t.Errorf("Expected data1, got %s (%v)", data, err)
+		}
+	}
+}
+
+func TestPutValueNear_SkipsExcludedContacts(t *testing.T) {
+	nodes := newTestNetwork(t, 4)
+	target := NewRandomKademliaID()
+	closest := nodes[0].RoutingTable.FindClosestContacts(target, 1)[0]
+
+	key, stored, err := nodes[0].PutValueNear([]byte("data1"), SHA1, nil, target, 1, map[string]bool{closest.ID.String(): true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(stored) != 1 || stored[0].ID.Equals(closest.ID) {
+		t.Errorf("Expected the value on 1 contact other than the excluded one, got %v", stored)
+	}
+	data, foundOn, err := nodes[1].GetValueNear(key, target)
+	if err != nil || string(data) != "data1" {
+		t.Errorf("Expected data1, got %s (%v)", data, err)
+	}
+	if !foundOn.ID.Equals(stored[0].ID) && !foundOn.ID.Equals(nodes[1].RoutingTable.Me.ID) {
+		t.Errorf("Expected data1 from %s, got it from %s", stored[0].ID, foundOn.ID)
+	}
+}
+
+func TestGetValue_NotFound(t *testing.T) {
+	nodes := newTestNetwork(t, 2)
+	key, _ := Sum([]byte("missing"), SHA1)
+
+	if _, _, err := nodes[0].GetValue(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestPutValue_NoContacts(t *testing.T) {
+	node := newTestNode(t)
+
+	if _, err := node.PutValue([]byte("data1"), SHA1); !errors.Is(err, ErrStoreQuorum) {
+		t.Errorf("Expected ErrStoreQuorum, got %v", err)
+	}
+}
+
+func TestPutValue_TooLarge(t *testing.T) {
+	node := newTestNode(t)
+
+	data := make([]byte, MaxValueSize+1)
+	rand.Read(data)
+	if _, err := node.PutValue(data, SHA1); !errors.Is(err, ErrValueTooLarge) {
+		t.Errorf("Expected ErrValueTooLarge, got %v", err)
+	}
+}
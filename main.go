@@ -1,24 +1,72 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"d7024e/api"
 	"d7024e/cli"
+	"d7024e/control"
 	"d7024e/kademlia"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"os"
+	"strings"
 	"time"
 )
 
+var idBits = flag.Int("id-bits", kademlia.IDLength*8, "width of the keyspace in bits, must be the same on every node")
+var httpAddr = flag.String("http", ":8080", "address of the HTTP API, empty to disable")
+var controlAddr = flag.String("control", "unix:/tmp/kademlia.sock", "network:address of the control API, empty to disable")
+var valueTTL = flag.Duration("value-ttl", 0, "how long stored values are kept unless stored again, 0 keeps them")
+var readyContacts = flag.Int("ready-contacts", 1, "live contacts a node needs before /readyz reports it ready")
+var logLevel = flag.String("log-level", "info", "lowest level that is logged: debug, info, warn or error")
+var logFormat = flag.String("log-format", "text", "format of the log output: text or json")
+var compression = flag.Bool("compression", true, "negotiate zstd or gzip compression of values with peers")
+var identityPath = flag.String("identity", "node.key", "file holding the Ed25519 key of the node, created if missing")
+var recordKeyPath = flag.String("record-key", "record.key", "file holding the Ed25519 key that MPUT signs records with, created if missing")
+var requireSignatures = flag.Bool("require-signatures", true, "reject messages from peers that are not signed")
+var puzzleStatic = flag.Int("puzzle-static", 8, "leading zero bits the double hash of the node key must have, must be the same on every node")
+var puzzleDynamic = flag.Int("puzzle-dynamic", 8, "leading zero bits the hash of the node ID XOR its nonce must have, must be the same on every node")
+var disjointPaths = flag.Int("disjoint-paths", 1, "disjoint lookups run for PUT, GET and DELETE, more resist malicious nodes")
+var ipLimitBucket = flag.Int("ip-limit-bucket", 2, "contacts per IP address in a bucket, 0 for no limit")
+var ipLimitTable = flag.Int("ip-limit-table", 10, "contacts per IP address in the routing table, 0 for no limit")
+var subnetLimitBucket = flag.Int("subnet-limit-bucket", 0, "contacts per /24 or /64 subnet in a bucket, 0 for no limit")
+var subnetLimitTable = flag.Int("subnet-limit-table", 0, "contacts per /24 or /64 subnet in the routing table, 0 for no limit")
+var rateLimit = flag.Float64("rate-limit", 100, "messages per second one IP may send, bursts of twice as many pass, 0 for no limit")
+var lookupRateLimit = flag.Float64("lookup-rate-limit", 20, "FIND_NODE and FIND_DATA messages each per second one IP may send, 0 for no limit")
+var pingRateLimit = flag.Float64("ping-rate-limit", 5, "PINGs per second that lookups of peers may make the node send to one IP, 0 for no limit")
+var maxInFlight = flag.Int("max-in-flight", 256, "messages handled at once, more are dropped, 0 for no limit")
+var banAfter = flag.Int("ban-after", 50, "messages of an IP dropped for exceeding a rate before it is banned, 0 never bans")
+var banDuration = flag.Duration("ban-duration", time.Minute, "how long a banned IP is ignored")
+var requireEncryption = flag.Bool("require-encryption", true, "drop messages from peers that are not encrypted")
+var controlToken = flag.String("control-token", "", "token that clients of a tcp control API must present")
+
+// bootstrapAddress is where every node finds the bootstrap node
+const bootstrapAddress = "172.20.0.6:8000"
+
+// bootstrapAttempts is how often a node tries to reach the bootstrap node
+const bootstrapAttempts = 10
+
 func main() {
+	flag.Parse()
 	fmt.Println("Starting the kademlia app...")
+	if *idBits%8 != 0 || kademlia.ValidateIDLength(*idBits/8) != nil {
+		fmt.Println("Invalid -id-bits:", *idBits)
+		return
+	}
+	if err := SetupLogger(); err != nil {
+		fmt.Println(err)
+		return
+	}
 	ipf, err := GetOutboundIP()
 	if err != nil {
 		fmt.Println("Error getting IP: ", err)
 		return
 	}
 	ip := ipf.String()
-	if ip == "172.20.0.6" {
+	if ip+":8000" == bootstrapAddress {
 		StartBootstrapNode(ip)
 	} else {
 		StartNode(ip)
@@ -27,7 +75,7 @@ func main() {
 }
 
 func StartBootstrapNode(ip string) {
-	k, err := JoinNetworkBootstrap(ip, "8000")
+	k, err := JoinNetwork(ip, "8000")
 	if err != nil {
 		fmt.Println("Error joining network: ", err)
 		return
@@ -35,7 +83,13 @@ func StartBootstrapNode(ip string) {
 	go k.ListenActionChannel()
 	time.Sleep(1 * time.Second)
 	go k.Network.Listen(k)
-	c := cli.NewCLI(k)
+	StartAPI(k)
+	StartControl(k)
+	c, err := NewCLI(k)
+	if err != nil {
+		fmt.Println("Error loading record key: ", err)
+		return
+	}
 	go c.CliHandler()
 }
 
@@ -49,39 +103,163 @@ func StartNode(ip string) {
 	go k.ListenActionChannel()
 	go k.Network.Listen(k)
 	time.Sleep(1 * time.Second)
+	JoinBootstrapNode(k)
 	DoLookUpOnSelf(k)
-	c := cli.NewCLI(k)
+	StartAPI(k)
+	StartControl(k)
+	c, err := NewCLI(k)
+	if err != nil {
+		fmt.Println("Error loading record key: ", err)
+		return
+	}
 	if c.CliHandler() {
 		os.Exit(0)
 	}
 }
 
 func JoinNetwork(ip string, port string) (*kademlia.Kademlia, error) {
-	id := kademlia.NewRandomKademliaID()
+	identity, err := kademlia.LoadOrCreateIdentity(*identityPath, NodeConfig().Puzzle)
+	if err != nil {
+		return nil, err
+	}
+	id := kademlia.NodeID(identity.Public().(ed25519.PublicKey), *idBits/8)
 	contact := kademlia.NewContact(id, ip+":"+port)
 	contact.CalcDistance(id)
 	routingTable := kademlia.NewRoutingTable(contact)
-	bootStrapContact := kademlia.NewContact(kademlia.NewKademliaID("FFFFFFFFF0000000000000000000000000000000)"), "172.20.0.6:8000")
-	routingTable.AddContact(bootStrapContact)
 
 	conn, err := net.ListenPacket("udp", ":"+port)
 	if err != nil {
 		return nil, err
 	}
 
-	return kademlia.NewKademlia(routingTable, conn), nil
+	config := NodeConfig()
+	config.Identity = identity
+	return kademlia.NewKademliaWithConfig(routingTable, conn, config)
 }
 
-func JoinNetworkBootstrap(ip string, port string) (*kademlia.Kademlia, error) {
-	bootStrapContact := kademlia.NewContact(kademlia.NewKademliaID("FFFFFFFFF0000000000000000000000000000000)"), ip+":"+port)
-	bootStrapContact.CalcDistance(bootStrapContact.ID)
-	routingTable := kademlia.NewRoutingTable(bootStrapContact)
-	conn, err := net.ListenPacket("udp", ":"+port)
+// NewCLI returns the CLI of k, which signs MPUT records with the key in
+// the -record-key file
+func NewCLI(k *kademlia.Kademlia) (*cli.CLI, error) {
+	signingKey, err := kademlia.LoadOrCreateIdentity(*recordKeyPath, kademlia.Puzzle{})
 	if err != nil {
 		return nil, err
 	}
+	c := cli.NewCLI(k)
+	c.SetSigningKey(signingKey)
+	return c, nil
+}
+
+// NodeConfig returns the settings of the node picked with the flags
+func NodeConfig() kademlia.Config {
+	return kademlia.Config{
+		Logger:             slog.Default(),
+		ValueTTL:           *valueTTL,
+		DisableCompression: !*compression,
+		RequireSignatures:  *requireSignatures,
+		RequireEncryption:  *requireEncryption,
+		Puzzle:             kademlia.Puzzle{Static: *puzzleStatic, Dynamic: *puzzleDynamic},
+		DisjointPaths:      *disjointPaths,
+		IPLimits: kademlia.IPLimits{
+			PerIPBucket:     *ipLimitBucket,
+			PerSubnetBucket: *subnetLimitBucket,
+			PerIPTable:      *ipLimitTable,
+			PerSubnetTable:  *subnetLimitTable,
+		},
+		Admission: kademlia.AdmissionLimits{
+			PerIP: rate(*rateLimit),
+			PerType: map[string]kademlia.Rate{
+				"FIND_NODE": rate(*lookupRateLimit),
+				"FIND_DATA": rate(*lookupRateLimit),
+			},
+			Pings:       rate(*pingRateLimit),
+			MaxInFlight: *maxInFlight,
+			BanAfter:    *banAfter,
+			BanDuration: *banDuration,
+		},
+	}
+}
+
+// rate returns a limit of perSecond messages that lets bursts of twice as
+// many through
+func rate(perSecond float64) kademlia.Rate {
+	return kademlia.Rate{PerSecond: perSecond, Burst: max(1, int(2*perSecond))}
+}
+
+// SetupLogger makes the logger picked with -log-level and -log-format the
+// default, it writes to stderr so that it stays out of the CLI output
+func SetupLogger() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("invalid -log-level: %s", *logLevel)
+	}
+	options := &slog.HandlerOptions{Level: level}
+	switch *logFormat {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, options)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, options)))
+	default:
+		return fmt.Errorf("invalid -log-format: %s", *logFormat)
+	}
+	return nil
+}
+
+// StartAPI serves the HTTP API in the background unless it is disabled
+func StartAPI(k *kademlia.Kademlia) {
+	if *httpAddr == "" {
+		return
+	}
+	go func() {
+		server := api.NewServer(k)
+		server.SetMinReadyContacts(*readyContacts)
+		if err := server.ListenAndServe(*httpAddr); err != nil {
+			fmt.Println("HTTP API stopped: ", err)
+		}
+	}()
+}
 
-	return kademlia.NewKademlia(routingTable, conn), nil
+// StartControl serves the control API in the background unless it is disabled.
+// A shutdown request closes the node's socket and exits.
+func StartControl(k *kademlia.Kademlia) {
+	if *controlAddr == "" {
+		return
+	}
+	network, address, found := strings.Cut(*controlAddr, ":")
+	if !found {
+		fmt.Println("Invalid -control, expected network:address:", *controlAddr)
+		return
+	}
+	server, err := control.NewServer(k, func() {
+		fmt.Println("Shutting down on request from the control API")
+		k.Network.Close()
+		if network == "unix" {
+			os.Remove(address)
+		}
+		os.Exit(0)
+	}, *controlToken)
+	if err != nil {
+		fmt.Println("Error starting control API: ", err)
+		return
+	}
+	go func() {
+		if err := server.Listen(network, address); err != nil {
+			fmt.Println("Control API stopped: ", err)
+		}
+	}()
+}
+
+// JoinBootstrapNode adds the bootstrap node to the routing table under the
+// ID it signs its messages with, retrying while it is starting up
+func JoinBootstrapNode(k *kademlia.Kademlia) {
+	for attempt := 1; attempt <= bootstrapAttempts; attempt++ {
+		contact, err := k.Bootstrap(bootstrapAddress)
+		if err == nil {
+			fmt.Println("Joined bootstrap node", contact.String())
+			return
+		}
+		fmt.Println("Bootstrap node not reachable: ", err)
+		time.Sleep(time.Second)
+	}
 }
 
 func DoLookUpOnSelf(k *kademlia.Kademlia) {
@@ -0,0 +1,136 @@
+package secret
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"d7024e/files"
+	"d7024e/kademlia"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Scheme is the URI scheme of capabilities
+const Scheme = "kademlia"
+
+var (
+	// ErrNeedsPrivateKey is returned by Get for the capability of a
+	// recipient, which is opened with GetFor
+	ErrNeedsPrivateKey = errors.New("capability is for a recipient, open it with their private key")
+	// ErrWrongRecipient is returned by GetFor when the private key does not
+	// belong to the recipient of the capability
+	ErrWrongRecipient = errors.New("private key does not belong to the recipient of the capability")
+)
+
+// Capability is the key of a sealed value together with the key that
+// opens it. Whoever holds it can fetch and read the value, so it is
+// shared like a password. It is written as kademlia:<hash>?key=<key>.
+//
+// A value sealed for a recipient has a capability that names the public
+// key of the recipient instead, kademlia:<hash>?to=<public key>. It holds
+// no secret: the recipient opens the value with the private key it keeps.
+type Capability struct {
+	Key       kademlia.Multihash
+	Secret    []byte
+	Recipient []byte
+}
+
+// String formats the capability as a URI
+func (capability Capability) String() string {
+	if capability.Recipient != nil {
+		return Scheme + ":" + capability.Key.String() + "?to=" + hex.EncodeToString(capability.Recipient)
+	}
+	return Scheme + ":" + capability.Key.String() + "?key=" + base64.RawURLEncoding.EncodeToString(capability.Secret)
+}
+
+// IsCapability returns true if s looks like a capability URI rather than
+// a plain hash
+func IsCapability(s string) bool {
+	return strings.HasPrefix(s, Scheme+":")
+}
+
+// ParseCapability parses a capability URI
+func ParseCapability(s string) (Capability, error) {
+	uri, err := url.Parse(s)
+	if err != nil || uri.Scheme != Scheme || uri.Opaque == "" {
+		return Capability{}, fmt.Errorf("invalid capability, expected %s:<hash>?key=<key> or %s:<hash>?to=<public key>", Scheme, Scheme)
+	}
+	key, err := kademlia.ParseKey(uri.Opaque)
+	if err != nil {
+		return Capability{}, fmt.Errorf("invalid capability: %w", err)
+	}
+	if to := uri.Query().Get("to"); to != "" {
+		publicKey, err := hex.DecodeString(to)
+		if err != nil {
+			return Capability{}, fmt.Errorf("invalid recipient public key: %w", err)
+		}
+		if _, err := ParseRecipient(publicKey); err != nil {
+			return Capability{}, err
+		}
+		return Capability{Key: key, Recipient: publicKey}, nil
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(uri.Query().Get("key"))
+	if err != nil || len(secret) != KeySize {
+		return Capability{}, fmt.Errorf("invalid capability: %w", ErrInvalidKey)
+	}
+	return Capability{Key: key, Secret: secret}, nil
+}
+
+// Put seals data with a new symmetric key, stores the ciphertext through
+// the files package and returns the capability that reads it back.
+// progress may be nil.
+func Put(node *kademlia.Kademlia, data []byte, hashFunc kademlia.HashFunc, progress files.Progress) (Capability, files.PutResult, error) {
+	key, err := NewKey()
+	if err != nil {
+		return Capability{}, files.PutResult{}, err
+	}
+	sealed, err := Seal(key, data)
+	if err != nil {
+		return Capability{}, files.PutResult{}, err
+	}
+	result, err := files.Put(node, sealed, hashFunc, progress)
+	return Capability{Key: result.Key, Secret: key}, result, err
+}
+
+// PutFor seals data for recipient and stores the ciphertext through the
+// files package. It returns the capability of the recipient, which the
+// recipient opens with GetFor. progress may be nil.
+func PutFor(node *kademlia.Kademlia, recipient *ecdh.PublicKey, data []byte, hashFunc kademlia.HashFunc, progress files.Progress) (Capability, files.PutResult, error) {
+	sealed, err := SealFor(recipient, data)
+	if err != nil {
+		return Capability{}, files.PutResult{}, err
+	}
+	result, err := files.Put(node, sealed, hashFunc, progress)
+	return Capability{Key: result.Key, Recipient: recipient.Bytes()}, result, err
+}
+
+// Get fetches the value capability points at and opens it locally. It
+// also returns the contact the value was found on. progress may be nil.
+func Get(node *kademlia.Kademlia, capability Capability, progress files.Progress) ([]byte, kademlia.Contact, error) {
+	if capability.Recipient != nil {
+		return nil, kademlia.Contact{}, ErrNeedsPrivateKey
+	}
+	sealed, foundOn, err := files.Get(node, capability.Key, progress)
+	if err != nil {
+		return nil, foundOn, err
+	}
+	data, err := Open(capability.Secret, sealed)
+	return data, foundOn, err
+}
+
+// GetFor is Get for a capability of a recipient, the value is opened with
+// privateKey, which must belong to that recipient
+func GetFor(node *kademlia.Kademlia, capability Capability, privateKey *ecdh.PrivateKey, progress files.Progress) ([]byte, kademlia.Contact, error) {
+	if !bytes.Equal(capability.Recipient, privateKey.PublicKey().Bytes()) {
+		return nil, kademlia.Contact{}, ErrWrongRecipient
+	}
+	sealed, foundOn, err := files.Get(node, capability.Key, progress)
+	if err != nil {
+		return nil, foundOn, err
+	}
+	data, err := Open(privateKey.Bytes(), sealed)
+	return data, foundOn, err
+}
@@ -0,0 +1,109 @@
+package secret
+
+import (
+	"bytes"
+	"crypto/rand"
+	"d7024e/files"
+	"d7024e/kademlia"
+	"d7024e/kademlia/kademliatest"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCapability_StringThenParse(t *testing.T) {
+	key, _ := kademlia.Sum([]byte("data1"), kademlia.SHA1)
+	secret, _ := NewKey()
+	capability := Capability{Key: key, Secret: secret}
+
+	uri := capability.String()
+	if !strings.HasPrefix(uri, "kademlia:"+key.String()+"?key=") || !IsCapability(uri) {
+		t.Errorf("Expected a kademlia URI for %s, got %s", key, uri)
+	}
+	parsed, err := ParseCapability(uri)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if parsed.Key.String() != key.String() || !bytes.Equal(parsed.Secret, secret) {
+		t.Errorf("Expected %v, got %v", capability, parsed)
+	}
+}
+
+func TestCapability_RecipientStringThenParse(t *testing.T) {
+	key, _ := kademlia.Sum([]byte("data1"), kademlia.SHA1)
+	recipient, _ := NewRecipientKey()
+	capability := Capability{Key: key, Recipient: recipient.PublicKey().Bytes()}
+
+	parsed, err := ParseCapability(capability.String())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if parsed.Secret != nil || !bytes.Equal(parsed.Recipient, capability.Recipient) {
+		t.Errorf("Expected %v, got %v", capability, parsed)
+	}
+}
+
+func TestParseCapability_Invalid(t *testing.T) {
+	key, _ := kademlia.Sum([]byte("data1"), kademlia.SHA1)
+	for _, invalid := range []string{
+		key.String(),
+		"kademlia:",
+		"kademlia:" + key.String(),
+		"kademlia:" + key.String() + "?key=short",
+		"kademlia:zz?key=" + strings.Repeat("A", 43),
+		"http:" + key.String() + "?key=" + strings.Repeat("A", 43),
+		"kademlia:" + key.String() + "?to=zz",
+		"kademlia:" + key.String() + "?to=00",
+	} {
+		if _, err := ParseCapability(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestPut_ThenGet(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 3)
+	data := make([]byte, 2*files.ChunkSize)
+	rand.Read(data)
+
+	capability, result, err := Put(nodes[0], data, kademlia.SHA1, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Key.String() != capability.Key.String() {
+		t.Errorf("Expected the capability to point at %s, got %s", result.Key, capability.Key)
+	}
+
+	stored, _, err := files.Get(nodes[1], capability.Key, nil)
+	if err != nil || bytes.Equal(stored, data) {
+		t.Errorf("Expected the nodes to hold ciphertext, got %v", err)
+	}
+	opened, _, err := Get(nodes[2], capability, nil)
+	if err != nil || !bytes.Equal(opened, data) {
+		t.Errorf("Expected the value back, got %v", err)
+	}
+}
+
+func TestPutFor_ThenGetFor(t *testing.T) {
+	nodes := kademliatest.NewNetwork(t, 3)
+	recipient, _ := NewRecipientKey()
+	other, _ := NewRecipientKey()
+
+	capability, _, err := PutFor(nodes[0], recipient.PublicKey(), []byte("for you"), kademlia.SHA1, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if capability.Secret != nil || strings.Contains(capability.String(), "key=") {
+		t.Errorf("Expected a capability without a secret, got %s", capability)
+	}
+	if _, _, err := Get(nodes[1], capability, nil); !errors.Is(err, ErrNeedsPrivateKey) {
+		t.Errorf("Expected ErrNeedsPrivateKey, got %v", err)
+	}
+	if _, _, err := GetFor(nodes[1], capability, other, nil); !errors.Is(err, ErrWrongRecipient) {
+		t.Errorf("Expected ErrWrongRecipient, got %v", err)
+	}
+	opened, _, err := GetFor(nodes[1], capability, recipient, nil)
+	if err != nil || string(opened) != "for you" {
+		t.Errorf("Expected for you, got %q (%v)", opened, err)
+	}
+}
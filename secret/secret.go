@@ -0,0 +1,206 @@
+// Package secret encrypts values on the client before they are stored, so
+// that the nodes holding them cannot read them. A value is sealed either
+// with a random symmetric key or for the X25519 public key of a recipient,
+// and the ciphertext is stored under its own hash like any other value.
+// The hash and the key that opens it together form a capability URI, the
+// one of a value sealed for a recipient names its public key instead.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the size of symmetric keys and X25519 private keys
+const KeySize = 32
+
+// Formats of a sealed value, stored in its first byte
+const (
+	formatSymmetric byte = 1
+	formatRecipient byte = 2
+)
+
+// Overhead is how much larger than the plaintext a value sealed for a
+// recipient is, values sealed with a symmetric key are smaller
+const Overhead = 1 + KeySize + nonceSize + tagSize
+
+const (
+	nonceSize = 12
+	tagSize   = 16
+)
+
+var (
+	// ErrInvalidKey is returned for keys that are not KeySize bytes
+	ErrInvalidKey = errors.New("key must be 32 bytes")
+	// ErrDecrypt is returned when a value cannot be opened with the key,
+	// because the key is wrong or the value was altered
+	ErrDecrypt = errors.New("value cannot be decrypted with this key")
+)
+
+// NewKey returns a random symmetric key
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// NewRecipientKey returns a random X25519 private key. Its public key is
+// given to senders, and the private key opens what they seal for it.
+func NewRecipientKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// SaveRecipientKey writes the private key of a recipient to a file at
+// path that only the current user can read. An existing file is kept.
+func SaveRecipientKey(path string, privateKey *ecdh.PrivateKey) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(file, base64.RawURLEncoding.EncodeToString(privateKey.Bytes())); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LoadRecipientKey reads a private key written by SaveRecipientKey
+func LoadRecipientKey(path string) (*ecdh.PrivateKey, error) {
+	encoded, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("%s: %w", path, ErrInvalidKey)
+	}
+	return ecdh.X25519().NewPrivateKey(key)
+}
+
+// ParseRecipient parses the 32 byte X25519 public key of a recipient
+func ParseRecipient(publicKey []byte) (*ecdh.PublicKey, error) {
+	recipient, err := ecdh.X25519().NewPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient public key: %w", err)
+	}
+	return recipient, nil
+}
+
+// Seal encrypts plaintext with the symmetric key using AES-256-GCM
+func Seal(key []byte, plaintext []byte) ([]byte, error) {
+	return seal([]byte{formatSymmetric}, key, plaintext)
+}
+
+// SealFor encrypts plaintext so that only the holder of the private key of
+// recipient can open it. A fresh ephemeral key is agreed with recipient
+// for every value, and it is stored in front of the ciphertext.
+func SealFor(recipient *ecdh.PublicKey, plaintext []byte) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte{formatRecipient}, ephemeral.PublicKey().Bytes()...)
+	return seal(header, deriveKey(shared, ephemeral.PublicKey(), recipient), plaintext)
+}
+
+// Open decrypts a value sealed by Seal or SealFor. key is the symmetric
+// key for the former and the X25519 private key of the recipient for the
+// latter.
+func Open(key []byte, sealed []byte) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	if len(sealed) == 0 {
+		return nil, ErrDecrypt
+	}
+	switch sealed[0] {
+	case formatSymmetric:
+		return open(key, sealed[:1], sealed[1:])
+	case formatRecipient:
+		if len(sealed) < 1+KeySize {
+			return nil, ErrDecrypt
+		}
+		private, err := ecdh.X25519().NewPrivateKey(key)
+		if err != nil {
+			return nil, ErrInvalidKey
+		}
+		ephemeral, err := ecdh.X25519().NewPublicKey(sealed[1 : 1+KeySize])
+		if err != nil {
+			return nil, ErrDecrypt
+		}
+		shared, err := private.ECDH(ephemeral)
+		if err != nil {
+			return nil, ErrDecrypt
+		}
+		return open(deriveKey(shared, ephemeral, private.PublicKey()), sealed[:1+KeySize], sealed[1+KeySize:])
+	default:
+		return nil, fmt.Errorf("%w: unknown format %d", ErrDecrypt, sealed[0])
+	}
+}
+
+// seal appends the nonce and the ciphertext of plaintext to header, which
+// is authenticated along with it
+func seal(header []byte, key []byte, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(append([]byte{}, header...), nonce...)
+	return aead.Seal(sealed, nonce, plaintext, header), nil
+}
+
+// open decrypts the nonce and ciphertext in body that follow header
+func open(key []byte, header []byte, body []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < nonceSize+tagSize {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := aead.Open(nil, body[:nonceSize], body[nonceSize:], header)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveKey turns an X25519 shared secret into the AES key of one value,
+// binding it to both public keys
+func deriveKey(shared []byte, ephemeral *ecdh.PublicKey, recipient *ecdh.PublicKey) []byte {
+	hash := sha256.New()
+	hash.Write([]byte("kademlia secret v1"))
+	hash.Write(shared)
+	hash.Write(ephemeral.Bytes())
+	hash.Write(recipient.Bytes())
+	return hash.Sum(nil)
+}
@@ -0,0 +1,106 @@
+package secret
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSeal_ThenOpen(t *testing.T) {
+	key, _ := NewKey()
+
+	sealed, err := Seal(key, []byte("private value"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if bytes.Contains(sealed, []byte("private value")) {
+		t.Error("Expected the plaintext not to appear in the sealed value")
+	}
+	opened, err := Open(key, sealed)
+	if err != nil || string(opened) != "private value" {
+		t.Errorf("Expected private value, got %q (%v)", opened, err)
+	}
+}
+
+func TestSeal_IsRandomized(t *testing.T) {
+	key, _ := NewKey()
+	first, _ := Seal(key, []byte("private value"))
+	second, _ := Seal(key, []byte("private value"))
+
+	if bytes.Equal(first, second) {
+		t.Error("Expected a fresh nonce for every sealed value")
+	}
+}
+
+func TestOpen_WrongKeyOrTampered(t *testing.T) {
+	key, _ := NewKey()
+	otherKey, _ := NewKey()
+	sealed, _ := Seal(key, []byte("private value"))
+
+	if _, err := Open(otherKey, sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for the wrong key, got %v", err)
+	}
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := Open(key, tampered); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for a tampered value, got %v", err)
+	}
+	if _, err := Open(key[:16], sealed); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey for a short key, got %v", err)
+	}
+	if _, err := Open(key, nil); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for an empty value, got %v", err)
+	}
+}
+
+func TestSealFor_ThenOpen(t *testing.T) {
+	recipient, _ := NewRecipientKey()
+	other, _ := NewRecipientKey()
+
+	sealed, err := SealFor(recipient.PublicKey(), []byte("for you"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(sealed) != len("for you")+Overhead {
+		t.Errorf("Expected %d bytes, got %d", len("for you")+Overhead, len(sealed))
+	}
+	opened, err := Open(recipient.Bytes(), sealed)
+	if err != nil || string(opened) != "for you" {
+		t.Errorf("Expected for you, got %q (%v)", opened, err)
+	}
+	if _, err := Open(other.Bytes(), sealed); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Expected ErrDecrypt for another recipient, got %v", err)
+	}
+}
+
+func TestParseRecipient_Invalid(t *testing.T) {
+	if _, err := ParseRecipient([]byte("short")); err == nil {
+		t.Error("Expected an error for a short public key")
+	}
+}
+
+func TestSaveRecipientKey_ThenLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recipient.key")
+	recipient, _ := NewRecipientKey()
+
+	if err := SaveRecipientKey(path, recipient); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the key file to be readable by its owner only, got %v", info.Mode().Perm())
+	}
+	loaded, err := LoadRecipientKey(path)
+	if err != nil || !loaded.Equal(recipient) {
+		t.Errorf("Expected the saved key back, got %v", err)
+	}
+	if err := SaveRecipientKey(path, recipient); err == nil {
+		t.Error("Expected an existing key file to be kept")
+	}
+
+	os.WriteFile(path+".bad", []byte("short"), 0600)
+	if _, err := LoadRecipientKey(path + ".bad"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey, got %v", err)
+	}
+}